@@ -0,0 +1,287 @@
+package distclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"github.com/docker/distribution/registry/client/auth"
+)
+
+const (
+	// DefaultPingTTL is how long the scheme and auth challenges of a registry
+	// are remembered before it is pinged again
+	DefaultPingTTL = 10 * time.Minute
+
+	// defaultTokenLifetime is the lifetime the token spec tells clients to
+	// assume when the token server does not give one
+	defaultTokenLifetime = 60 * time.Second
+
+	// tokenExpiryMargin is how long before they expire tokens stop being used,
+	// so they do not expire in the middle of a request
+	tokenExpiryMargin = 10 * time.Second
+)
+
+// TokenCache shares bearer tokens between the connections of a dialer, so
+// that pulling the same image again does not go back to the token server
+// until the token expires. Tokens are keyed by realm, service, scope and
+// credentials.
+type TokenCache struct {
+	Clock clock.Clock
+
+	mu     sync.Mutex
+	tokens map[tokenKey]*cachedToken
+}
+
+func NewTokenCache(clock clock.Clock) *TokenCache {
+	return &TokenCache{
+		Clock:  clock,
+		tokens: make(map[tokenKey]*cachedToken),
+	}
+}
+
+type tokenKey struct {
+	realm    string
+	service  string
+	scope    string
+	username string
+	password string
+}
+
+// cachedToken is locked while its token is being fetched. Its token and
+// expiry are only set with both its lock and the lock of the cache held.
+type cachedToken struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// token returns the cached token for key, calling fetch when there is none
+// or it has expired. Concurrent callers for the same key share one fetch.
+func (c *TokenCache) token(key tokenKey, fetch func() (string, time.Duration, error)) (string, error) {
+	entry := c.entry(key)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.token != "" && c.Clock.Now().Before(entry.expiresAt) {
+		return entry.token, nil
+	}
+
+	requestedAt := c.Clock.Now()
+	token, lifetime, err := fetch()
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	entry.token = token
+	entry.expiresAt = requestedAt.Add(lifetime - tokenExpiryMargin)
+	c.mu.Unlock()
+
+	return token, nil
+}
+
+func (c *TokenCache) entry(key tokenKey) *cachedToken {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.tokens[key]; ok {
+		return entry
+	}
+
+	c.prune()
+
+	entry := &cachedToken{}
+	c.tokens[key] = entry
+	return entry
+}
+
+// prune forgets expired tokens. It must be called with mu held.
+func (c *TokenCache) prune() {
+	now := c.Clock.Now()
+
+	for key, entry := range c.tokens {
+		if entry.token != "" && !now.Before(entry.expiresAt) {
+			delete(c.tokens, key)
+		}
+	}
+}
+
+// cachingTokenHandler is an auth.AuthenticationHandler for bearer token
+// challenges which gets its tokens through a TokenCache
+type cachingTokenHandler struct {
+	cache     *TokenCache
+	transport http.RoundTripper
+	creds     auth.CredentialStore
+	scope     string
+}
+
+func newCachingTokenHandler(cache *TokenCache, transport http.RoundTripper, creds auth.CredentialStore, repo string) auth.AuthenticationHandler {
+	return &cachingTokenHandler{
+		cache:     cache,
+		transport: transport,
+		creds:     creds,
+		scope:     fmt.Sprintf("repository:%s:pull", repo),
+	}
+}
+
+func (th *cachingTokenHandler) Scheme() string {
+	return "bearer"
+}
+
+func (th *cachingTokenHandler) AuthorizeRequest(req *http.Request, params map[string]string) error {
+	realm, ok := params["realm"]
+	if !ok {
+		return errors.New("no realm specified for token auth challenge")
+	}
+
+	realmURL, err := url.Parse(realm)
+	if err != nil {
+		return fmt.Errorf("invalid token auth challenge realm: %s", err)
+	}
+
+	username, password := th.creds.Basic(realmURL)
+	key := tokenKey{
+		realm:    realm,
+		service:  params["service"],
+		scope:    th.scope,
+		username: username,
+		password: password,
+	}
+
+	token, err := th.cache.token(key, func() (string, time.Duration, error) {
+		return th.fetchToken(realmURL, key)
+	})
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (th *cachingTokenHandler) fetchToken(realmURL *url.URL, key tokenKey) (string, time.Duration, error) {
+	req, err := http.NewRequest("GET", realmURL.String(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	params := req.URL.Query()
+	if key.service != "" {
+		params.Add("service", key.service)
+	}
+	params.Add("scope", key.scope)
+
+	if key.username != "" && key.password != "" {
+		params.Add("account", key.username)
+		req.SetBasicAuth(key.username, key.password)
+	}
+
+	req.URL.RawQuery = params.Encode()
+
+	resp, err := (&http.Client{Transport: th.transport, Timeout: 15 * time.Second}).Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, &StatusError{Fetching: "token from " + realmURL.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", 0, fmt.Errorf("unable to decode token response: %s", err)
+	}
+
+	token := tr.Token
+	if token == "" {
+		token = tr.AccessToken
+	}
+
+	if token == "" {
+		return "", 0, errors.New("authorization server did not include a token in the response")
+	}
+
+	lifetime := defaultTokenLifetime
+	if tr.ExpiresIn > 0 {
+		lifetime = time.Duration(tr.ExpiresIn) * time.Second
+	}
+
+	return token, lifetime, nil
+}
+
+// PingCache remembers, per registry host, whether it is reached over https or
+// http and which auth challenges it sent, so that dialing a registry does not
+// ping it every time
+type PingCache struct {
+	TTL   time.Duration
+	Clock clock.Clock
+
+	mu    sync.Mutex
+	pings map[string]*cachedPing
+}
+
+func NewPingCache(ttl time.Duration, clock clock.Clock) *PingCache {
+	return &PingCache{
+		TTL:   ttl,
+		Clock: clock,
+		pings: make(map[string]*cachedPing),
+	}
+}
+
+type ping struct {
+	scheme     string
+	challenges auth.ChallengeManager
+}
+
+// cachedPing is locked while its host is being pinged
+type cachedPing struct {
+	mu        sync.Mutex
+	ping      ping
+	expiresAt time.Time
+}
+
+// ping returns the cached ping of host, calling doPing when there is none or
+// it has expired. Concurrent callers for the same host share one ping. Failed
+// pings are not cached.
+func (c *PingCache) ping(logger lager.Logger, host string, doPing func() (ping, error)) (ping, error) {
+	c.mu.Lock()
+	entry, ok := c.pings[host]
+	if !ok {
+		entry = &cachedPing{}
+		c.pings[host] = entry
+	}
+	c.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if c.Clock.Now().Before(entry.expiresAt) {
+		logger.Debug("using-cached-ping", lager.Data{"host": host, "scheme": entry.ping.scheme})
+		return entry.ping, nil
+	}
+
+	p, err := doPing()
+	if err != nil {
+		return ping{}, err
+	}
+
+	entry.ping = p
+	entry.expiresAt = c.Clock.Now().Add(c.TTL)
+
+	return p, nil
+}
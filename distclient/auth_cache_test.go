@@ -0,0 +1,133 @@
+package distclient_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/garden-shed/distclient"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("token and ping caching", func() {
+	var (
+		logger         lager.Logger
+		fakeClock      *fakeclock.FakeClock
+		registry       *fakeRegistry
+		registryServer *httptest.Server
+		tokenServer    *httptest.Server
+		tokenRequests  []*http.Request
+		tokens         *distclient.TokenCache
+		pings          *distclient.PingCache
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		tokenRequests = nil
+		tokenServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenRequests = append(tokenRequests, r)
+			fmt.Fprintf(w, `{"token": "some-token", "expires_in": 300}`)
+		}))
+
+		registry = newFakeRegistry()
+		registry.token = "some-token"
+		registry.tokenRealm = tokenServer.URL + "/token"
+		registry.manifests["some-tag"] = servedManifest{content: mustMarshal(map[string]interface{}{
+			"schemaVersion": 1,
+			"fsLayers":      []map[string]interface{}{},
+			"history":       []map[string]interface{}{},
+		})}
+		registryServer = httptest.NewServer(registry)
+
+		tokens = distclient.NewTokenCache(fakeClock)
+		pings = distclient.NewPingCache(time.Minute, fakeClock)
+	})
+
+	AfterEach(func() {
+		registryServer.Close()
+		tokenServer.Close()
+	})
+
+	getManifest := func(username, password string) {
+		dialer := distclient.NewDialer([]string{hostOf(registryServer)})
+		dialer.Tokens = tokens
+		dialer.Pings = pings
+
+		conn, err := dialer.Dial(logger, hostOf(registryServer), "some/repo", username, password)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = conn.GetManifest(logger, "some-tag")
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("requests a token scoped to the repository", func() {
+		getManifest("", "")
+
+		Expect(tokenRequests).To(HaveLen(1))
+		Expect(tokenRequests[0].URL.Query().Get("service")).To(Equal("fake-registry"))
+		Expect(tokenRequests[0].URL.Query().Get("scope")).To(Equal("repository:some/repo:pull"))
+	})
+
+	It("reuses the token across dials until it expires", func() {
+		getManifest("", "")
+		getManifest("", "")
+		Expect(tokenRequests).To(HaveLen(1))
+
+		fakeClock.Increment(5 * time.Minute)
+
+		getManifest("", "")
+		Expect(tokenRequests).To(HaveLen(2))
+	})
+
+	It("does not share tokens between credentials", func() {
+		getManifest("some-user", "some-password")
+		getManifest("other-user", "other-password")
+		getManifest("some-user", "some-password")
+
+		Expect(tokenRequests).To(HaveLen(2))
+
+		username, password, ok := tokenRequests[1].BasicAuth()
+		Expect(ok).To(BeTrue())
+		Expect(username).To(Equal("other-user"))
+		Expect(password).To(Equal("other-password"))
+	})
+
+	It("pings the registry again only once the ping has expired", func() {
+		getManifest("", "")
+		getManifest("", "")
+		Expect(registry.pings).To(Equal(1))
+
+		fakeClock.Increment(2 * time.Minute)
+
+		getManifest("", "")
+		Expect(registry.pings).To(Equal(2))
+	})
+
+	Context("when the dialer has no caches set", func() {
+		It("creates them on the first dial and shares them with later ones", func() {
+			dialer := distclient.NewDialer([]string{hostOf(registryServer)})
+			dialer.Tokens = nil
+			dialer.Pings = nil
+
+			for i := 0; i < 2; i++ {
+				conn, err := dialer.Dial(logger, hostOf(registryServer), "some/repo", "", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = conn.GetManifest(logger, "some-tag")
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Expect(dialer.Tokens).NotTo(BeNil())
+			Expect(dialer.Pings).NotTo(BeNil())
+			Expect(registry.pings).To(Equal(1))
+			Expect(tokenRequests).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package distclient_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/garden-shed/distclient"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/docker/distribution/digest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GetBlobRangeReader", func() {
+	var (
+		logger   lager.Logger
+		registry *fakeRegistry
+		server   *httptest.Server
+		conn     distclient.Conn
+		blobSum  digest.Digest
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		registry = newFakeRegistry()
+		server = httptest.NewServer(registry)
+
+		blobSum = registry.addBlob([]byte("0123456789"))
+	})
+
+	JustBeforeEach(func() {
+		host := hostOf(server)
+
+		var err error
+		conn, err = distclient.NewDialer([]string{host}).Dial(logger, host, "some/repo", "", "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("returns the blob from the offset onwards", func() {
+		blob, err := conn.GetBlobRangeReader(logger, blobSum, 4)
+		Expect(err).NotTo(HaveOccurred())
+		defer blob.Close()
+
+		Expect(ioutil.ReadAll(blob)).To(Equal([]byte("456789")))
+	})
+
+	Context("when the offset is at the end of the blob", func() {
+		It("returns an empty reader", func() {
+			blob, err := conn.GetBlobRangeReader(logger, blobSum, 10)
+			Expect(err).NotTo(HaveOccurred())
+			defer blob.Close()
+
+			Expect(ioutil.ReadAll(blob)).To(BeEmpty())
+		})
+	})
+
+	Context("when the registry does not support range requests", func() {
+		BeforeEach(func() {
+			registry.ignoreRange = true
+		})
+
+		It("skips to the offset", func() {
+			blob, err := conn.GetBlobRangeReader(logger, blobSum, 4)
+			Expect(err).NotTo(HaveOccurred())
+			defer blob.Close()
+
+			Expect(ioutil.ReadAll(blob)).To(Equal([]byte("456789")))
+		})
+	})
+
+	Context("when the blob does not exist", func() {
+		It("returns an error", func() {
+			_, err := conn.GetBlobRangeReader(logger, "sha256:0000000000000000000000000000000000000000000000000000000000000000", 4)
+			Expect(err).To(MatchError(ContainSubstring("404")))
+		})
+	})
+})
@@ -1,16 +1,21 @@
 package distclient
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/image"
 
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager"
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
@@ -23,16 +28,28 @@ import (
 
 //go:generate counterfeiter -o fake_distclient/fake_conn.go . Conn
 type Conn interface {
-	GetManifest(logger lager.Logger, tag string) (*Manifest, error)
+	GetManifest(logger lager.Logger, reference string) (*Manifest, error)
 	GetBlobReader(logger lager.Logger, d digest.Digest) (io.ReadCloser, error)
+	GetBlobRangeReader(logger lager.Logger, d digest.Digest, offset int64) (io.ReadCloser, error)
 }
 
 type conn struct {
-	client distribution.Repository
+	client    distribution.Repository
+	transport http.RoundTripper
+	baseURL   string
+	repo      string
+	platform  Platform
 }
 
 type Manifest struct {
+	// Digest is the digest of the manifest, or manifest list, the reference
+	// resolved to
+	Digest digest.Digest
 	Layers []Layer
+	// StopSignal is the signal the image asks to be stopped with. The
+	// vendored image config predates it, so it is not on the top layer's
+	// Image.Config.
+	StopSignal string
 }
 
 type Layer struct {
@@ -40,18 +57,81 @@ type Layer struct {
 	StrongID       digest.Digest
 	ParentStrongID digest.Digest
 	Image          image.Image
+
+	// DiffID, if known, is the digest of the uncompressed layer, which its
+	// StrongID is worked out from
+	DiffID digest.Digest
 }
 
 type dialer struct {
 	InsecureRegistryList InsecureRegistryList
+	// Platform selects the image to pull from manifest lists and OCI indexes
+	Platform Platform
+	// Mirrors lists, per registry host, the hosts to try before the registry
+	Mirrors map[string][]string
+	// Keychain, if set, provides the credentials for registries dialed
+	// without any
+	Keychain Keychain
+	// CertsDir, if set, holds per registry CA certificates, client
+	// certificates and TLS versions, see LoadTLSConfig
+	CertsDir string
+	// Tokens and Pings are shared by all connections of the dialer. They
+	// are created on the first dial when they are not set.
+	Tokens *TokenCache
+	Pings  *PingCache
+
+	mu         sync.Mutex
+	transports *transportCache
 }
 
 func NewDialer(insecureRegistries []string) *dialer {
-	return &dialer{InsecureRegistryList(insecureRegistries)}
+	clk := clock.NewClock()
+
+	return &dialer{
+		InsecureRegistryList: InsecureRegistryList(insecureRegistries),
+		Platform:             DefaultPlatform(),
+		Tokens:               NewTokenCache(clk),
+		Pings:                NewPingCache(DefaultPingTTL, clk),
+		transports:           &transportCache{transports: make(map[string]*http.Transport)},
+	}
 }
 
-func (d dialer) Dial(logger lager.Logger, host, repo, username, password string) (Conn, error) {
-	host, transport, err := newTransport(logger, d.InsecureRegistryList, host, repo, username, password)
+func (d *dialer) Dial(logger lager.Logger, host, repo, username, password string) (Conn, error) {
+	mirrors := d.Mirrors[host]
+	if len(mirrors) == 0 {
+		return d.dial(logger, host, repo, username, password)
+	}
+
+	var endpoints []*endpoint
+	for _, mirror := range mirrors {
+		endpoints = append(endpoints, d.endpoint(mirror, repo, "", ""))
+	}
+
+	return &mirroredConn{
+		endpoints: append(endpoints, d.endpoint(host, repo, username, password)),
+	}, nil
+}
+
+func (d *dialer) endpoint(host, repo, username, password string) *endpoint {
+	return &endpoint{
+		host: host,
+		dial: func(logger lager.Logger) (Conn, error) {
+			return d.dial(logger, host, repo, username, password)
+		},
+	}
+}
+
+func (d *dialer) dial(logger lager.Logger, host, repo, username, password string) (Conn, error) {
+	if username == "" && password == "" && d.Keychain != nil {
+		var err error
+		username, password, err = d.Keychain.Credentials(host)
+		if err != nil {
+			// the image may well be public, so try without credentials
+			logger.Error("failed-to-get-default-credentials", err, lager.Data{"host": host})
+		}
+	}
+
+	host, transport, err := d.newTransport(logger, host, repo, username, password)
 	if err != nil {
 		logger.Error("failed-to-construct-transport", err)
 		return nil, err
@@ -63,29 +143,59 @@ func (d dialer) Dial(logger lager.Logger, host, repo, username, password string)
 		return nil, err
 	}
 
-	return &conn{client: repoClient}, nil
+	return &conn{
+		client:    repoClient,
+		transport: transport,
+		baseURL:   host,
+		repo:      repo,
+		platform:  d.Platform,
+	}, nil
 }
 
-func (r *conn) GetManifest(logger lager.Logger, tag string) (*Manifest, error) {
-	manifestService, err := r.client.Manifests(context.TODO())
+// GetManifest fetches the manifest for reference, which is either a tag or a
+// manifest digest. Manifests fetched by digest are verified against it. When
+// reference names a manifest list or OCI index, the manifest for the platform
+// of the dialer is used, but the digest of the list is reported.
+func (r *conn) GetManifest(logger lager.Logger, reference string) (*Manifest, error) {
+	content, mediaType, err := r.getVerifiedManifest(logger, reference)
 	if err != nil {
-		logger.Error("failed-to-construct-manifest-service", err)
 		return nil, err
 	}
 
-	layer, err := manifestService.GetByTag(tag)
+	dgst, err := manifestDigest(mediaType, content)
 	if err != nil {
-		logger.Error("failed-to-get-by-tag", err)
+		logger.Error("failed-to-digest-manifest", err)
 		return nil, err
 	}
 
-	layers, err := toLayers(layer.FSLayers, layer.History)
+	if mediaType == MediaTypeDockerManifestList || mediaType == MediaTypeOCIIndex {
+		platformDigest, err := selectPlatformManifest(content, r.platform)
+		if err != nil {
+			logger.Error("failed-to-select-platform", err)
+			return nil, err
+		}
+
+		logger.Info("selected-platform-manifest", lager.Data{"platform": r.platform.String(), "digest": platformDigest})
+
+		content, mediaType, err = r.getVerifiedManifest(logger, platformDigest.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var m *Manifest
+	switch mediaType {
+	case MediaTypeDockerManifest, MediaTypeOCIManifest:
+		m, err = toSchema2Manifest(logger, content, r.getBlob)
+	default:
+		m, err = toSchema1Manifest(logger, content)
+	}
 	if err != nil {
-		logger.Error("failed-to-get-v1-compat-layers", err)
 		return nil, err
 	}
 
-	return &Manifest{Layers: layers}, nil
+	m.Digest = dgst
+	return m, nil
 }
 
 func (r *conn) GetBlobReader(logger lager.Logger, digest digest.Digest) (io.ReadCloser, error) {
@@ -93,6 +203,44 @@ func (r *conn) GetBlobReader(logger lager.Logger, digest digest.Digest) (io.Read
 	return blobStore.Open(context.TODO(), digest)
 }
 
+// GetBlobRangeReader returns the content of the blob d from offset onwards,
+// so that interrupted downloads can be resumed.
+func (r *conn) GetBlobRangeReader(logger lager.Logger, d digest.Digest, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v2/%s/blobs/%s", r.baseURL, r.repo, d), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	resp, err := (&http.Client{Transport: r.transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// the registry ignored the range, so skip the part we already have
+		logger.Info("range-not-supported", lager.Data{"digest": d, "offset": offset})
+
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// there is nothing left to read
+		resp.Body.Close()
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	default:
+		resp.Body.Close()
+		return nil, &StatusError{Fetching: "blob " + d.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+}
+
 func toLayers(fsl []manifest.FSLayer, history []manifest.History) (r []Layer, err error) {
 	var parent digest.Digest
 	for i := len(fsl) - 1; i >= 0; i-- {
@@ -118,23 +266,60 @@ func toLayers(fsl []manifest.FSLayer, history []manifest.History) (r []Layer, er
 	return
 }
 
-func newTransport(logger lager.Logger, insecureRegistries InsecureRegistryList, host, repo, username, password string) (string, http.RoundTripper, error) {
-	scheme := "https://"
-	baseTransport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		Dial: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).Dial,
-		DisableKeepAlives: true,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: insecureRegistries.AllowInsecure(host),
-		},
+func (d *dialer) newTransport(logger lager.Logger, host, repo, username, password string) (string, http.RoundTripper, error) {
+	tokens, pings, transports := d.caches()
+
+	baseTransport, err := transports.get(host, func() (*tls.Config, error) {
+		return LoadTLSConfig(d.CertsDir, host, d.InsecureRegistryList.AllowInsecure(host))
+	})
+	if err != nil {
+		logger.Error("failed-to-load-tls-config", err, lager.Data{"host": host})
+		return "", nil, err
 	}
 
 	authTransport := transport.NewTransport(baseTransport)
 
+	p, err := pings.ping(logger, host, func() (ping, error) {
+		return pingRegistry(logger, authTransport, d.InsecureRegistryList, host)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	credentialStore := dumbCredentialStore{username, password}
+	tokenHandler := newCachingTokenHandler(tokens, authTransport, credentialStore, repo)
+	basicHandler := auth.NewBasicHandler(credentialStore)
+	authorizer := auth.NewAuthorizer(p.challenges, tokenHandler, basicHandler)
+
+	return p.scheme + host, transport.NewTransport(baseTransport, authorizer), nil
+}
+
+// caches returns the caches shared by the connections of the dialer,
+// creating those that are not set
+func (d *dialer) caches() (*TokenCache, *PingCache, *transportCache) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.Tokens == nil {
+		d.Tokens = NewTokenCache(clock.NewClock())
+	}
+
+	if d.Pings == nil {
+		d.Pings = NewPingCache(DefaultPingTTL, clock.NewClock())
+	}
+
+	if d.transports == nil {
+		d.transports = &transportCache{}
+	}
+
+	return d.Tokens, d.Pings, d.transports
+}
+
+// pingRegistry finds out whether host talks https, or, for insecure
+// registries, http, and which auth challenges it sends
+func pingRegistry(logger lager.Logger, authTransport http.RoundTripper, insecureRegistries InsecureRegistryList, host string) (ping, error) {
+	scheme := "https://"
+
 	pingClient := &http.Client{
 		Transport: authTransport,
 		Timeout:   15 * time.Second,
@@ -143,7 +328,7 @@ func newTransport(logger lager.Logger, insecureRegistries InsecureRegistryList,
 	req, err := http.NewRequest("GET", scheme+host+"/v2/", nil)
 	if err != nil {
 		logger.Error("failed-to-create-ping-request", err)
-		return "", nil, err
+		return ping{}, err
 	}
 
 	challengeManager := auth.NewSimpleChallengeManager()
@@ -153,35 +338,71 @@ func newTransport(logger lager.Logger, insecureRegistries InsecureRegistryList,
 		logger.Error("failed-to-ping-registry", err)
 
 		if !insecureRegistries.AllowInsecure(host) {
-			return "", nil, err
+			return ping{}, err
 		}
 
 		scheme = "http://"
 		req, err = http.NewRequest("GET", scheme+host+"/v2/", nil)
 		if err != nil {
 			logger.Error("failed-to-create-http-ping-request", err)
-			return "", nil, err
+			return ping{}, err
 		}
 
 		resp, err = pingClient.Do(req)
 		if err != nil {
-			return "", nil, err
+			return ping{}, err
 		}
-	} else {
-		defer resp.Body.Close()
+		resp.Body.Close()
 
-		if err := challengeManager.AddResponse(resp); err != nil {
-			logger.Error("failed-to-add-response-to-challenge-manager", err)
-			return "", nil, err
-		}
+		return ping{scheme: scheme, challenges: challengeManager}, nil
 	}
+	defer resp.Body.Close()
 
-	credentialStore := dumbCredentialStore{username, password}
-	tokenHandler := auth.NewTokenHandler(authTransport, credentialStore, repo, "pull")
-	basicHandler := auth.NewBasicHandler(credentialStore)
-	authorizer := auth.NewAuthorizer(challengeManager, tokenHandler, basicHandler)
+	if err := challengeManager.AddResponse(resp); err != nil {
+		logger.Error("failed-to-add-response-to-challenge-manager", err)
+		return ping{}, err
+	}
+
+	return ping{scheme: scheme, challenges: challengeManager}, nil
+}
+
+// transportCache keeps one transport per registry host, so that connections
+// are reused across dials
+type transportCache struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func (c *transportCache) get(host string, tlsConfig func() (*tls.Config, error)) (*http.Transport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t, ok := c.transports[host]; ok {
+		return t, nil
+	}
+
+	if c.transports == nil {
+		c.transports = make(map[string]*http.Transport)
+	}
+
+	config, err := tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).Dial,
+		IdleConnTimeout: 90 * time.Second,
+		TLSClientConfig: config,
+	}
 
-	return scheme + host, transport.NewTransport(baseTransport, authorizer), nil
+	c.transports[host] = t
+	return t, nil
 }
 
 type dumbCredentialStore struct {
@@ -0,0 +1,195 @@
+package distclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"code.cloudfoundry.org/commandrunner"
+)
+
+// DockerHubServerURL is the key docker uses for the Docker Hub in config.json
+// and when asking credential helpers for credentials
+const DockerHubServerURL = "https://index.docker.io/v1/"
+
+// Keychain provides the credentials to use for a registry when none are given
+// with the request
+//
+//go:generate counterfeiter -o fake_distclient/fake_keychain.go . Keychain
+type Keychain interface {
+	Credentials(host string) (username, password string, err error)
+}
+
+// DockerConfig is a Keychain backed by a docker style config.json
+type DockerConfig struct {
+	Auths       map[string]DockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore,omitempty"`
+	CredHelpers map[string]string     `json:"credHelpers,omitempty"`
+
+	// Runner runs the docker-credential-<helper> binaries
+	Runner commandrunner.CommandRunner `json:"-"`
+}
+
+type DockerAuth struct {
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// LoadDockerConfig reads the docker config.json at path. A missing file is an
+// empty config.
+func LoadDockerConfig(path string, runner commandrunner.CommandRunner) (*DockerConfig, error) {
+	config := &DockerConfig{Runner: runner}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(contents, config); err != nil {
+		return nil, fmt.Errorf("parse docker config %s: %s", path, err)
+	}
+
+	return config, nil
+}
+
+// Credentials returns the credentials for host from its credential helper,
+// or the default credential store, falling back to the auths entries of the
+// config. Hosts without credentials get empty ones.
+func (c *DockerConfig) Credentials(host string) (string, string, error) {
+	keys := configKeys(host)
+
+	for configKey, helper := range c.CredHelpers {
+		if matchesHost(configKey, keys) {
+			return c.helperCredentials(helper, serverURL(host))
+		}
+	}
+
+	if c.CredsStore != "" {
+		username, password, err := c.helperCredentials(c.CredsStore, serverURL(host))
+		if err != nil || username != "" || password != "" {
+			return username, password, err
+		}
+	}
+
+	for configKey, auth := range c.Auths {
+		if matchesHost(configKey, keys) {
+			return auth.credentials(configKey)
+		}
+	}
+
+	return "", "", nil
+}
+
+func (a DockerAuth) credentials(key string) (string, string, error) {
+	if a.Auth == "" {
+		return a.Username, a.Password, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return "", "", fmt.Errorf("decode auth for %s: %s", key, err)
+	}
+
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("decode auth for %s: expected username:password", key)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+type helperCredentials struct {
+	Username string
+	Secret   string
+}
+
+// helperCredentialsNotFound is printed by credential helpers that have no
+// credentials for a server
+const helperCredentialsNotFound = "credentials not found in native keychain"
+
+func (c *DockerConfig) helperCredentials(helper, server string) (string, string, error) {
+	stdout := new(bytes.Buffer)
+
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = stdout
+
+	if err := c.Runner.Run(cmd); err != nil {
+		if strings.Contains(stdout.String(), helperCredentialsNotFound) {
+			return "", "", nil
+		}
+
+		return "", "", fmt.Errorf("docker-credential-%s get %s: %s: %s", helper, server, err, strings.TrimSpace(stdout.String()))
+	}
+
+	var creds helperCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", fmt.Errorf("docker-credential-%s get %s: parse output: %s", helper, server, err)
+	}
+
+	if creds.Username == "<token>" {
+		// identity tokens need an OAuth2 exchange the registry client does
+		// not support, so they are treated as no credentials
+		return "", "", nil
+	}
+
+	return creds.Username, creds.Secret, nil
+}
+
+// configKeys returns the keys host may be stored under in config.json
+func configKeys(host string) []string {
+	if isDockerHub(host) {
+		return []string{"index.docker.io", "docker.io", "registry-1.docker.io"}
+	}
+
+	return []string{host}
+}
+
+func matchesHost(configKey string, keys []string) bool {
+	for _, key := range keys {
+		if normalizeConfigKey(configKey) == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeConfigKey strips the scheme and path off keys such as
+// https://index.docker.io/v1/
+func normalizeConfigKey(key string) string {
+	key = strings.TrimPrefix(key, "https://")
+	key = strings.TrimPrefix(key, "http://")
+
+	if i := strings.Index(key, "/"); i >= 0 {
+		key = key[:i]
+	}
+
+	return key
+}
+
+func serverURL(host string) string {
+	if isDockerHub(host) {
+		return DockerHubServerURL
+	}
+
+	return host
+}
+
+func isDockerHub(host string) bool {
+	switch host {
+	case "registry-1.docker.io", "index.docker.io", "docker.io":
+		return true
+	}
+
+	return false
+}
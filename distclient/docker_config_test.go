@@ -0,0 +1,272 @@
+package distclient_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/commandrunner/fake_command_runner"
+	"code.cloudfoundry.org/garden-shed/distclient"
+	"code.cloudfoundry.org/garden-shed/distclient/fake_distclient"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DockerConfig", func() {
+	var (
+		tmpDir     string
+		configPath string
+		runner     *fake_command_runner.FakeCommandRunner
+		config     *distclient.DockerConfig
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "docker-config")
+		Expect(err).NotTo(HaveOccurred())
+
+		configPath = filepath.Join(tmpDir, "config.json")
+		runner = fake_command_runner.New()
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		config, err = distclient.LoadDockerConfig(configPath, runner)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	writeConfig := func(contents string) {
+		Expect(ioutil.WriteFile(configPath, []byte(contents), 0600)).To(Succeed())
+	}
+
+	whenHelperRuns := func(helper string, fn func(cmd *exec.Cmd) error) {
+		runner.WhenRunning(fake_command_runner.CommandSpec{
+			Path: "docker-credential-" + helper,
+			Args: []string{"get"},
+		}, fn)
+	}
+
+	Context("when the config does not exist", func() {
+		It("has no credentials", func() {
+			username, password, err := config.Credentials("my.registry.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(BeEmpty())
+			Expect(password).To(BeEmpty())
+		})
+	})
+
+	Context("when the config is not valid JSON", func() {
+		It("returns an error", func() {
+			writeConfig("{")
+
+			_, err := distclient.LoadDockerConfig(configPath, runner)
+			Expect(err).To(MatchError(ContainSubstring("parse docker config")))
+		})
+	})
+
+	Context("when the config has auths", func() {
+		BeforeEach(func() {
+			writeConfig(`{
+				"auths": {
+					"my.registry.io": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:pass:word")) + `"},
+					"https://other.registry.io/v2/": {"username": "other-user", "password": "other-pass"},
+					"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("hub-user:hub-pass")) + `"},
+					"broken.registry.io": {"auth": "not base64!"}
+				}
+			}`)
+		})
+
+		It("decodes base64 auth entries", func() {
+			username, password, err := config.Credentials("my.registry.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(Equal("user"))
+			Expect(password).To(Equal("pass:word"))
+		})
+
+		It("matches entries stored as URLs", func() {
+			username, password, err := config.Credentials("other.registry.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(Equal("other-user"))
+			Expect(password).To(Equal("other-pass"))
+		})
+
+		It("uses the index.docker.io entry for the Docker Hub registry", func() {
+			username, password, err := config.Credentials("registry-1.docker.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(Equal("hub-user"))
+			Expect(password).To(Equal("hub-pass"))
+		})
+
+		It("has no credentials for other registries", func() {
+			username, password, err := config.Credentials("unknown.registry.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(BeEmpty())
+			Expect(password).To(BeEmpty())
+		})
+
+		It("returns an error when an entry cannot be decoded", func() {
+			_, _, err := config.Credentials("broken.registry.io")
+			Expect(err).To(MatchError(ContainSubstring("decode auth for broken.registry.io")))
+		})
+	})
+
+	Context("when the config has a credential helper for the registry", func() {
+		var (
+			helperOutput string
+			helperErr    error
+		)
+
+		BeforeEach(func() {
+			writeConfig(`{
+				"auths": {"my.registry.io": {"username": "auths-user", "password": "auths-pass"}},
+				"credHelpers": {"my.registry.io": "my-helper"}
+			}`)
+
+			helperOutput = `{"ServerURL": "my.registry.io", "Username": "helper-user", "Secret": "helper-secret"}`
+			helperErr = nil
+
+			whenHelperRuns("my-helper", func(cmd *exec.Cmd) error {
+				server, err := ioutil.ReadAll(cmd.Stdin)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(server)).To(Equal("my.registry.io"))
+
+				cmd.Stdout.Write([]byte(helperOutput))
+				return helperErr
+			})
+		})
+
+		It("gets the credentials from the helper", func() {
+			username, password, err := config.Credentials("my.registry.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(Equal("helper-user"))
+			Expect(password).To(Equal("helper-secret"))
+		})
+
+		Context("and the helper fails", func() {
+			BeforeEach(func() {
+				helperOutput = "keychain is locked"
+				helperErr = errors.New("exit status 1")
+			})
+
+			It("returns an error", func() {
+				_, _, err := config.Credentials("my.registry.io")
+				Expect(err).To(MatchError(ContainSubstring("keychain is locked")))
+			})
+		})
+	})
+
+	Context("when the config has a default credential store", func() {
+		BeforeEach(func() {
+			writeConfig(`{
+				"auths": {"my.registry.io": {"username": "auths-user", "password": "auths-pass"}},
+				"credsStore": "my-store"
+			}`)
+		})
+
+		It("asks the store with the Docker Hub server URL for the Docker Hub", func() {
+			whenHelperRuns("my-store", func(cmd *exec.Cmd) error {
+				server, err := ioutil.ReadAll(cmd.Stdin)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(server)).To(Equal(distclient.DockerHubServerURL))
+
+				_, err = cmd.Stdout.Write([]byte(`{"Username": "hub-user", "Secret": "hub-secret"}`))
+				return err
+			})
+
+			username, password, err := config.Credentials("registry-1.docker.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(Equal("hub-user"))
+			Expect(password).To(Equal("hub-secret"))
+		})
+
+		It("falls back to the auths when the store has no credentials", func() {
+			whenHelperRuns("my-store", func(cmd *exec.Cmd) error {
+				cmd.Stdout.Write([]byte("credentials not found in native keychain\n"))
+				return errors.New("exit status 1")
+			})
+
+			username, password, err := config.Credentials("my.registry.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(Equal("auths-user"))
+			Expect(password).To(Equal("auths-pass"))
+		})
+	})
+})
+
+var _ = Describe("dialing with a keychain", func() {
+	var (
+		logger   lager.Logger
+		registry *fakeRegistry
+		server   *httptest.Server
+		keychain *fake_distclient.FakeKeychain
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+
+		registry = newFakeRegistry()
+		registry.username = "some-user"
+		registry.password = "some-password"
+		registry.manifests["some-tag"] = servedManifest{content: mustMarshal(map[string]interface{}{
+			"schemaVersion": 1,
+			"fsLayers":      []map[string]interface{}{},
+			"history":       []map[string]interface{}{},
+		})}
+		server = httptest.NewServer(registry)
+
+		keychain = new(fake_distclient.FakeKeychain)
+		keychain.CredentialsReturns("some-user", "some-password", nil)
+	})
+
+	dial := func(username, password string) (distclient.Conn, error) {
+		dialer := distclient.NewDialer([]string{hostOf(server)})
+		dialer.Keychain = keychain
+		return dialer.Dial(logger, hostOf(server), "some/repo", username, password)
+	}
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("uses the credentials from the keychain when none are given", func() {
+		conn, err := dial("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = conn.GetManifest(logger, "some-tag")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(keychain.CredentialsCallCount()).To(Equal(1))
+		Expect(keychain.CredentialsArgsForCall(0)).To(Equal(hostOf(server)))
+	})
+
+	It("prefers the credentials it is given", func() {
+		_, err := dial("some-user", "some-password")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(keychain.CredentialsCallCount()).To(Equal(0))
+	})
+
+	Context("when the keychain fails", func() {
+		BeforeEach(func() {
+			keychain.CredentialsReturns("", "", errors.New("boom"))
+		})
+
+		It("dials without credentials", func() {
+			conn, err := dial("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = conn.GetManifest(logger, "some-tag")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
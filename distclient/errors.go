@@ -0,0 +1,41 @@
+package distclient
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution/digest"
+)
+
+// StatusError is returned when the registry answers with an unexpected HTTP
+// status, so that callers can tell missing images from unavailable registries
+type StatusError struct {
+	Fetching   string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status fetching %s: %s", e.Fetching, e.Status)
+}
+
+// DigestMismatchError is returned when content fetched from the registry does
+// not match the digest it was fetched by
+type DigestMismatchError struct {
+	Content  string
+	Expected digest.Digest
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("%s digest verification failed", e.Content)
+}
+
+// LayoutError is returned when an image layout is not valid or does not hold
+// the image asked for
+type LayoutError struct {
+	Path   string
+	Reason string
+}
+
+func (e *LayoutError) Error() string {
+	return fmt.Sprintf("image layout %s: %s", e.Path, e.Reason)
+}
@@ -11,11 +11,11 @@ import (
 )
 
 type FakeConn struct {
-	GetManifestStub        func(logger lager.Logger, tag string) (*distclient.Manifest, error)
+	GetManifestStub        func(logger lager.Logger, reference string) (*distclient.Manifest, error)
 	getManifestMutex       sync.RWMutex
 	getManifestArgsForCall []struct {
-		logger lager.Logger
-		tag    string
+		logger    lager.Logger
+		reference string
 	}
 	getManifestReturns struct {
 		result1 *distclient.Manifest
@@ -39,21 +39,36 @@ type FakeConn struct {
 		result1 io.ReadCloser
 		result2 error
 	}
+	GetBlobRangeReaderStub        func(logger lager.Logger, d digest.Digest, offset int64) (io.ReadCloser, error)
+	getBlobRangeReaderMutex       sync.RWMutex
+	getBlobRangeReaderArgsForCall []struct {
+		logger lager.Logger
+		d      digest.Digest
+		offset int64
+	}
+	getBlobRangeReaderReturns struct {
+		result1 io.ReadCloser
+		result2 error
+	}
+	getBlobRangeReaderReturnsOnCall map[int]struct {
+		result1 io.ReadCloser
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeConn) GetManifest(logger lager.Logger, tag string) (*distclient.Manifest, error) {
+func (fake *FakeConn) GetManifest(logger lager.Logger, reference string) (*distclient.Manifest, error) {
 	fake.getManifestMutex.Lock()
 	ret, specificReturn := fake.getManifestReturnsOnCall[len(fake.getManifestArgsForCall)]
 	fake.getManifestArgsForCall = append(fake.getManifestArgsForCall, struct {
-		logger lager.Logger
-		tag    string
-	}{logger, tag})
-	fake.recordInvocation("GetManifest", []interface{}{logger, tag})
+		logger    lager.Logger
+		reference string
+	}{logger, reference})
+	fake.recordInvocation("GetManifest", []interface{}{logger, reference})
 	fake.getManifestMutex.Unlock()
 	if fake.GetManifestStub != nil {
-		return fake.GetManifestStub(logger, tag)
+		return fake.GetManifestStub(logger, reference)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -70,7 +85,7 @@ func (fake *FakeConn) GetManifestCallCount() int {
 func (fake *FakeConn) GetManifestArgsForCall(i int) (lager.Logger, string) {
 	fake.getManifestMutex.RLock()
 	defer fake.getManifestMutex.RUnlock()
-	return fake.getManifestArgsForCall[i].logger, fake.getManifestArgsForCall[i].tag
+	return fake.getManifestArgsForCall[i].logger, fake.getManifestArgsForCall[i].reference
 }
 
 func (fake *FakeConn) GetManifestReturns(result1 *distclient.Manifest, result2 error) {
@@ -147,6 +162,59 @@ func (fake *FakeConn) GetBlobReaderReturnsOnCall(i int, result1 io.ReadCloser, r
 	}{result1, result2}
 }
 
+func (fake *FakeConn) GetBlobRangeReader(logger lager.Logger, d digest.Digest, offset int64) (io.ReadCloser, error) {
+	fake.getBlobRangeReaderMutex.Lock()
+	ret, specificReturn := fake.getBlobRangeReaderReturnsOnCall[len(fake.getBlobRangeReaderArgsForCall)]
+	fake.getBlobRangeReaderArgsForCall = append(fake.getBlobRangeReaderArgsForCall, struct {
+		logger lager.Logger
+		d      digest.Digest
+		offset int64
+	}{logger, d, offset})
+	fake.recordInvocation("GetBlobRangeReader", []interface{}{logger, d, offset})
+	fake.getBlobRangeReaderMutex.Unlock()
+	if fake.GetBlobRangeReaderStub != nil {
+		return fake.GetBlobRangeReaderStub(logger, d, offset)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.getBlobRangeReaderReturns.result1, fake.getBlobRangeReaderReturns.result2
+}
+
+func (fake *FakeConn) GetBlobRangeReaderCallCount() int {
+	fake.getBlobRangeReaderMutex.RLock()
+	defer fake.getBlobRangeReaderMutex.RUnlock()
+	return len(fake.getBlobRangeReaderArgsForCall)
+}
+
+func (fake *FakeConn) GetBlobRangeReaderArgsForCall(i int) (lager.Logger, digest.Digest, int64) {
+	fake.getBlobRangeReaderMutex.RLock()
+	defer fake.getBlobRangeReaderMutex.RUnlock()
+	return fake.getBlobRangeReaderArgsForCall[i].logger, fake.getBlobRangeReaderArgsForCall[i].d, fake.getBlobRangeReaderArgsForCall[i].offset
+}
+
+func (fake *FakeConn) GetBlobRangeReaderReturns(result1 io.ReadCloser, result2 error) {
+	fake.GetBlobRangeReaderStub = nil
+	fake.getBlobRangeReaderReturns = struct {
+		result1 io.ReadCloser
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeConn) GetBlobRangeReaderReturnsOnCall(i int, result1 io.ReadCloser, result2 error) {
+	fake.GetBlobRangeReaderStub = nil
+	if fake.getBlobRangeReaderReturnsOnCall == nil {
+		fake.getBlobRangeReaderReturnsOnCall = make(map[int]struct {
+			result1 io.ReadCloser
+			result2 error
+		})
+	}
+	fake.getBlobRangeReaderReturnsOnCall[i] = struct {
+		result1 io.ReadCloser
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeConn) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -154,6 +222,8 @@ func (fake *FakeConn) Invocations() map[string][][]interface{} {
 	defer fake.getManifestMutex.RUnlock()
 	fake.getBlobReaderMutex.RLock()
 	defer fake.getBlobReaderMutex.RUnlock()
+	fake.getBlobRangeReaderMutex.RLock()
+	defer fake.getBlobRangeReaderMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -0,0 +1,522 @@
+package distclient
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/image"
+)
+
+// AnnotationRefName names the manifests of an OCI image layout
+const AnnotationRefName = "org.opencontainers.image.ref.name"
+
+// LayoutDialer opens images stored on the local filesystem, either as an OCI
+// image layout or as written by docker save, in a directory or in a tar
+// archive. The host has to be empty and the repository is the absolute path
+// of the layout without its leading slash, as in oci:///path/to/layout.
+type LayoutDialer struct {
+	// Platform selects the image to use from indexes listing several
+	Platform Platform
+}
+
+func (d *LayoutDialer) Dial(logger lager.Logger, host, repo, _, _ string) (Conn, error) {
+	if host != "" {
+		return nil, &LayoutError{Path: host + "/" + repo, Reason: "layouts have to be given by absolute path, as in oci:///path/to/layout"}
+	}
+
+	return OpenLayout(logger, "/"+repo, d.Platform)
+}
+
+// OpenLayout opens the image layout at path, which is a directory or an
+// uncompressed tar archive of one
+func OpenLayout(logger lager.Logger, layoutPath string, platform Platform) (Conn, error) {
+	logger = logger.Session("open-layout", lager.Data{"path": layoutPath})
+
+	info, err := os.Stat(layoutPath)
+	if err != nil {
+		return nil, &LayoutError{Path: layoutPath, Reason: err.Error()}
+	}
+
+	var files layoutFiles
+	if info.IsDir() {
+		files = dirFiles(layoutPath)
+	} else {
+		if files, err = indexTar(layoutPath); err != nil {
+			logger.Error("failed-to-index-archive", err)
+			return nil, &LayoutError{Path: layoutPath, Reason: err.Error()}
+		}
+	}
+
+	return &layoutConn{
+		path:      layoutPath,
+		files:     files,
+		platform:  platform,
+		blobPaths: make(map[digest.Digest]string),
+	}, nil
+}
+
+type layoutConn struct {
+	path     string
+	files    layoutFiles
+	platform Platform
+
+	// docker save archives keep layers under paths of their own rather than
+	// by digest
+	blobPathsMu sync.Mutex
+	blobPaths   map[digest.Digest]string
+}
+
+// dockerSaveManifest is an entry of the manifest.json written by docker save
+type dockerSaveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+func (c *layoutConn) GetManifest(logger lager.Logger, reference string) (*Manifest, error) {
+	logger = logger.Session("get-layout-manifest", lager.Data{"path": c.path, "reference": reference})
+
+	index, err := c.readFile("index.json")
+	if err == nil {
+		return c.ociManifest(logger, reference, index)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	manifests, err := c.readFile("manifest.json")
+	if os.IsNotExist(err) {
+		return nil, c.error("neither index.json nor manifest.json found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.dockerSaveManifest(logger, reference, manifests)
+}
+
+func (c *layoutConn) GetBlobReader(logger lager.Logger, d digest.Digest) (io.ReadCloser, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	r, _, err := c.files.Open(c.blobPath(d))
+	if os.IsNotExist(err) {
+		return nil, c.error(fmt.Sprintf("blob %s not found", d))
+	}
+
+	return r, err
+}
+
+func (c *layoutConn) GetBlobRangeReader(logger lager.Logger, d digest.Digest, offset int64) (io.ReadCloser, error) {
+	r, err := c.GetBlobReader(logger, d)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, r, offset); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (c *layoutConn) ociManifest(logger lager.Logger, reference string, index []byte) (*Manifest, error) {
+	var list manifestList
+	if err := json.Unmarshal(index, &list); err != nil {
+		return nil, c.error(fmt.Sprintf("parse index.json: %s", err))
+	}
+
+	desc, err := c.selectReference(list, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := c.readBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := manifestMediaType(desc.MediaType, content)
+	if mediaType == MediaTypeDockerManifestList || mediaType == MediaTypeOCIIndex {
+		platformDigest, err := selectPlatformManifest(content, c.platform)
+		if err != nil {
+			logger.Error("failed-to-select-platform", err)
+			return nil, err
+		}
+
+		logger.Info("selected-platform-manifest", lager.Data{"platform": c.platform.String(), "digest": platformDigest})
+
+		if content, err = c.readBlob(platformDigest); err != nil {
+			return nil, err
+		}
+
+		mediaType = manifestMediaType("", content)
+	}
+
+	if mediaType != MediaTypeDockerManifest && mediaType != MediaTypeOCIManifest {
+		return nil, c.error(fmt.Sprintf("unsupported manifest type %s", mediaType))
+	}
+
+	m, err := toSchema2Manifest(logger, content, c.readFileBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Digest = desc.Digest
+	return m, nil
+}
+
+// selectReference finds the manifest with the given digest or name in an
+// index. The default tag, latest, picks the only manifest of an index that
+// does not name its manifests.
+func (c *layoutConn) selectReference(list manifestList, reference string) (descriptor, error) {
+	if d, err := digest.ParseDigest(reference); err == nil {
+		for _, m := range list.Manifests {
+			if m.Digest == d {
+				return m.descriptor, nil
+			}
+		}
+
+		return descriptor{Digest: d}, nil
+	}
+
+	for _, m := range list.Manifests {
+		if m.Annotations[AnnotationRefName] == reference {
+			return m.descriptor, nil
+		}
+	}
+
+	if reference == "latest" && len(list.Manifests) == 1 && list.Manifests[0].Annotations[AnnotationRefName] == "" {
+		return list.Manifests[0].descriptor, nil
+	}
+
+	return descriptor{}, c.error(fmt.Sprintf("no manifest named %s", reference))
+}
+
+func (c *layoutConn) dockerSaveManifest(logger lager.Logger, reference string, content []byte) (*Manifest, error) {
+	var manifests []dockerSaveManifest
+	if err := json.Unmarshal(content, &manifests); err != nil {
+		return nil, c.error(fmt.Sprintf("parse manifest.json: %s", err))
+	}
+
+	entry, err := c.selectRepoTag(manifests, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	configContent, err := c.readFile(entry.Config)
+	if os.IsNotExist(err) {
+		return nil, c.error(fmt.Sprintf("config %s not found", entry.Config))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	configDigest, err := savedConfigDigest(entry.Config)
+	if err != nil {
+		return nil, c.error(err.Error())
+	}
+
+	if err := verifyContent("image config", configDigest, configContent); err != nil {
+		return nil, err
+	}
+
+	config, err := parseImageConfig(configContent)
+	if err != nil {
+		logger.Error("failed-to-parse-image-config", err)
+		return nil, err
+	}
+
+	if len(config.RootFS.DiffIDs) != len(entry.Layers) {
+		return nil, fmt.Errorf("image config has %d diff ids but the manifest has %d layers", len(config.RootFS.DiffIDs), len(entry.Layers))
+	}
+
+	var layers []Layer
+	var parent digest.Digest
+	for i, layerPath := range entry.Layers {
+		// docker save writes layers uncompressed, so they are addressed by
+		// their diff IDs
+		diffID := config.RootFS.DiffIDs[i]
+		c.setBlobPath(diffID, layerPath)
+
+		size, err := c.fileSize(layerPath)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := chainID(parent, diffID)
+		if err != nil {
+			return nil, err
+		}
+
+		img := image.Image{Size: size}
+		if i == len(entry.Layers)-1 {
+			img = config.Image
+			img.Size = size
+		}
+
+		layers = append(layers, Layer{
+			BlobSum:        diffID,
+			StrongID:       id,
+			ParentStrongID: parent,
+			Image:          img,
+			DiffID:         diffID,
+		})
+
+		parent = id
+	}
+
+	return &Manifest{Layers: layers, StopSignal: config.StopSignal}, nil
+}
+
+// selectRepoTag finds the image tagged with reference, either in full as
+// busybox:latest or just the tag. The default tag, latest, picks the only
+// image of an archive that has no tags.
+func (c *layoutConn) selectRepoTag(manifests []dockerSaveManifest, reference string) (dockerSaveManifest, error) {
+	for _, m := range manifests {
+		for _, repoTag := range m.RepoTags {
+			if repoTag == reference || repoTag[strings.LastIndex(repoTag, ":")+1:] == reference {
+				return m, nil
+			}
+		}
+	}
+
+	if reference == "latest" && len(manifests) == 1 && len(manifests[0].RepoTags) == 0 {
+		return manifests[0], nil
+	}
+
+	return dockerSaveManifest{}, c.error(fmt.Sprintf("no image tagged %s", reference))
+}
+
+// savedConfigDigest returns the digest of a config written by docker save,
+// which is named after it, as in <hex>.json or blobs/sha256/<hex>
+func savedConfigDigest(configPath string) (digest.Digest, error) {
+	hex := strings.TrimSuffix(path.Base(configPath), ".json")
+
+	algorithm := path.Base(path.Dir(configPath))
+	if algorithm == "." || algorithm == "/" {
+		algorithm = string(digest.SHA256)
+	}
+
+	d := digest.NewDigestFromHex(algorithm, hex)
+	if err := d.Validate(); err != nil {
+		return "", fmt.Errorf("config %s is not named after its digest: %s", configPath, err)
+	}
+
+	return d, nil
+}
+
+func (c *layoutConn) setBlobPath(d digest.Digest, p string) {
+	c.blobPathsMu.Lock()
+	defer c.blobPathsMu.Unlock()
+
+	c.blobPaths[d] = p
+}
+
+func (c *layoutConn) blobPath(d digest.Digest) string {
+	c.blobPathsMu.Lock()
+	defer c.blobPathsMu.Unlock()
+
+	if p, ok := c.blobPaths[d]; ok {
+		return p
+	}
+
+	return path.Join("blobs", string(d.Algorithm()), d.Hex())
+}
+
+// readBlob reads and verifies a blob stored by digest
+func (c *layoutConn) readBlob(d digest.Digest) ([]byte, error) {
+	content, err := c.readFileBlob(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyContent("blob "+d.String(), d, content); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+func (c *layoutConn) readFileBlob(d digest.Digest) ([]byte, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	content, err := c.readFile(c.blobPath(d))
+	if os.IsNotExist(err) {
+		return nil, c.error(fmt.Sprintf("blob %s not found", d))
+	}
+
+	return content, err
+}
+
+func (c *layoutConn) readFile(name string) ([]byte, error) {
+	r, _, err := c.files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+func (c *layoutConn) fileSize(name string) (int64, error) {
+	r, size, err := c.files.Open(name)
+	if os.IsNotExist(err) {
+		return 0, c.error(fmt.Sprintf("%s not found", name))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	r.Close()
+	return size, nil
+}
+
+func (c *layoutConn) error(reason string) error {
+	return &LayoutError{Path: c.path, Reason: reason}
+}
+
+// layoutFiles opens the files of a layout by slash separated path, whether
+// it is a directory or a tar archive
+type layoutFiles interface {
+	Open(name string) (io.ReadCloser, int64, error)
+}
+
+type dirFiles string
+
+func (d dirFiles) Open(name string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(filepath.Join(string(d), filepath.FromSlash(path.Clean("/"+name))))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+
+	return f, info.Size(), nil
+}
+
+// maxTarLinks bounds how many links are followed to find a file in an archive
+const maxTarLinks = 16
+
+// tarFiles reads files straight out of an uncompressed tar archive, without
+// extracting it, at the offsets found when it was indexed
+type tarFiles struct {
+	path    string
+	entries map[string]tarEntry
+}
+
+type tarEntry struct {
+	offset int64
+	size   int64
+	link   string
+}
+
+func indexTar(archivePath string) (*tarFiles, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	magic, err := bufio.NewReader(f).Peek(6)
+	if err == nil && (bytes.HasPrefix(magic, []byte{0x1f, 0x8b}) || bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00})) {
+		return nil, fmt.Errorf("compressed archives are not supported")
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	files := &tarFiles{path: archivePath, entries: make(map[string]tarEntry)}
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			// the tar reader does not read ahead, so the file is where the
+			// archive has been read up to
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+
+			files.entries[name] = tarEntry{offset: offset, size: hdr.Size}
+		case tar.TypeSymlink:
+			target := hdr.Linkname
+			if !path.IsAbs(target) {
+				target = path.Join(path.Dir(name), target)
+			}
+
+			files.entries[name] = tarEntry{link: path.Clean("/" + target)}
+		case tar.TypeLink:
+			files.entries[name] = tarEntry{link: path.Clean("/" + hdr.Linkname)}
+		}
+	}
+
+	return files, nil
+}
+
+func (t *tarFiles) Open(name string) (io.ReadCloser, int64, error) {
+	name = path.Clean("/" + name)
+
+	entry, ok := t.entries[name]
+	for links := 0; ok && entry.link != ""; links++ {
+		if links == maxTarLinks {
+			return nil, 0, fmt.Errorf("too many links resolving %s", name)
+		}
+
+		entry, ok = t.entries[entry.link]
+	}
+
+	if !ok {
+		return nil, 0, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &sectionReadCloser{
+		SectionReader: io.NewSectionReader(f, entry.offset, entry.size),
+		file:          f,
+	}, entry.size, nil
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (s *sectionReadCloser) Close() error {
+	return s.file.Close()
+}
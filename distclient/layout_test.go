@@ -0,0 +1,344 @@
+package distclient_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/garden-shed/distclient"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/docker/distribution/digest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Image layouts", func() {
+	var (
+		logger    lager.Logger
+		layoutDir string
+		platform  distclient.Platform
+
+		layerTars [][]byte
+		diffIDs   []digest.Digest
+	)
+
+	writeFile := func(name string, content []byte) {
+		path := filepath.Join(layoutDir, filepath.FromSlash(name))
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, content, 0644)).To(Succeed())
+	}
+
+	writeBlob := func(content []byte) digest.Digest {
+		d, err := digest.FromBytes(content)
+		Expect(err).NotTo(HaveOccurred())
+
+		writeFile("blobs/sha256/"+d.Hex(), content)
+		return d
+	}
+
+	imageConfig := func() []byte {
+		return mustMarshal(map[string]interface{}{
+			"architecture": "amd64",
+			"os":           "linux",
+			"config": map[string]interface{}{
+				"Env":        []string{"PATH=/bin"},
+				"StopSignal": "SIGQUIT",
+			},
+			"rootfs": map[string]interface{}{
+				"type":     "layers",
+				"diff_ids": diffIDs,
+			},
+		})
+	}
+
+	open := func(path string) distclient.Conn {
+		conn, err := distclient.OpenLayout(logger, path, platform)
+		Expect(err).NotTo(HaveOccurred())
+		return conn
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		platform = distclient.Platform{OS: "linux", Architecture: "amd64"}
+
+		var err error
+		layoutDir, err = ioutil.TempDir("", "layout")
+		Expect(err).NotTo(HaveOccurred())
+
+		layerTars = [][]byte{
+			tarOf(map[string]string{"bottom": "bottom-layer"}),
+			tarOf(map[string]string{"top": "top-layer"}),
+		}
+
+		diffIDs = nil
+		for _, l := range layerTars {
+			d, err := digest.FromBytes(l)
+			Expect(err).NotTo(HaveOccurred())
+			diffIDs = append(diffIDs, d)
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(layoutDir)).To(Succeed())
+	})
+
+	Describe("OCI image layouts", func() {
+		var (
+			manifestDigest digest.Digest
+			blobSums       []digest.Digest
+			index          map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			blobSums = []digest.Digest{
+				writeBlob(gzipOf(layerTars[0])),
+				writeBlob(gzipOf(layerTars[1])),
+			}
+
+			configDigest := writeBlob(imageConfig())
+
+			manifestDigest = writeBlob(mustMarshal(map[string]interface{}{
+				"schemaVersion": 2,
+				"mediaType":     distclient.MediaTypeOCIManifest,
+				"config":        map[string]interface{}{"digest": configDigest, "size": 10},
+				"layers": []map[string]interface{}{
+					{"digest": blobSums[0], "size": 12},
+					{"digest": blobSums[1], "size": 9},
+				},
+			}))
+
+			writeFile("oci-layout", []byte(`{"imageLayoutVersion": "1.0.0"}`))
+			index = map[string]interface{}{
+				"schemaVersion": 2,
+				"manifests": []map[string]interface{}{
+					{
+						"mediaType":   distclient.MediaTypeOCIManifest,
+						"digest":      manifestDigest,
+						"size":        100,
+						"annotations": map[string]string{distclient.AnnotationRefName: "v1"},
+					},
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			writeFile("index.json", mustMarshal(index))
+		})
+
+		itReadsTheImage := func(layoutPath func() string) {
+			It("returns the layers of the named manifest", func() {
+				manifest, err := open(layoutPath()).GetManifest(logger, "v1")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(manifest.Digest).To(Equal(manifestDigest))
+				Expect(manifest.StopSignal).To(Equal("SIGQUIT"))
+				Expect(manifest.Layers).To(HaveLen(2))
+				Expect(manifest.Layers[0].BlobSum).To(Equal(blobSums[0]))
+				Expect(manifest.Layers[0].StrongID).To(Equal(diffIDs[0]))
+				Expect(manifest.Layers[0].DiffID).To(Equal(diffIDs[0]))
+				Expect(manifest.Layers[1].ParentStrongID).To(Equal(diffIDs[0]))
+				Expect(manifest.Layers[1].Image.Config.Env).To(Equal([]string{"PATH=/bin"}))
+			})
+
+			It("returns the blobs of the layers", func() {
+				conn := open(layoutPath())
+
+				r, err := conn.GetBlobReader(logger, blobSums[1])
+				Expect(err).NotTo(HaveOccurred())
+				defer r.Close()
+
+				Expect(ioutil.ReadAll(r)).To(Equal(gzipOf(layerTars[1])))
+			})
+
+			It("finds manifests by digest", func() {
+				manifest, err := open(layoutPath()).GetManifest(logger, manifestDigest.String())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.Layers).To(HaveLen(2))
+			})
+
+			It("fails for names it does not have", func() {
+				_, err := open(layoutPath()).GetManifest(logger, "v2")
+				Expect(err).To(BeAssignableToTypeOf(&distclient.LayoutError{}))
+			})
+		}
+
+		Context("in a directory", func() {
+			itReadsTheImage(func() string { return layoutDir })
+		})
+
+		Context("in a tar archive", func() {
+			var archive string
+
+			JustBeforeEach(func() {
+				archive = tarDir(layoutDir)
+			})
+
+			AfterEach(func() {
+				Expect(os.Remove(archive)).To(Succeed())
+			})
+
+			itReadsTheImage(func() string { return archive })
+		})
+
+		Context("when the only manifest is not named", func() {
+			BeforeEach(func() {
+				index["manifests"] = []map[string]interface{}{
+					{"mediaType": distclient.MediaTypeOCIManifest, "digest": manifestDigest, "size": 100},
+				}
+			})
+
+			It("uses it for the default tag", func() {
+				manifest, err := open(layoutDir).GetManifest(logger, "latest")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.Digest).To(Equal(manifestDigest))
+			})
+		})
+
+		Context("when the manifest does not match its digest", func() {
+			JustBeforeEach(func() {
+				writeFile("blobs/sha256/"+manifestDigest.Hex(), []byte(`{"schemaVersion": 2}`))
+			})
+
+			It("fails", func() {
+				_, err := open(layoutDir).GetManifest(logger, "v1")
+				Expect(err).To(BeAssignableToTypeOf(&distclient.DigestMismatchError{}))
+			})
+		})
+	})
+
+	Describe("docker save archives", func() {
+		var archive string
+
+		BeforeEach(func() {
+			configContent := imageConfig()
+			configDigest, err := digest.FromBytes(configContent)
+			Expect(err).NotTo(HaveOccurred())
+
+			writeFile(configDigest.Hex()+".json", configContent)
+			writeFile("bottom/layer.tar", layerTars[0])
+			writeFile("top/layer.tar", layerTars[1])
+			writeFile("manifest.json", mustMarshal([]map[string]interface{}{
+				{
+					"Config":   configDigest.Hex() + ".json",
+					"RepoTags": []string{"busybox:1.24"},
+					"Layers":   []string{"bottom/layer.tar", "top/layer.tar"},
+				},
+			}))
+		})
+
+		JustBeforeEach(func() {
+			archive = tarDir(layoutDir)
+		})
+
+		AfterEach(func() {
+			Expect(os.Remove(archive)).To(Succeed())
+		})
+
+		It("returns the layers of the tagged image, addressed by diff ID", func() {
+			manifest, err := open(archive).GetManifest(logger, "1.24")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(manifest.Layers).To(HaveLen(2))
+			Expect(manifest.Layers[0].BlobSum).To(Equal(diffIDs[0]))
+			Expect(manifest.Layers[1].BlobSum).To(Equal(diffIDs[1]))
+			Expect(manifest.Layers[1].DiffID).To(Equal(diffIDs[1]))
+			Expect(manifest.Layers[1].ParentStrongID).To(Equal(diffIDs[0]))
+			Expect(manifest.Layers[1].Image.Size).To(BeEquivalentTo(len(layerTars[1])))
+			Expect(manifest.StopSignal).To(Equal("SIGQUIT"))
+		})
+
+		It("returns the layer tars as blobs", func() {
+			conn := open(archive)
+
+			_, err := conn.GetManifest(logger, "busybox:1.24")
+			Expect(err).NotTo(HaveOccurred())
+
+			r, err := conn.GetBlobReader(logger, diffIDs[0])
+			Expect(err).NotTo(HaveOccurred())
+			defer r.Close()
+
+			Expect(ioutil.ReadAll(r)).To(Equal(layerTars[0]))
+		})
+
+		It("fails for tags it does not have", func() {
+			_, err := open(archive).GetManifest(logger, "latest")
+			Expect(err).To(MatchError(ContainSubstring("no image tagged latest")))
+		})
+	})
+
+	It("refuses compressed archives", func() {
+		archive := filepath.Join(layoutDir, "layout.tar.gz")
+		Expect(ioutil.WriteFile(archive, gzipOf(tarOf(map[string]string{"index.json": "{}"})), 0644)).To(Succeed())
+
+		_, err := distclient.OpenLayout(logger, archive, platform)
+		Expect(err).To(MatchError(ContainSubstring("compressed archives are not supported")))
+	})
+
+	It("refuses layouts on other hosts", func() {
+		_, err := (&distclient.LayoutDialer{}).Dial(logger, "some-host", "some/layout", "", "")
+		Expect(err).To(BeAssignableToTypeOf(&distclient.LayoutError{}))
+	})
+})
+
+func tarOf(files map[string]string) []byte {
+	buf := new(bytes.Buffer)
+
+	tw := tar.NewWriter(buf)
+	for name, content := range files {
+		Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})).To(Succeed())
+		_, err := tw.Write([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(tw.Close()).To(Succeed())
+
+	return buf.Bytes()
+}
+
+func gzipOf(content []byte) []byte {
+	buf := new(bytes.Buffer)
+
+	gw := gzip.NewWriter(buf)
+	_, err := gw.Write(content)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(gw.Close()).To(Succeed())
+
+	return buf.Bytes()
+}
+
+// tarDir archives the contents of dir into a temporary file
+func tarDir(dir string) string {
+	f, err := ioutil.TempFile("", "layout-archive")
+	Expect(err).NotTo(HaveOccurred())
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	Expect(filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if err := tw.WriteHeader(&tar.Header{Name: filepath.ToSlash(rel), Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+
+		_, err = tw.Write(content)
+		return err
+	})).To(Succeed())
+	Expect(tw.Close()).To(Succeed())
+
+	return f.Name()
+}
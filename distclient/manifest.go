@@ -0,0 +1,360 @@
+package distclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/docker/image"
+	"golang.org/x/net/context"
+)
+
+const (
+	MediaTypeDockerSchema1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeDockerSchema1Signed = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeDockerManifest      = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeOCIManifest         = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerManifestList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIIndex            = "application/vnd.oci.image.index.v1+json"
+)
+
+// acceptedManifestTypes are sent to the registry in order of preference
+var acceptedManifestTypes = []string{
+	MediaTypeOCIIndex,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifest,
+	MediaTypeDockerSchema1Signed,
+	MediaTypeDockerSchema1,
+}
+
+type descriptor struct {
+	MediaType string        `json:"mediaType"`
+	Size      int64         `json:"size"`
+	Digest    digest.Digest `json:"digest"`
+}
+
+type schema2Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+}
+
+type manifestList struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	MediaType     string `json:"mediaType"`
+	Manifests     []struct {
+		descriptor
+		Platform    Platform          `json:"platform"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	} `json:"manifests"`
+}
+
+type imageConfig struct {
+	image.Image
+
+	RootFS struct {
+		Type    string          `json:"type"`
+		DiffIDs []digest.Digest `json:"diff_ids"`
+	} `json:"rootfs"`
+
+	StopSignal string `json:"-"`
+}
+
+// stopSignal reads Config.StopSignal out of a v1 compatible image config
+func stopSignal(content []byte) (string, error) {
+	var config struct {
+		Config struct {
+			StopSignal string
+		} `json:"config"`
+	}
+
+	if err := json.Unmarshal(content, &config); err != nil {
+		return "", err
+	}
+
+	return config.Config.StopSignal, nil
+}
+
+func (r *conn) getVerifiedManifest(logger lager.Logger, reference string) ([]byte, string, error) {
+	content, mediaType, err := r.getRawManifest(logger, reference)
+	if err != nil {
+		logger.Error("failed-to-get-manifest", err)
+		return nil, "", err
+	}
+
+	if dgst, err := digest.ParseDigest(reference); err == nil {
+		if err := verifyManifestDigest(dgst, mediaType, content); err != nil {
+			logger.Error("failed-to-verify-manifest", err)
+			return nil, "", err
+		}
+	}
+
+	return content, mediaType, nil
+}
+
+func (r *conn) getRawManifest(logger lager.Logger, reference string) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v2/%s/manifests/%s", r.baseURL, r.repo, reference), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, mediaType := range acceptedManifestTypes {
+		req.Header.Add("Accept", mediaType)
+	}
+
+	resp, err := (&http.Client{Transport: r.transport}).Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", &StatusError{Fetching: "manifest " + reference, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mediaType := manifestMediaType(resp.Header.Get("Content-Type"), content)
+	logger.Debug("got-manifest", lager.Data{"mediaType": mediaType})
+
+	return content, mediaType, nil
+}
+
+// manifestMediaType prefers the mediaType declared in the manifest itself,
+// since some registries serve every manifest as application/json.
+func manifestMediaType(contentType string, content []byte) string {
+	var versioned struct {
+		manifest.Versioned
+		MediaType string          `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+	}
+
+	if err := json.Unmarshal(content, &versioned); err == nil {
+		if versioned.SchemaVersion == 1 {
+			return MediaTypeDockerSchema1
+		}
+
+		if versioned.MediaType != "" {
+			return versioned.MediaType
+		}
+	}
+
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(contentType)
+
+	switch contentType {
+	case MediaTypeDockerManifest, MediaTypeDockerManifestList, MediaTypeOCIManifest, MediaTypeOCIIndex:
+		return contentType
+	}
+
+	if versioned.SchemaVersion == 2 {
+		// the mediaType field is optional in OCI manifests and indexes
+		if versioned.Manifests != nil {
+			return MediaTypeOCIIndex
+		}
+
+		return MediaTypeOCIManifest
+	}
+
+	return contentType
+}
+
+// selectPlatformManifest returns the digest of the first manifest in a
+// manifest list or OCI index that can run on platform.
+func selectPlatformManifest(content []byte, platform Platform) (digest.Digest, error) {
+	var list manifestList
+	if err := json.Unmarshal(content, &list); err != nil {
+		return "", err
+	}
+
+	for _, m := range list.Manifests {
+		if platform.Matches(m.Platform) {
+			return m.Digest, nil
+		}
+	}
+
+	return "", fmt.Errorf("no manifest for platform %s in manifest list", platform.withDefaults())
+}
+
+func toSchema1Manifest(logger lager.Logger, content []byte) (*Manifest, error) {
+	var signed manifest.SignedManifest
+	if err := json.Unmarshal(content, &signed); err != nil {
+		logger.Error("failed-to-parse-schema1-manifest", err)
+		return nil, err
+	}
+
+	layers, err := toLayers(signed.FSLayers, signed.History)
+	if err != nil {
+		logger.Error("failed-to-get-v1-compat-layers", err)
+		return nil, err
+	}
+
+	var signal string
+	if len(signed.History) > 0 {
+		// the first history entry is the config of the top layer
+		if signal, err = stopSignal([]byte(signed.History[0].V1Compatibility)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Manifest{Layers: layers, StopSignal: signal}, nil
+}
+
+// toSchema2Manifest converts a docker schema2 or OCI manifest. getBlob
+// returns the content of the image config, which is verified here.
+func toSchema2Manifest(logger lager.Logger, content []byte, getBlob func(digest.Digest) ([]byte, error)) (*Manifest, error) {
+	var m schema2Manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		logger.Error("failed-to-parse-schema2-manifest", err)
+		return nil, err
+	}
+
+	config, err := getImageConfig(m.Config.Digest, getBlob)
+	if err != nil {
+		logger.Error("failed-to-get-image-config", err)
+		return nil, err
+	}
+
+	if len(config.RootFS.DiffIDs) != len(m.Layers) {
+		return nil, fmt.Errorf("image config has %d diff ids but the manifest has %d layers", len(config.RootFS.DiffIDs), len(m.Layers))
+	}
+
+	var layers []Layer
+	var parent digest.Digest
+	for i, l := range m.Layers {
+		diffID := config.RootFS.DiffIDs[i]
+		id, err := chainID(parent, diffID)
+		if err != nil {
+			return nil, err
+		}
+
+		// the image config describes the whole image, so it belongs to the top layer
+		img := image.Image{Size: l.Size}
+		if i == len(m.Layers)-1 {
+			img = config.Image
+			img.Size = l.Size
+		}
+
+		layers = append(layers, Layer{
+			BlobSum:        l.Digest,
+			StrongID:       id,
+			ParentStrongID: parent,
+			Image:          img,
+			DiffID:         diffID,
+		})
+
+		parent = id
+	}
+
+	return &Manifest{Layers: layers, StopSignal: config.StopSignal}, nil
+}
+
+func (r *conn) getBlob(d digest.Digest) ([]byte, error) {
+	return r.client.Blobs(context.TODO()).Get(context.TODO(), d)
+}
+
+func getImageConfig(d digest.Digest, getBlob func(digest.Digest) ([]byte, error)) (*imageConfig, error) {
+	content, err := getBlob(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyContent("image config", d, content); err != nil {
+		return nil, err
+	}
+
+	return parseImageConfig(content)
+}
+
+func parseImageConfig(content []byte) (*imageConfig, error) {
+	var config imageConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if config.StopSignal, err = stopSignal(content); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func verifyContent(what string, d digest.Digest, content []byte) error {
+	verifier, err := digest.NewDigestVerifier(d)
+	if err != nil {
+		return err
+	}
+
+	if _, err := verifier.Write(content); err != nil {
+		return err
+	}
+
+	if !verifier.Verified() {
+		return &DigestMismatchError{Content: what, Expected: d}
+	}
+
+	return nil
+}
+
+// verifyManifestDigest checks a manifest fetched by digest against that
+// digest. Signed schema1 manifests are addressed by their payload, i.e.
+// without the signatures the registry adds when serving them.
+func verifyManifestDigest(expected digest.Digest, mediaType string, content []byte) error {
+	content, err := manifestPayload(mediaType, content)
+	if err != nil {
+		return err
+	}
+
+	return verifyContent("manifest", expected, content)
+}
+
+// manifestDigest returns the digest the manifest is addressed by
+func manifestDigest(mediaType string, content []byte) (digest.Digest, error) {
+	payload, err := manifestPayload(mediaType, content)
+	if err != nil {
+		return "", err
+	}
+
+	return digest.FromBytes(payload)
+}
+
+func manifestPayload(mediaType string, content []byte) ([]byte, error) {
+	if mediaType != MediaTypeDockerSchema1 {
+		return content, nil
+	}
+
+	var signed manifest.SignedManifest
+	if err := json.Unmarshal(content, &signed); err != nil {
+		return nil, err
+	}
+
+	if payload, err := signed.Payload(); err == nil {
+		return payload, nil
+	}
+
+	return content, nil
+}
+
+// chainID computes the OCI chain ID of a layer from the chain ID of its
+// parent and its own diff ID, so that the same stack of layers is always
+// registered in the cake under the same ID.
+func chainID(parent, diffID digest.Digest) (digest.Digest, error) {
+	if parent == "" {
+		return diffID, nil
+	}
+
+	return digest.FromBytes([]byte(parent.String() + " " + diffID.String()))
+}
@@ -0,0 +1,464 @@
+package distclient_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+
+	"code.cloudfoundry.org/garden-shed/distclient"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/docker/distribution/digest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("schema2 and OCI manifests", func() {
+	var (
+		logger     lager.Logger
+		registry   *fakeRegistry
+		server     *httptest.Server
+		conn       distclient.Conn
+		diffIDs    []digest.Digest
+		blobSums   []digest.Digest
+		configDgst digest.Digest
+		platform   distclient.Platform
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		registry = newFakeRegistry()
+		server = httptest.NewServer(registry)
+		platform = distclient.Platform{OS: "linux", Architecture: "amd64"}
+
+		diffIDs = []digest.Digest{
+			"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		}
+
+		blobSums = []digest.Digest{
+			registry.addBlob([]byte("bottom-layer")),
+			registry.addBlob([]byte("top-layer")),
+		}
+
+		configDgst = registry.addBlob(mustMarshal(map[string]interface{}{
+			"architecture": "amd64",
+			"os":           "linux",
+			"config": map[string]interface{}{
+				"Env":        []string{"PATH=/bin", "FOO=bar"},
+				"Volumes":    map[string]struct{}{"/data": struct{}{}},
+				"StopSignal": "SIGQUIT",
+			},
+			"rootfs": map[string]interface{}{
+				"type":     "layers",
+				"diff_ids": diffIDs,
+			},
+		}))
+	})
+
+	JustBeforeEach(func() {
+		host := hostOf(server)
+
+		dialer := distclient.NewDialer([]string{host})
+		dialer.Platform = platform
+
+		var err error
+		conn, err = dialer.Dial(logger, host, "some/repo", "", "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	schema2Manifest := func(mediaType string) []byte {
+		m := map[string]interface{}{
+			"schemaVersion": 2,
+			"config": map[string]interface{}{
+				"mediaType": "application/vnd.docker.container.image.v1+json",
+				"digest":    configDgst,
+				"size":      10,
+			},
+			"layers": []map[string]interface{}{
+				{"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "digest": blobSums[0], "size": 12},
+				{"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "digest": blobSums[1], "size": 9},
+			},
+		}
+
+		if mediaType != "" {
+			m["mediaType"] = mediaType
+		}
+
+		return mustMarshal(m)
+	}
+
+	itReturnsTheLayers := func() {
+		It("returns the layers bottom to top", func() {
+			manifest, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(manifest.Layers).To(HaveLen(2))
+			Expect(manifest.Layers[0].BlobSum).To(Equal(blobSums[0]))
+			Expect(manifest.Layers[1].BlobSum).To(Equal(blobSums[1]))
+			Expect(manifest.Layers[0].Image.Size).To(BeEquivalentTo(12))
+			Expect(manifest.Layers[1].Image.Size).To(BeEquivalentTo(9))
+		})
+
+		It("uses chain IDs as the strong IDs of the layers", func() {
+			manifest, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			topChainID, err := digest.FromBytes([]byte(diffIDs[0].String() + " " + diffIDs[1].String()))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(manifest.Layers[0].StrongID).To(Equal(diffIDs[0]))
+			Expect(manifest.Layers[0].ParentStrongID).To(BeEquivalentTo(""))
+			Expect(manifest.Layers[1].StrongID).To(Equal(topChainID))
+			Expect(manifest.Layers[1].ParentStrongID).To(Equal(diffIDs[0]))
+		})
+
+		It("gives the layers the diff IDs of the image config, to verify them against", func() {
+			manifest, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(manifest.Layers[0].DiffID).To(Equal(diffIDs[0]))
+			Expect(manifest.Layers[1].DiffID).To(Equal(diffIDs[1]))
+		})
+
+		It("puts the image config on the top layer", func() {
+			manifest, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(manifest.Layers[0].Image.Config).To(BeNil())
+			Expect(manifest.Layers[1].Image.Config.Env).To(Equal([]string{"PATH=/bin", "FOO=bar"}))
+			Expect(manifest.Layers[1].Image.Config.Volumes).To(HaveKey("/data"))
+		})
+
+		It("returns the stop signal of the image", func() {
+			manifest, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(manifest.StopSignal).To(Equal("SIGQUIT"))
+		})
+	}
+
+	Context("when the registry serves a docker schema2 manifest", func() {
+		BeforeEach(func() {
+			registry.manifests["some-tag"] = servedManifest{
+				contentType: distclient.MediaTypeDockerManifest,
+				content:     schema2Manifest(distclient.MediaTypeDockerManifest),
+			}
+		})
+
+		itReturnsTheLayers()
+
+		It("asks for schema2 and OCI manifests", func() {
+			_, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(registry.manifestAccept).To(ContainElement(distclient.MediaTypeDockerManifest))
+			Expect(registry.manifestAccept).To(ContainElement(distclient.MediaTypeOCIManifest))
+		})
+
+		Context("when the image config does not match its digest", func() {
+			BeforeEach(func() {
+				registry.blobs[configDgst] = []byte(`{"rootfs":{}}`)
+			})
+
+			It("returns an error", func() {
+				_, err := conn.GetManifest(logger, "some-tag")
+				Expect(err).To(MatchError("image config digest verification failed"))
+			})
+		})
+
+		Context("when the image config has the wrong number of diff ids", func() {
+			BeforeEach(func() {
+				diffIDs = diffIDs[:1]
+				configDgst = registry.addBlob(mustMarshal(map[string]interface{}{
+					"rootfs": map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+				}))
+
+				registry.manifests["some-tag"] = servedManifest{
+					contentType: distclient.MediaTypeDockerManifest,
+					content:     schema2Manifest(distclient.MediaTypeDockerManifest),
+				}
+			})
+
+			It("returns an error", func() {
+				_, err := conn.GetManifest(logger, "some-tag")
+				Expect(err).To(MatchError(ContainSubstring("has 1 diff ids but the manifest has 2 layers")))
+			})
+		})
+	})
+
+	Context("when the registry serves an OCI manifest without a mediaType field", func() {
+		BeforeEach(func() {
+			registry.manifests["some-tag"] = servedManifest{
+				contentType: distclient.MediaTypeOCIManifest,
+				content:     schema2Manifest(""),
+			}
+		})
+
+		itReturnsTheLayers()
+	})
+
+	Context("when the manifest is fetched by digest", func() {
+		var manifestDigest digest.Digest
+
+		BeforeEach(func() {
+			content := schema2Manifest(distclient.MediaTypeDockerManifest)
+
+			var err error
+			manifestDigest, err = digest.FromBytes(content)
+			Expect(err).NotTo(HaveOccurred())
+
+			registry.manifests[manifestDigest.String()] = servedManifest{
+				contentType: distclient.MediaTypeDockerManifest,
+				content:     content,
+			}
+		})
+
+		It("returns the layers", func() {
+			manifest, err := conn.GetManifest(logger, manifestDigest.String())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.Layers).To(HaveLen(2))
+		})
+
+		It("returns the digest of the manifest", func() {
+			manifest, err := conn.GetManifest(logger, manifestDigest.String())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.Digest).To(Equal(manifestDigest))
+		})
+
+		Context("when the registry serves a different manifest", func() {
+			BeforeEach(func() {
+				registry.manifests[manifestDigest.String()] = servedManifest{
+					contentType: distclient.MediaTypeOCIManifest,
+					content:     schema2Manifest(""),
+				}
+			})
+
+			It("returns an error", func() {
+				_, err := conn.GetManifest(logger, manifestDigest.String())
+				Expect(err).To(MatchError(ContainSubstring("manifest digest verification failed")))
+			})
+		})
+	})
+
+	Context("when the registry serves a manifest list", func() {
+		var amd64Digest, arm64Digest digest.Digest
+
+		BeforeEach(func() {
+			amd64Digest = registry.addManifest(distclient.MediaTypeDockerManifest, schema2Manifest(distclient.MediaTypeDockerManifest))
+
+			arm64Config := registry.addBlob(mustMarshal(map[string]interface{}{
+				"rootfs": map[string]interface{}{"type": "layers", "diff_ids": diffIDs[:1]},
+			}))
+			arm64Digest = registry.addManifest(distclient.MediaTypeDockerManifest, mustMarshal(map[string]interface{}{
+				"schemaVersion": 2,
+				"mediaType":     distclient.MediaTypeDockerManifest,
+				"config":        map[string]interface{}{"digest": arm64Config},
+				"layers":        []map[string]interface{}{{"digest": blobSums[0], "size": 12}},
+			}))
+
+			registry.manifests["some-tag"] = servedManifest{
+				contentType: distclient.MediaTypeDockerManifestList,
+				content: mustMarshal(map[string]interface{}{
+					"schemaVersion": 2,
+					"mediaType":     distclient.MediaTypeDockerManifestList,
+					"manifests": []map[string]interface{}{
+						{
+							"digest":   arm64Digest,
+							"platform": map[string]string{"os": "linux", "architecture": "arm64", "variant": "v8"},
+						},
+						{
+							"digest":   amd64Digest,
+							"platform": map[string]string{"os": "linux", "architecture": "amd64"},
+						},
+					},
+				}),
+			}
+		})
+
+		It("uses the manifest matching the platform", func() {
+			manifest, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.Layers).To(HaveLen(2))
+		})
+
+		It("returns the digest of the manifest list", func() {
+			manifest, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			listDigest, err := digest.FromBytes(registry.manifests["some-tag"].content)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.Digest).To(Equal(listDigest))
+		})
+
+		It("asks for manifest lists and OCI indexes", func() {
+			_, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(registry.manifestAccept).To(ContainElement(distclient.MediaTypeDockerManifestList))
+			Expect(registry.manifestAccept).To(ContainElement(distclient.MediaTypeOCIIndex))
+		})
+
+		Context("when a different platform is configured", func() {
+			BeforeEach(func() {
+				platform = distclient.Platform{OS: "linux", Architecture: "arm64"}
+			})
+
+			It("uses the manifest for that platform", func() {
+				manifest, err := conn.GetManifest(logger, "some-tag")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.Layers).To(HaveLen(1))
+			})
+		})
+
+		Context("when no manifest matches the platform", func() {
+			BeforeEach(func() {
+				platform = distclient.Platform{OS: "linux", Architecture: "s390x"}
+			})
+
+			It("returns an error", func() {
+				_, err := conn.GetManifest(logger, "some-tag")
+				Expect(err).To(MatchError("no manifest for platform linux/s390x in manifest list"))
+			})
+		})
+	})
+
+	Context("when the manifest does not exist", func() {
+		It("returns an error", func() {
+			_, err := conn.GetManifest(logger, "no-such-tag")
+			Expect(err).To(MatchError(ContainSubstring("404")))
+		})
+	})
+})
+
+type servedManifest struct {
+	contentType string
+	content     []byte
+}
+
+type fakeRegistry struct {
+	manifests      map[string]servedManifest
+	blobs          map[digest.Digest][]byte
+	manifestAccept []string
+	requests       int
+	ignoreRange    bool
+
+	// username and password, if set, are required as basic auth
+	username string
+	password string
+
+	// token, if set, is required as a bearer token issued by tokenRealm
+	token      string
+	tokenRealm string
+	pings      int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		manifests: map[string]servedManifest{},
+		blobs:     map[digest.Digest][]byte{},
+	}
+}
+
+func (f *fakeRegistry) addBlob(content []byte) digest.Digest {
+	d, err := digest.FromBytes(content)
+	Expect(err).NotTo(HaveOccurred())
+
+	f.blobs[d] = content
+	return d
+}
+
+func (f *fakeRegistry) addManifest(contentType string, content []byte) digest.Digest {
+	d, err := digest.FromBytes(content)
+	Expect(err).NotTo(HaveOccurred())
+
+	f.manifests[d.String()] = servedManifest{contentType: contentType, content: content}
+	return d
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var reference string
+	f.requests++
+
+	if f.username != "" {
+		if username, password, ok := r.BasicAuth(); !ok || username != f.username || password != f.password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fake-registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if r.URL.Path == "/v2/" {
+		f.pings++
+	}
+
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="fake-registry"`, f.tokenRealm))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case scan(r.URL.Path, "/v2/some/repo/manifests/%s", &reference):
+		f.manifestAccept = r.Header["Accept"]
+
+		m, ok := f.manifests[reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", m.contentType)
+		w.Write(m.content)
+	case scan(r.URL.Path, "/v2/some/repo/blobs/%s", &reference):
+		blob, ok := f.blobs[digest.Digest(reference)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var offset int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset); err == nil && !f.ignoreRange {
+			if offset >= len(blob) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+
+			w.Header().Set("Content-Length", strconv.Itoa(len(blob)-offset))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(blob[offset:])
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		w.Write(blob)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func scan(path, format string, reference *string) bool {
+	n, err := fmt.Sscanf(path, format, reference)
+	return err == nil && n == 1
+}
+
+func hostOf(server *httptest.Server) string {
+	u, err := url.Parse(server.URL)
+	Expect(err).NotTo(HaveOccurred())
+	return u.Host
+}
+
+func mustMarshal(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	Expect(err).NotTo(HaveOccurred())
+	return b
+}
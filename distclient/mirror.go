@@ -0,0 +1,92 @@
+package distclient
+
+import (
+	"io"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/docker/distribution/digest"
+)
+
+// mirroredConn tries the mirrors of a registry in order before falling back
+// to the registry itself. Endpoints are only dialed when they are first
+// needed, so the registry is not contacted at all while a mirror can serve
+// the image. Credentials given for the registry are only ever sent to the
+// registry; mirrors only get the default credentials of their own host.
+type mirroredConn struct {
+	endpoints []*endpoint
+}
+
+type endpoint struct {
+	host string
+	dial func(logger lager.Logger) (Conn, error)
+
+	mu   sync.Mutex
+	conn Conn
+}
+
+func (m *mirroredConn) GetManifest(logger lager.Logger, reference string) (*Manifest, error) {
+	var manifest *Manifest
+	err := m.try(logger, "get-manifest", func(conn Conn) (err error) {
+		manifest, err = conn.GetManifest(logger, reference)
+		return err
+	})
+
+	return manifest, err
+}
+
+func (m *mirroredConn) GetBlobReader(logger lager.Logger, d digest.Digest) (io.ReadCloser, error) {
+	var blob io.ReadCloser
+	err := m.try(logger, "get-blob", func(conn Conn) (err error) {
+		blob, err = conn.GetBlobReader(logger, d)
+		return err
+	})
+
+	return blob, err
+}
+
+func (m *mirroredConn) GetBlobRangeReader(logger lager.Logger, d digest.Digest, offset int64) (io.ReadCloser, error) {
+	var blob io.ReadCloser
+	err := m.try(logger, "get-blob-range", func(conn Conn) (err error) {
+		blob, err = conn.GetBlobRangeReader(logger, d, offset)
+		return err
+	})
+
+	return blob, err
+}
+
+func (m *mirroredConn) try(logger lager.Logger, action string, fn func(Conn) error) error {
+	var err error
+	for _, e := range m.endpoints {
+		var conn Conn
+		if conn, err = e.get(logger); err == nil {
+			err = fn(conn)
+		}
+
+		if err == nil {
+			logger.Info(action+"-served", lager.Data{"endpoint": e.host})
+			return nil
+		}
+
+		logger.Error(action+"-failed", err, lager.Data{"endpoint": e.host})
+	}
+
+	return err
+}
+
+func (e *endpoint) get(logger lager.Logger) (Conn, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn != nil {
+		return e.conn, nil
+	}
+
+	conn, err := e.dial(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	e.conn = conn
+	return conn, nil
+}
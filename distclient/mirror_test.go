@@ -0,0 +1,118 @@
+package distclient_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/garden-shed/distclient"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/docker/distribution/digest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("registry mirrors", func() {
+	var (
+		logger       lager.Logger
+		mirror       *fakeRegistry
+		origin       *fakeRegistry
+		mirrorServer *httptest.Server
+		originServer *httptest.Server
+		conn         distclient.Conn
+		blobSum      digest.Digest
+		manifest     []byte
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+
+		mirror = newFakeRegistry()
+		mirrorServer = httptest.NewServer(mirror)
+
+		origin = newFakeRegistry()
+		originServer = httptest.NewServer(origin)
+
+		manifest = mustMarshal(map[string]interface{}{
+			"schemaVersion": 1,
+			"fsLayers":      []map[string]interface{}{},
+			"history":       []map[string]interface{}{},
+		})
+
+		blobSum = mirror.addBlob([]byte("some-layer"))
+		origin.addBlob([]byte("some-layer"))
+	})
+
+	JustBeforeEach(func() {
+		mirrorHost, originHost := hostOf(mirrorServer), hostOf(originServer)
+
+		dialer := distclient.NewDialer([]string{mirrorHost, originHost})
+		dialer.Mirrors = map[string][]string{
+			originHost: []string{mirrorHost},
+		}
+
+		var err error
+		conn, err = dialer.Dial(logger, originHost, "some/repo", "", "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		mirrorServer.Close()
+		originServer.Close()
+	})
+
+	Context("when the mirror has the image", func() {
+		BeforeEach(func() {
+			mirror.manifests["some-tag"] = servedManifest{content: manifest}
+		})
+
+		It("does not contact the origin registry", func() {
+			_, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			blob, err := conn.GetBlobReader(logger, blobSum)
+			Expect(err).NotTo(HaveOccurred())
+			defer blob.Close()
+
+			Expect(ioutil.ReadAll(blob)).To(Equal([]byte("some-layer")))
+			Expect(origin.requests).To(Equal(0))
+		})
+	})
+
+	Context("when the mirror does not have the image", func() {
+		BeforeEach(func() {
+			origin.manifests["some-tag"] = servedManifest{content: manifest}
+		})
+
+		It("falls back to the origin registry", func() {
+			_, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(mirror.requests).NotTo(BeZero())
+			Expect(origin.requests).NotTo(BeZero())
+		})
+	})
+
+	Context("when the mirror is down", func() {
+		BeforeEach(func() {
+			origin.manifests["some-tag"] = servedManifest{content: manifest}
+			mirrorServer.Close()
+		})
+
+		It("falls back to the origin registry", func() {
+			_, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).NotTo(HaveOccurred())
+
+			blob, err := conn.GetBlobReader(logger, blobSum)
+			Expect(err).NotTo(HaveOccurred())
+			blob.Close()
+		})
+	})
+
+	Context("when no endpoint has the image", func() {
+		It("returns the error from the origin registry", func() {
+			_, err := conn.GetManifest(logger, "some-tag")
+			Expect(err).To(MatchError(ContainSubstring("404")))
+		})
+	})
+})
@@ -0,0 +1,75 @@
+package distclient
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Platform identifies the entry to pick from a manifest list or OCI index
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func DefaultPlatform() Platform {
+	return Platform{
+		OS:           runtime.GOOS,
+		Architecture: runtime.GOARCH,
+	}
+}
+
+// ParsePlatform parses platforms of the form os/arch[/variant], e.g.
+// linux/arm64/v8. An empty string is the platform of the host.
+func ParsePlatform(s string) (Platform, error) {
+	if s == "" {
+		return DefaultPlatform(), nil
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q: expected os/arch[/variant]", s)
+	}
+
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	return p, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// Matches reports whether a manifest built for other can run on p. A platform
+// without a variant accepts any variant of its architecture.
+func (p Platform) Matches(other Platform) bool {
+	p = p.withDefaults()
+
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+
+	return p.Variant == "" || p.Variant == other.Variant
+}
+
+func (p Platform) withDefaults() Platform {
+	def := DefaultPlatform()
+
+	if p.OS == "" {
+		p.OS = def.OS
+	}
+
+	if p.Architecture == "" {
+		p.Architecture = def.Architecture
+	}
+
+	return p
+}
@@ -0,0 +1,59 @@
+package distclient_test
+
+import (
+	"runtime"
+
+	"code.cloudfoundry.org/garden-shed/distclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Platform", func() {
+	Describe("ParsePlatform", func() {
+		It("parses os and architecture", func() {
+			p, err := distclient.ParsePlatform("linux/arm64")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p).To(Equal(distclient.Platform{OS: "linux", Architecture: "arm64"}))
+		})
+
+		It("parses the variant", func() {
+			p, err := distclient.ParsePlatform("linux/arm/v7")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p).To(Equal(distclient.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}))
+		})
+
+		It("defaults to the platform of the host", func() {
+			p, err := distclient.ParsePlatform("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p).To(Equal(distclient.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}))
+		})
+
+		It("rejects malformed platforms", func() {
+			_, err := distclient.ParsePlatform("linux")
+			Expect(err).To(HaveOccurred())
+
+			_, err = distclient.ParsePlatform("linux/arm/v7/extra")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Matches", func() {
+		It("matches the same os and architecture", func() {
+			p := distclient.Platform{OS: "linux", Architecture: "amd64"}
+			Expect(p.Matches(distclient.Platform{OS: "linux", Architecture: "amd64"})).To(BeTrue())
+			Expect(p.Matches(distclient.Platform{OS: "linux", Architecture: "arm64"})).To(BeFalse())
+			Expect(p.Matches(distclient.Platform{OS: "windows", Architecture: "amd64"})).To(BeFalse())
+		})
+
+		It("accepts any variant when none is given", func() {
+			p := distclient.Platform{OS: "linux", Architecture: "arm64"}
+			Expect(p.Matches(distclient.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"})).To(BeTrue())
+		})
+
+		It("requires the variant when one is given", func() {
+			p := distclient.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+			Expect(p.Matches(distclient.Platform{OS: "linux", Architecture: "arm", Variant: "v7"})).To(BeTrue())
+			Expect(p.Matches(distclient.Platform{OS: "linux", Architecture: "arm", Variant: "v6"})).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,122 @@
+package distclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MinTLSVersionFile is the file in the certs directory of a registry holding
+// the minimum TLS version to talk to it with, e.g. 1.2
+const MinTLSVersionFile = "min-tls-version"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// LoadTLSConfig builds the TLS configuration for host from certsDir, which
+// follows docker's certs.d layout: certsDir/<host>/*.crt are CA certificates
+// trusted in addition to the system ones, and each certsDir/<host>/*.cert is
+// a client certificate with its key in the .key file of the same name. A host
+// without a directory gets the default configuration.
+func LoadTLSConfig(certsDir, host string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+
+	if certsDir == "" {
+		return config, nil
+	}
+
+	hostDir := filepath.Join(certsDir, host)
+	files, err := ioutil.ReadDir(hostDir)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		path := filepath.Join(hostDir, f.Name())
+
+		switch {
+		case strings.HasSuffix(f.Name(), ".crt"):
+			if err := addCA(config, path); err != nil {
+				return nil, err
+			}
+		case strings.HasSuffix(f.Name(), ".cert"):
+			if err := addClientCertificate(config, path); err != nil {
+				return nil, err
+			}
+		case strings.HasSuffix(f.Name(), ".key"):
+			if _, err := os.Stat(strings.TrimSuffix(path, ".key") + ".cert"); err != nil {
+				return nil, fmt.Errorf("missing client certificate for key %s", path)
+			}
+		case f.Name() == MinTLSVersionFile:
+			if err := setMinVersion(config, path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return config, nil
+}
+
+func addCA(config *tls.Config, path string) error {
+	if config.RootCAs == nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		config.RootCAs = pool
+	}
+
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return nil
+}
+
+func addClientCertificate(config *tls.Config, certPath string) error {
+	keyPath := strings.TrimSuffix(certPath, ".cert") + ".key"
+	if _, err := os.Stat(keyPath); err != nil {
+		return fmt.Errorf("missing key for client certificate %s", certPath)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return fmt.Errorf("load client certificate %s: %s", certPath, err)
+	}
+
+	config.Certificates = append(config.Certificates, cert)
+	return nil
+}
+
+func setMinVersion(config *tls.Config, path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	version, ok := tlsVersions[strings.TrimSpace(string(contents))]
+	if !ok {
+		return fmt.Errorf("unsupported TLS version %q in %s", strings.TrimSpace(string(contents)), path)
+	}
+
+	config.MinVersion = version
+	return nil
+}
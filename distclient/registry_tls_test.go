@@ -0,0 +1,204 @@
+package distclient_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/garden-shed/distclient"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("per registry TLS configuration", func() {
+	var (
+		logger   lager.Logger
+		certsDir string
+		hostDir  string
+		registry *fakeRegistry
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+
+		var err error
+		certsDir, err = ioutil.TempDir("", "certs.d")
+		Expect(err).NotTo(HaveOccurred())
+
+		registry = newFakeRegistry()
+		registry.manifests["some-tag"] = servedManifest{content: mustMarshal(map[string]interface{}{
+			"schemaVersion": 1,
+			"fsLayers":      []map[string]interface{}{},
+			"history":       []map[string]interface{}{},
+		})}
+		server = httptest.NewUnstartedServer(registry)
+	})
+
+	JustBeforeEach(func() {
+		server.StartTLS()
+
+		hostDir = filepath.Join(certsDir, hostOf(server))
+		Expect(os.MkdirAll(hostDir, 0755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(certsDir)).To(Succeed())
+	})
+
+	writeFile := func(name string, contents []byte) {
+		Expect(ioutil.WriteFile(filepath.Join(hostDir, name), contents, 0600)).To(Succeed())
+	}
+
+	trustServer := func() {
+		writeFile("ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]}))
+	}
+
+	getManifest := func() error {
+		dialer := distclient.NewDialer(nil)
+		dialer.CertsDir = certsDir
+
+		conn, err := dialer.Dial(logger, hostOf(server), "some/repo", "", "")
+		if err != nil {
+			return err
+		}
+
+		_, err = conn.GetManifest(logger, "some-tag")
+		return err
+	}
+
+	It("does not trust registries signed by an unknown CA", func() {
+		Expect(getManifest()).To(MatchError(ContainSubstring("certificate")))
+	})
+
+	It("trusts the CA certificates in the directory of the registry", func() {
+		trustServer()
+
+		Expect(getManifest()).To(Succeed())
+	})
+
+	Context("when the registry requires a client certificate", func() {
+		var clientCert, clientKey []byte
+
+		BeforeEach(func() {
+			var cert *x509.Certificate
+			cert, clientCert, clientKey = generateClientCertificate()
+
+			pool := x509.NewCertPool()
+			pool.AddCert(cert)
+			server.TLS = &tls.Config{
+				ClientAuth: tls.RequireAndVerifyClientCert,
+				ClientCAs:  pool,
+			}
+		})
+
+		JustBeforeEach(func() {
+			trustServer()
+		})
+
+		It("fails without a client certificate", func() {
+			Expect(getManifest()).NotTo(Succeed())
+		})
+
+		It("presents the client certificate in the directory of the registry", func() {
+			writeFile("client.cert", clientCert)
+			writeFile("client.key", clientKey)
+
+			Expect(getManifest()).To(Succeed())
+		})
+
+		It("fails when a client certificate has no key", func() {
+			writeFile("client.cert", clientCert)
+
+			Expect(getManifest()).To(MatchError(ContainSubstring("missing key for client certificate")))
+		})
+
+		It("fails when a key has no client certificate", func() {
+			writeFile("client.key", clientKey)
+
+			Expect(getManifest()).To(MatchError(ContainSubstring("missing client certificate for key")))
+		})
+	})
+
+	Describe("LoadTLSConfig", func() {
+		It("only sets InsecureSkipVerify for registries without a directory", func() {
+			config, err := distclient.LoadTLSConfig(certsDir, "unknown.registry.io", true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.InsecureSkipVerify).To(BeTrue())
+			Expect(config.RootCAs).To(BeNil())
+			Expect(config.Certificates).To(BeEmpty())
+		})
+
+		It("reads the minimum TLS version", func() {
+			writeFile(distclient.MinTLSVersionFile, []byte("1.2\n"))
+
+			config, err := distclient.LoadTLSConfig(certsDir, hostOf(server), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.MinVersion).To(BeEquivalentTo(tls.VersionTLS12))
+		})
+
+		It("accepts TLS 1.3 as the minimum version", func() {
+			writeFile(distclient.MinTLSVersionFile, []byte("1.3"))
+
+			config, err := distclient.LoadTLSConfig(certsDir, hostOf(server), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.MinVersion).To(BeEquivalentTo(tls.VersionTLS13))
+		})
+
+		It("rejects unknown TLS versions", func() {
+			writeFile(distclient.MinTLSVersionFile, []byte("2.0"))
+
+			_, err := distclient.LoadTLSConfig(certsDir, hostOf(server), false)
+			Expect(err).To(MatchError(ContainSubstring(`unsupported TLS version "2.0"`)))
+		})
+
+		It("rejects CA files without certificates", func() {
+			writeFile("ca.crt", []byte("not a certificate"))
+
+			_, err := distclient.LoadTLSConfig(certsDir, hostOf(server), false)
+			Expect(err).To(MatchError(ContainSubstring("no certificates found")))
+		})
+	})
+})
+
+func generateClientCertificate() (*x509.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "garden-shed"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	return cert,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -0,0 +1,126 @@
+package overlay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/garden-shed/docker_drivers/aufs"
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/lager"
+)
+
+// QuotaedDriver limits the size of overlay container layers by mounting a
+// loop device backed by a fixed size file over the layer directory. Overlay
+// requires the upper and work directories to live on the same filesystem, so
+// the whole layer directory is replaced rather than just the upper directory.
+type QuotaedDriver struct {
+	aufs.GraphDriver
+	BackingStoreMgr aufs.BackingStoreMgr
+	LoopMounter     aufs.LoopMounter
+	RootPath        string
+	Logger          lager.Logger
+}
+
+func (o *QuotaedDriver) GetQuotaed(id, mountlabel string, quota int64) (string, error) {
+	path := o.makeLayerPath(id)
+	log := o.Logger.Session("get-quotaed", lager.Data{"id": id, "mountlabel": mountlabel, "quota": quota, "path": path})
+
+	lowerID, err := ioutil.ReadFile(filepath.Join(path, "lower-id"))
+	if err != nil {
+		return "", fmt.Errorf("reading lower id: %s", err)
+	}
+
+	upperInfo, err := os.Stat(filepath.Join(path, "upper"))
+	if err != nil {
+		return "", fmt.Errorf("reading upper directory: %s", err)
+	}
+
+	bsPath, err := o.BackingStoreMgr.Create(id, quota)
+	if err != nil {
+		return "", fmt.Errorf("creating backingstore file: %s", err)
+	}
+
+	if err := o.LoopMounter.MountFile(bsPath, path); err != nil {
+		if err2 := o.BackingStoreMgr.Delete(id); err2 != nil {
+			log.Error("cleaning-backing-store", err2)
+		}
+
+		return "", fmt.Errorf("mounting file: %s", err)
+	}
+
+	cleanup := func() {
+		if err2 := o.LoopMounter.Unmount(path); err2 != nil {
+			log.Error("unmounting-loop-device", err2)
+		}
+		if err2 := o.BackingStoreMgr.Delete(id); err2 != nil {
+			log.Error("cleaning-backing-store", err2)
+		}
+	}
+
+	if err := populateLayer(path, lowerID, upperInfo.Mode()); err != nil {
+		cleanup()
+		return "", fmt.Errorf("populating layer directory: %s", err)
+	}
+
+	mntPath, err := o.GraphDriver.Get(id, mountlabel)
+	if err != nil {
+		cleanup()
+		return "", fmt.Errorf("getting mountpath: %s", err)
+	}
+
+	return mntPath, nil
+}
+
+func (o *QuotaedDriver) Put(id string) error {
+	path := o.makeLayerPath(id)
+
+	// the layer is still unmounted and its backing store removed when putting
+	// it fails, or both would leak
+	if err := o.GraphDriver.Put(id); err != nil {
+		o.Logger.Session("put", lager.Data{"id": id}).Error("putting-layer", err)
+	}
+
+	if err := o.LoopMounter.Unmount(path); err != nil {
+		return fmt.Errorf("unmounting the loop device: %s", err)
+	}
+
+	if err := o.BackingStoreMgr.Delete(id); err != nil {
+		return fmt.Errorf("removing the backing store: %s", err)
+	}
+
+	return nil
+}
+
+func (o *QuotaedDriver) GetDiffLayerPath(rootFSPath string) string {
+	return filepath.Join(filepath.Dir(rootFSPath), "upper")
+}
+
+func (o *QuotaedDriver) GetMntPath(id layercake.ID) string {
+	return filepath.Join(o.makeLayerPath(id.GraphID()), "merged")
+}
+
+func (o *QuotaedDriver) makeLayerPath(id string) string {
+	return filepath.Join(o.RootPath, "overlay", id)
+}
+
+// populateLayer recreates the layout docker's overlay driver expects of a
+// child layer inside the freshly mounted (and therefore empty) layer
+// directory. Container layers always sit directly on top of a flattened image
+// layer, so their upper directory starts out empty.
+func populateLayer(path string, lowerID []byte, upperMode os.FileMode) error {
+	if err := os.Mkdir(filepath.Join(path, "upper"), upperMode); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(filepath.Join(path, "work"), 0700); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(filepath.Join(path, "merged"), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(path, "lower-id"), lowerID, 0666)
+}
@@ -0,0 +1,13 @@
+package overlay_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Overlay Suite")
+}
@@ -0,0 +1,248 @@
+package overlay_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	fakes "code.cloudfoundry.org/garden-shed/docker_drivers/aufs/aufsfakes"
+	"code.cloudfoundry.org/garden-shed/docker_drivers/overlay"
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QuotaedDriver", func() {
+	var (
+		fakeGraphDriver     *fakes.FakeGraphDriver
+		fakeLoopMounter     *fakes.FakeLoopMounter
+		fakeBackingStoreMgr *fakes.FakeBackingStoreMgr
+
+		driver *overlay.QuotaedDriver
+
+		rootPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		rootPath, err = ioutil.TempDir("", "overlay-graph")
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeGraphDriver = new(fakes.FakeGraphDriver)
+		fakeLoopMounter = new(fakes.FakeLoopMounter)
+		fakeBackingStoreMgr = new(fakes.FakeBackingStoreMgr)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(rootPath)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		driver = &overlay.QuotaedDriver{
+			GraphDriver:     fakeGraphDriver,
+			BackingStoreMgr: fakeBackingStoreMgr,
+			LoopMounter:     fakeLoopMounter,
+			RootPath:        rootPath,
+			Logger:          lagertest.NewTestLogger("test"),
+		}
+	})
+
+	createChildLayer := func(id, lowerID string) string {
+		layerPath := filepath.Join(rootPath, "overlay", id)
+		Expect(os.MkdirAll(filepath.Join(layerPath, "upper"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(layerPath, "lower-id"), []byte(lowerID), 0666)).To(Succeed())
+		return layerPath
+	}
+
+	Describe("GetQuotaed", func() {
+		var layerPath string
+
+		BeforeEach(func() {
+			layerPath = createChildLayer("banana-id", "banana-parent")
+			fakeLoopMounter.MountFileStub = func(_, destPath string) error {
+				// simulate the freshly mounted, empty filesystem
+				Expect(os.RemoveAll(destPath)).To(Succeed())
+				return os.MkdirAll(destPath, 0755)
+			}
+		})
+
+		It("should create a backing store file", func() {
+			_, err := driver.GetQuotaed("banana-id", "", 12*1024)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeBackingStoreMgr.CreateCallCount()).To(Equal(1))
+			gottenID, gottenQuota := fakeBackingStoreMgr.CreateArgsForCall(0)
+			Expect(gottenID).To(Equal("banana-id"))
+			Expect(gottenQuota).To(BeNumerically("==", 12*1024))
+		})
+
+		It("should mount the backing store file over the layer directory", func() {
+			fakeBackingStoreMgr.CreateReturns("/path/to/my/banana/device", nil)
+
+			_, err := driver.GetQuotaed("banana-id", "", 10*1024)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeLoopMounter.MountFileCallCount()).To(Equal(1))
+			devicePath, destPath := fakeLoopMounter.MountFileArgsForCall(0)
+			Expect(devicePath).To(Equal("/path/to/my/banana/device"))
+			Expect(destPath).To(Equal(layerPath))
+		})
+
+		It("should recreate the overlay layer layout on the mounted filesystem", func() {
+			_, err := driver.GetQuotaed("banana-id", "", 10*1024)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(filepath.Join(layerPath, "upper")).To(BeADirectory())
+			Expect(filepath.Join(layerPath, "work")).To(BeADirectory())
+			Expect(filepath.Join(layerPath, "merged")).To(BeADirectory())
+
+			lowerID, err := ioutil.ReadFile(filepath.Join(layerPath, "lower-id"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(lowerID)).To(Equal("banana-parent"))
+		})
+
+		Context("when the layer is not a child layer", func() {
+			It("should return an error without creating a backing store", func() {
+				_, err := driver.GetQuotaed("not-there", "", 10*1024)
+				Expect(err).To(MatchError(ContainSubstring("reading lower id")))
+				Expect(fakeBackingStoreMgr.CreateCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when failing to create a backing store", func() {
+			It("should return an error", func() {
+				fakeBackingStoreMgr.CreateReturns("", errors.New("create failed!"))
+
+				_, err := driver.GetQuotaed("banana-id", "", 12*1024)
+				Expect(err).To(MatchError(ContainSubstring("create failed!")))
+			})
+		})
+
+		Context("when failing to mount the backing store", func() {
+			BeforeEach(func() {
+				fakeLoopMounter.MountFileStub = nil
+				fakeLoopMounter.MountFileReturns(errors.New("another banana error"))
+			})
+
+			It("should return an error", func() {
+				_, err := driver.GetQuotaed("banana-id", "", 10*1024)
+				Expect(err).To(MatchError(ContainSubstring("another banana error")))
+			})
+
+			It("should delete the backing store", func() {
+				driver.GetQuotaed("banana-id", "", 10*1024)
+				Expect(fakeBackingStoreMgr.DeleteCallCount()).To(Equal(1))
+			})
+
+			It("should not mount the layer", func() {
+				driver.GetQuotaed("banana-id", "", 10*1024)
+				Expect(fakeGraphDriver.GetCallCount()).To(Equal(0))
+			})
+		})
+
+		It("should mount the layer", func() {
+			_, err := driver.GetQuotaed("banana-id", "handle with care", 10*1024)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeGraphDriver.GetCallCount()).To(Equal(1))
+			gottenID, gottenMountLabel := fakeGraphDriver.GetArgsForCall(0)
+			Expect(gottenID).To(Equal("banana-id"))
+			Expect(gottenMountLabel).To(Equal("handle with care"))
+		})
+
+		It("should return the mounted layer's path", func() {
+			fakeGraphDriver.GetReturns("/path/to/mounted/banana", nil)
+
+			path, err := driver.GetQuotaed("banana-id", "", 10*1024)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/path/to/mounted/banana"))
+		})
+
+		Context("when mounting the layer fails", func() {
+			BeforeEach(func() {
+				fakeGraphDriver.GetReturns("", errors.New("Another banana error"))
+			})
+
+			It("should return an error", func() {
+				_, err := driver.GetQuotaed("banana-id", "", 10*1024)
+				Expect(err).To(MatchError(ContainSubstring("Another banana error")))
+			})
+
+			It("should unmount the loop device and delete the backing store", func() {
+				driver.GetQuotaed("banana-id", "", 10*1024)
+				Expect(fakeLoopMounter.UnmountCallCount()).To(Equal(1))
+				Expect(fakeLoopMounter.UnmountArgsForCall(0)).To(Equal(layerPath))
+				Expect(fakeBackingStoreMgr.DeleteCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("Put", func() {
+		It("should put the layer", func() {
+			Expect(driver.Put("herring-id")).To(Succeed())
+
+			Expect(fakeGraphDriver.PutCallCount()).To(Equal(1))
+			Expect(fakeGraphDriver.PutArgsForCall(0)).To(Equal("herring-id"))
+		})
+
+		Context("when putting the layer fails", func() {
+			BeforeEach(func() {
+				fakeGraphDriver.PutReturns(errors.New("banana"))
+			})
+
+			It("should still unmount the loop device", func() {
+				Expect(driver.Put("banana-id")).To(Succeed())
+				Expect(fakeLoopMounter.UnmountCallCount()).To(Equal(1))
+			})
+
+			It("should still remove the backing store file", func() {
+				Expect(driver.Put("banana-id")).To(Succeed())
+				Expect(fakeBackingStoreMgr.DeleteCallCount()).To(Equal(1))
+				Expect(fakeBackingStoreMgr.DeleteArgsForCall(0)).To(Equal("banana-id"))
+			})
+		})
+
+		It("should unmount the loop mount", func() {
+			Expect(driver.Put("banana-id")).To(Succeed())
+
+			Expect(fakeLoopMounter.UnmountCallCount()).To(Equal(1))
+			Expect(fakeLoopMounter.UnmountArgsForCall(0)).To(Equal(filepath.Join(rootPath, "overlay", "banana-id")))
+		})
+
+		Context("when unmounting the loop device fails", func() {
+			BeforeEach(func() {
+				fakeLoopMounter.UnmountReturns(errors.New("avocado"))
+			})
+
+			It("should return an error", func() {
+				Expect(driver.Put("banana-id")).To(MatchError("unmounting the loop device: avocado"))
+			})
+
+			It("should not remove the backing store file", func() {
+				Expect(driver.Put("banana-id")).To(HaveOccurred())
+				Expect(fakeBackingStoreMgr.DeleteCallCount()).To(Equal(0))
+			})
+		})
+
+		It("should remove the backing store file", func() {
+			Expect(driver.Put("banana-id")).To(Succeed())
+
+			Expect(fakeBackingStoreMgr.DeleteCallCount()).To(Equal(1))
+			Expect(fakeBackingStoreMgr.DeleteArgsForCall(0)).To(Equal("banana-id"))
+		})
+	})
+
+	Describe("GetMntPath", func() {
+		It("returns the merged path of the given layer (without calling Path)", func() {
+			Expect(driver.GetMntPath(layercake.DockerImageID("foo"))).To(Equal(filepath.Join(rootPath, "overlay", "foo", "merged")))
+		})
+	})
+
+	Describe("GetDiffLayerPath", func() {
+		It("returns the upper directory next to the given merged directory", func() {
+			Expect(driver.GetDiffLayerPath("some/overlay/id/merged")).To(Equal("some/overlay/id/upper"))
+		})
+	})
+})
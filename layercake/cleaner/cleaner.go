@@ -1,14 +1,25 @@
 package cleaner
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"code.cloudfoundry.org/garden-shed/layercake"
 	"code.cloudfoundry.org/lager"
+	"github.com/docker/docker/image"
 )
 
+// OvenCleaner removes unused layer chains once GraphCleanupThreshold is
+// exceeded. Chains are removed least recently used first, going by LastUsed
+// or else by when their leaf was created, until LowWaterMark is reached; a
+// nil LowWaterMark removes every unused chain. References, if set, names the
+// images layers belong to in dry run reports.
 type OvenCleaner struct {
 	GraphCleanupThreshold Threshold
+	LowWaterMark          Target
+	LastUsed              *LastUsed
+	References            ImageReferencer
 	retainCheck           Checker
 }
 
@@ -26,6 +37,25 @@ type Threshold interface {
 	Exceeded(log lager.Logger, cake layercake.Cake) bool
 }
 
+//go:generate counterfeiter . Target
+type Target interface {
+	// Excess returns how many bytes GC has to reclaim to reach the target
+	Excess(log lager.Logger, cake layercake.Cake) (int64, error)
+}
+
+type ImageReferencer interface {
+	// References returns the references of the images with the given top layer
+	References(imageID string) []string
+}
+
+// sweepGraph is what a sweep needs of the graph, so that a dry run can sweep
+// a graph which only pretends to remove layers
+type sweepGraph interface {
+	Get(id layercake.ID) (*image.Image, error)
+	IsLeaf(id layercake.ID) (bool, error)
+	Remove(id layercake.ID) error
+}
+
 func NewOvenCleaner(retainCheck Checker, graphCleanupThreshold Threshold) *OvenCleaner {
 	return &OvenCleaner{
 		GraphCleanupThreshold: graphCleanupThreshold,
@@ -44,21 +74,163 @@ func (g *OvenCleaner) GC(log lager.Logger, cake layercake.Cake) error {
 		return nil
 	}
 
+	report := &Report{ThresholdExceeded: true}
+	err := g.sweep(log, cake, cake, report)
+	g.forget(log, report)
+
+	log.Info("reclaimed", lager.Data{"bytes": report.Reclaimed})
+	return err
+}
+
+// Candidates returns the leaves whose chains GC would remove now, in the
+// order it would remove them, without removing anything. Together with
+// RemoveChain it lets GC be done a chain at a time.
+func (g *OvenCleaner) Candidates(log lager.Logger, cake layercake.Cake) ([]layercake.ID, error) {
+	log = log.Session("gc-candidates")
+
+	if exceeded := g.GraphCleanupThreshold.Exceeded(log, cake); !exceeded {
+		log.Debug("threshold-not-exceeded")
+		return nil, nil
+	}
+
+	report := &Report{DryRun: true, ThresholdExceeded: true}
+	if err := g.sweep(log, cake, newDryRunGraph(cake), report); err != nil {
+		return nil, err
+	}
+
+	return report.chains, nil
+}
+
+// RemoveChain removes a leaf returned by Candidates along with its ancestors,
+// for as long as they are left without children and have not been used more
+// recently than the leaf, and returns the number of bytes removed. Since the
+// graph may have changed since, a layer which has become retained, a
+// container or a parent is left alone.
+func (g *OvenCleaner) RemoveChain(log lager.Logger, cake layercake.Cake, id layercake.ID) (int64, error) {
+	log = log.Session("gc-remove-chain", lager.Data{"id": id})
+
+	if leaf, err := cake.IsLeaf(id); err != nil || !leaf {
+		log.Info("no-longer-a-leaf")
+		return 0, nil
+	}
+
+	report := &Report{}
+	freed, _, err := g.removeRecursively(log, cake, id, g.lastUsed(cake, id), report)
+	g.forget(log, report)
+
+	return freed, err
+}
+
+// forget drops the last used times of the layers removed
+func (g *OvenCleaner) forget(log lager.Logger, report *Report) {
+	if g.LastUsed == nil {
+		return
+	}
+
+	for _, layer := range report.Layers {
+		if !layer.Removed {
+			continue
+		}
+
+		if err := g.LastUsed.Forget(layercake.DockerImageID(layer.ID)); err != nil {
+			log.Error("forget-last-used-failed", err)
+		}
+	}
+}
+
+// DryRun reports what GC would remove now, and why it would keep the other
+// layers, without removing anything
+func (g *OvenCleaner) DryRun(log lager.Logger, cake layercake.Cake) (*Report, error) {
+	log = log.Session("gc-dry-run")
+
+	log.Info("start")
+	defer log.Info("finished")
+
+	report := &Report{
+		DryRun:            true,
+		ThresholdExceeded: g.GraphCleanupThreshold.Exceeded(log, cake),
+	}
+
+	if report.ThresholdExceeded {
+		if err := g.sweep(log, cake, newDryRunGraph(cake), report); err != nil {
+			return nil, err
+		}
+	} else {
+		ids, err := cake.GetAllLeaves()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range ids {
+			report.keep(id, ReasonBelowThreshold)
+		}
+	}
+
+	g.describe(cake, report)
+	return report, nil
+}
+
+// sweep removes unused chains from graph, least recently used first, until
+// the low-water mark is reached, and records what it did in report
+func (g *OvenCleaner) sweep(log lager.Logger, cake layercake.Cake, graph sweepGraph, report *Report) error {
+	var excess int64
+	if g.LowWaterMark != nil {
+		var err error
+		if excess, err = g.LowWaterMark.Excess(log, cake); err != nil {
+			return err
+		}
+	}
+
 	ids, err := cake.GetAllLeaves()
 	if err != nil {
 		return err
 	}
 
-	for _, id := range ids {
-		if err := g.removeRecursively(log, cake, id); err != nil {
+	reached := false
+	queue := g.leastRecentlyUsedFirst(graph, ids)
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+
+		id := next.id
+		if g.LowWaterMark != nil && excess <= 0 {
+			if !reached {
+				log.Info("low-water-mark-reached")
+				reached = true
+			}
+
+			report.keep(id, ReasonLowWaterMarkReached)
+			continue
+		}
+
+		freed, left, err := g.removeRecursively(log, graph, id, next.lastUsed, report)
+		report.Reclaimed += freed
+		if err != nil {
 			return err
 		}
+
+		// an ancestor used more recently than the chain is left as a leaf,
+		// and takes its turn in the queue
+		if left != nil {
+			queue = queue.insert(leaf{id: left, lastUsed: g.lastUsed(graph, left)})
+		}
+
+		if report.removed(id) {
+			report.chains = append(report.chains, id)
+		}
+
+		excess -= freed
 	}
 
 	return nil
 }
 
-func (g *OvenCleaner) removeRecursively(log lager.Logger, cake layercake.Cake, id layercake.ID) error {
+// removeRecursively removes the layer and then its ancestors, for as long as
+// they are left without children, and returns the number of bytes removed.
+// An ancestor used more recently than chainLastUsed, such as a base image
+// that containers are still created from, is not removed along with the
+// chain: it is returned instead, for its own last used time to decide.
+func (g *OvenCleaner) removeRecursively(log lager.Logger, graph sweepGraph, id layercake.ID, chainLastUsed time.Time, report *Report) (int64, layercake.ID, error) {
 	log = log.Session("remove-recursively", lager.Data{"id": id})
 
 	log.Debug("start")
@@ -66,36 +238,97 @@ func (g *OvenCleaner) removeRecursively(log lager.Logger, cake layercake.Cake, i
 
 	if g.retainCheck.Check(id) {
 		log.Debug("layer-is-held")
-		return nil
+		report.keep(id, ReasonRetained)
+		return 0, nil, nil
 	}
 
-	img, err := cake.Get(id)
+	img, err := graph.Get(id)
 	if err != nil {
 		log.Error("get-image-failed", err)
-		return nil
+		report.keep(id, ReasonNotFound)
+		return 0, nil, nil
 	}
 
 	if img.Container != "" {
 		log.Debug("image-is-container", lager.Data{"id": id, "container": img.Container})
-		return nil
+		report.keep(id, ReasonContainer)
+		return 0, nil, nil
 	}
 
-	if err := cake.Remove(id); err != nil {
+	if err := graph.Remove(id); err != nil {
 		log.Error("remove-image-failed", err)
-		return err
+		return 0, nil, err
 	}
 
+	report.remove(id)
+
 	if img.Parent == "" {
 		log.Debug("stop-image-has-no-parent")
-		return nil
+		return img.Size, nil, nil
 	}
 
-	if leaf, err := cake.IsLeaf(layercake.DockerImageID(img.Parent)); err == nil && leaf {
+	parent := layercake.DockerImageID(img.Parent)
+	if leaf, err := graph.IsLeaf(parent); err == nil && leaf {
+		if g.lastUsed(graph, parent).After(chainLastUsed) {
+			log.Debug("stop-parent-used-more-recently", lager.Data{"parent-id": img.Parent})
+			return img.Size, parent, nil
+		}
+
 		log.Debug("has-parent-leaf", lager.Data{"parent-id": img.Parent})
-		return g.removeRecursively(log, cake, layercake.DockerImageID(img.Parent))
+		freed, left, err := g.removeRecursively(log, graph, parent, chainLastUsed, report)
+		return img.Size + freed, left, err
 	}
 
-	return nil
+	return img.Size, nil, nil
+}
+
+// leastRecentlyUsedFirst orders the leaves by when they were last used. Leaves
+// which have never been used to create a container go by when they were
+// created.
+func (g *OvenCleaner) leastRecentlyUsedFirst(graph sweepGraph, ids []layercake.ID) byLastUsed {
+	leaves := make(byLastUsed, 0, len(ids))
+	for _, id := range ids {
+		leaves = append(leaves, leaf{id: id, lastUsed: g.lastUsed(graph, id)})
+	}
+
+	sort.Stable(leaves)
+	return leaves
+}
+
+func (g *OvenCleaner) lastUsed(graph sweepGraph, id layercake.ID) time.Time {
+	if g.LastUsed != nil {
+		if t, ok := g.LastUsed.Get(id); ok {
+			return t
+		}
+	}
+
+	if img, err := graph.Get(id); err == nil {
+		return img.Created
+	}
+
+	return time.Time{}
+}
+
+type leaf struct {
+	id       layercake.ID
+	lastUsed time.Time
+}
+
+type byLastUsed []leaf
+
+func (b byLastUsed) Len() int           { return len(b) }
+func (b byLastUsed) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byLastUsed) Less(i, j int) bool { return b[i].lastUsed.Before(b[j].lastUsed) }
+
+// insert adds l after the leaves used no later than it, keeping the order
+func (b byLastUsed) insert(l leaf) byLastUsed {
+	i := sort.Search(len(b), func(i int) bool { return b[i].lastUsed.After(l.lastUsed) })
+
+	b = append(b, leaf{})
+	copy(b[i+1:], b[i:])
+	b[i] = l
+
+	return b
 }
 
 type retainer struct {
@@ -133,3 +366,7 @@ func (g *retainer) Check(id layercake.ID) bool {
 type CheckFunc func(id layercake.ID) bool
 
 func (fn CheckFunc) Check(id layercake.ID) bool { return fn(id) }
+
+type ReferencesFunc func(imageID string) []string
+
+func (fn ReferencesFunc) References(imageID string) []string { return fn(imageID) }
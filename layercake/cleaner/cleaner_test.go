@@ -2,7 +2,9 @@ package cleaner_test
 
 import (
 	"errors"
+	"time"
 
+	"code.cloudfoundry.org/clock/fakeclock"
 	"code.cloudfoundry.org/garden-shed/layercake"
 	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
 	fakes "code.cloudfoundry.org/garden-shed/layercake/cleaner/cleanerfakes"
@@ -21,6 +23,7 @@ var _ = Describe("Oven cleaner", func() {
 		fakeCake      *fake_cake.FakeCake
 		child2parent  map[layercake.ID]layercake.ID // child -> parent
 		size          map[layercake.ID]int64
+		created       map[layercake.ID]time.Time
 		fakeThreshold *fakes.FakeThreshold
 		logger        lager.Logger
 	)
@@ -35,14 +38,16 @@ var _ = Describe("Oven cleaner", func() {
 		fakeCake.GetStub = func(id layercake.ID) (*image.Image, error) {
 			if parent, ok := child2parent[id]; ok {
 				return &image.Image{
-					ID:     id.GraphID(),
-					Parent: parent.GraphID(),
-					Size:   size[id],
+					ID:      id.GraphID(),
+					Parent:  parent.GraphID(),
+					Size:    size[id],
+					Created: created[id],
 				}, nil
 			}
 
 			return &image.Image{
-				Size: size[id],
+				Size:    size[id],
+				Created: created[id],
 			}, nil
 		}
 
@@ -63,6 +68,7 @@ var _ = Describe("Oven cleaner", func() {
 
 		child2parent = make(map[layercake.ID]layercake.ID)
 		size = make(map[layercake.ID]int64)
+		created = make(map[layercake.ID]time.Time)
 	})
 
 	JustBeforeEach(func() {
@@ -187,6 +193,125 @@ var _ = Describe("Oven cleaner", func() {
 
 			})
 
+			Context("when the leaves have been used at different times", func() {
+				var (
+					fakeClock  *fakeclock.FakeClock
+					lastUsed   *cleaner.LastUsed
+					fakeTarget *fakes.FakeTarget
+				)
+
+				BeforeEach(func() {
+					fakeCake.GetAllLeavesReturns([]layercake.ID{
+						layercake.DockerImageID("recent"),
+						layercake.DockerImageID("stale"),
+						layercake.DockerImageID("never-used"),
+					}, nil)
+
+					size[layercake.DockerImageID("recent")] = 100
+					size[layercake.DockerImageID("stale")] = 100
+					size[layercake.DockerImageID("never-used")] = 100
+					created[layercake.DockerImageID("never-used")] = time.Unix(500, 0)
+
+					fakeClock = fakeclock.NewFakeClock(time.Unix(1000, 0))
+					lastUsed = &cleaner.LastUsed{Clock: fakeClock}
+					Expect(lastUsed.Touch(layercake.DockerImageID("stale"))).To(Succeed())
+					fakeClock.Increment(time.Hour)
+					Expect(lastUsed.Touch(layercake.DockerImageID("recent"))).To(Succeed())
+
+					fakeTarget = new(fakes.FakeTarget)
+				})
+
+				JustBeforeEach(func() {
+					gc.LastUsed = lastUsed
+				})
+
+				It("removes the least recently used leaves first", func() {
+					Expect(gc.GC(logger, fakeCake)).To(Succeed())
+
+					Expect(fakeCake.RemoveCallCount()).To(Equal(3))
+					Expect(fakeCake.RemoveArgsForCall(0)).To(Equal(layercake.DockerImageID("never-used")))
+					Expect(fakeCake.RemoveArgsForCall(1)).To(Equal(layercake.DockerImageID("stale")))
+					Expect(fakeCake.RemoveArgsForCall(2)).To(Equal(layercake.DockerImageID("recent")))
+				})
+
+				It("forgets when removed layers were used", func() {
+					Expect(gc.GC(logger, fakeCake)).To(Succeed())
+
+					_, ok := lastUsed.Get(layercake.DockerImageID("stale"))
+					Expect(ok).To(BeFalse())
+				})
+
+				Context("when there is a low-water mark", func() {
+					JustBeforeEach(func() {
+						gc.LowWaterMark = fakeTarget
+					})
+
+					It("stops removing leaves once enough has been reclaimed to reach it", func() {
+						fakeTarget.ExcessReturns(150, nil)
+						Expect(gc.GC(logger, fakeCake)).To(Succeed())
+
+						Expect(fakeCake.RemoveCallCount()).To(Equal(2))
+						Expect(fakeCake.RemoveArgsForCall(0)).To(Equal(layercake.DockerImageID("never-used")))
+						Expect(fakeCake.RemoveArgsForCall(1)).To(Equal(layercake.DockerImageID("stale")))
+					})
+
+					It("counts the parents removed along with a leaf", func() {
+						child2parent[layercake.DockerImageID("never-used")] = layercake.DockerImageID("base")
+						size[layercake.DockerImageID("base")] = 100
+
+						fakeTarget.ExcessReturns(150, nil)
+						Expect(gc.GC(logger, fakeCake)).To(Succeed())
+
+						Expect(fakeCake.RemoveCallCount()).To(Equal(2))
+						Expect(fakeCake.RemoveArgsForCall(0)).To(Equal(layercake.DockerImageID("never-used")))
+						Expect(fakeCake.RemoveArgsForCall(1)).To(Equal(layercake.DockerImageID("base")))
+					})
+
+					Context("when a parent has been used more recently than its leaf", func() {
+						BeforeEach(func() {
+							child2parent[layercake.DockerImageID("stale")] = layercake.DockerImageID("base")
+							size[layercake.DockerImageID("base")] = 100
+
+							fakeClock.Increment(time.Hour)
+							Expect(lastUsed.Touch(layercake.DockerImageID("base"))).To(Succeed())
+						})
+
+						It("does not remove the parent along with the leaf", func() {
+							fakeTarget.ExcessReturns(250, nil)
+							Expect(gc.GC(logger, fakeCake)).To(Succeed())
+
+							Expect(fakeCake.RemoveCallCount()).To(Equal(3))
+							Expect(fakeCake.RemoveArgsForCall(0)).To(Equal(layercake.DockerImageID("never-used")))
+							Expect(fakeCake.RemoveArgsForCall(1)).To(Equal(layercake.DockerImageID("stale")))
+							Expect(fakeCake.RemoveArgsForCall(2)).To(Equal(layercake.DockerImageID("recent")))
+						})
+
+						It("removes the parent in its own turn once the other leaves are gone", func() {
+							fakeTarget.ExcessReturns(400, nil)
+							Expect(gc.GC(logger, fakeCake)).To(Succeed())
+
+							Expect(fakeCake.RemoveCallCount()).To(Equal(4))
+							Expect(fakeCake.RemoveArgsForCall(2)).To(Equal(layercake.DockerImageID("recent")))
+							Expect(fakeCake.RemoveArgsForCall(3)).To(Equal(layercake.DockerImageID("base")))
+						})
+					})
+
+					It("does not remove anything when it is already reached", func() {
+						fakeTarget.ExcessReturns(0, nil)
+						Expect(gc.GC(logger, fakeCake)).To(Succeed())
+						Expect(fakeCake.RemoveCallCount()).To(Equal(0))
+					})
+
+					Context("when it cannot be worked out how much to reclaim", func() {
+						It("returns the error without removing anything", func() {
+							fakeTarget.ExcessReturns(0, errors.New("statfs failed"))
+							Expect(gc.GC(logger, fakeCake)).To(MatchError("statfs failed"))
+							Expect(fakeCake.RemoveCallCount()).To(Equal(0))
+						})
+					})
+				})
+			})
+
 			Context("when getting the list of leaves fails", func() {
 				It("returns the error", func() {
 					fakeCake.GetAllLeavesReturns(nil, errors.New("firey potato"))
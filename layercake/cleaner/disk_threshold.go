@@ -0,0 +1,109 @@
+package cleaner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/lager"
+	"github.com/docker/docker/pkg/units"
+)
+
+// FreeSpace is an amount of free space on a filesystem, either in bytes or as
+// a percentage of the size of the filesystem
+type FreeSpace struct {
+	Bytes   int64
+	Percent float64
+}
+
+// ParseFreeSpace parses a percentage such as "10%" or a size such as "5G"
+func ParseFreeSpace(s string) (FreeSpace, error) {
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return FreeSpace{}, fmt.Errorf("invalid free space percentage: %s", s)
+		}
+
+		return FreeSpace{Percent: percent}, nil
+	}
+
+	bytes, err := units.RAMInBytes(s)
+	if err != nil {
+		return FreeSpace{}, fmt.Errorf("invalid free space: %s", err)
+	}
+
+	return FreeSpace{Bytes: bytes}, nil
+}
+
+func (f FreeSpace) of(total int64) int64 {
+	if f.Percent > 0 {
+		return int64(float64(total) * f.Percent / 100)
+	}
+
+	return f.Bytes
+}
+
+// DiskThreshold measures the free space of the filesystem the graph lives on,
+// which also counts the backing stores of containers and anything else
+// sharing the disk. It is exceeded when less than Trigger is free, and as a
+// Target has GC reclaim space until Target is free.
+type DiskThreshold struct {
+	Path    string
+	Trigger FreeSpace
+	Target  FreeSpace
+
+	Statfs func(path string, buf *syscall.Statfs_t) error
+}
+
+func NewDiskThreshold(path string, trigger, target FreeSpace) *DiskThreshold {
+	return &DiskThreshold{
+		Path:    path,
+		Trigger: trigger,
+		Target:  target,
+		Statfs:  syscall.Statfs,
+	}
+}
+
+func (d *DiskThreshold) Exceeded(log lager.Logger, cake layercake.Cake) bool {
+	log = log.Session("disk-threshold", lager.Data{"path": d.Path})
+
+	free, total, err := d.statfs()
+	if err != nil {
+		log.Error("statfs-failed", err)
+		return false
+	}
+
+	trigger := d.Trigger.of(total)
+	log.Info("measured", lager.Data{"free": free, "total": total, "trigger": trigger})
+
+	return free < trigger
+}
+
+func (d *DiskThreshold) Excess(log lager.Logger, cake layercake.Cake) (int64, error) {
+	free, total, err := d.statfs()
+	if err != nil {
+		return 0, fmt.Errorf("measure free space of %s: %s", d.Path, err)
+	}
+
+	target := d.Target.of(total)
+	log.Info("disk-target", lager.Data{"free": free, "total": total, "target": target})
+
+	if free >= target {
+		return 0, nil
+	}
+
+	return target - free, nil
+}
+
+// statfs returns the space available to unprivileged users and the size of
+// the filesystem, in bytes
+func (d *DiskThreshold) statfs() (int64, int64, error) {
+	var buf syscall.Statfs_t
+	if err := d.Statfs(d.Path, &buf); err != nil {
+		return 0, 0, err
+	}
+
+	return int64(buf.Bavail) * int64(buf.Bsize), int64(buf.Blocks) * int64(buf.Bsize), nil
+}
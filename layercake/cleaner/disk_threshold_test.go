@@ -0,0 +1,124 @@
+package cleaner_test
+
+import (
+	"errors"
+	"syscall"
+
+	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
+	"code.cloudfoundry.org/garden-shed/layercake/fake_cake"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DiskThreshold", func() {
+	var (
+		fakeCake  *fake_cake.FakeCake
+		logger    lager.Logger
+		threshold *cleaner.DiskThreshold
+
+		statfsErr  error
+		statfsPath string
+		available  uint64
+	)
+
+	BeforeEach(func() {
+		fakeCake = new(fake_cake.FakeCake)
+		logger = lagertest.NewTestLogger("test")
+
+		statfsErr = nil
+		available = 300
+
+		threshold = cleaner.NewDiskThreshold("/graph", cleaner.FreeSpace{Bytes: 2048}, cleaner.FreeSpace{Percent: 50})
+		threshold.Statfs = func(path string, buf *syscall.Statfs_t) error {
+			statfsPath = path
+			buf.Bsize = 10
+			buf.Blocks = 1000
+			buf.Bavail = available
+			return statfsErr
+		}
+	})
+
+	Describe("Exceeded", func() {
+		It("measures the filesystem at the path", func() {
+			threshold.Exceeded(logger, fakeCake)
+			Expect(statfsPath).To(Equal("/graph"))
+		})
+
+		Context("when less than the trigger is free", func() {
+			BeforeEach(func() {
+				available = 200
+			})
+
+			It("is exceeded", func() {
+				Expect(threshold.Exceeded(logger, fakeCake)).To(BeTrue())
+			})
+		})
+
+		Context("when at least the trigger is free", func() {
+			It("is not exceeded", func() {
+				Expect(threshold.Exceeded(logger, fakeCake)).To(BeFalse())
+			})
+		})
+
+		Context("when the filesystem cannot be measured", func() {
+			BeforeEach(func() {
+				available = 0
+				statfsErr = errors.New("no such file")
+			})
+
+			It("is not exceeded", func() {
+				Expect(threshold.Exceeded(logger, fakeCake)).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("Excess", func() {
+		It("returns how much has to be freed to reach the target", func() {
+			Expect(threshold.Excess(logger, fakeCake)).To(BeEquivalentTo(2000))
+		})
+
+		Context("when the target is already free", func() {
+			BeforeEach(func() {
+				available = 600
+			})
+
+			It("returns zero", func() {
+				Expect(threshold.Excess(logger, fakeCake)).To(BeZero())
+			})
+		})
+
+		Context("when the filesystem cannot be measured", func() {
+			BeforeEach(func() {
+				statfsErr = errors.New("no such file")
+			})
+
+			It("returns an error", func() {
+				_, err := threshold.Excess(logger, fakeCake)
+				Expect(err).To(MatchError(ContainSubstring("no such file")))
+			})
+		})
+	})
+})
+
+var _ = Describe("ParseFreeSpace", func() {
+	It("parses percentages", func() {
+		Expect(cleaner.ParseFreeSpace("12.5%")).To(Equal(cleaner.FreeSpace{Percent: 12.5}))
+	})
+
+	It("parses sizes", func() {
+		Expect(cleaner.ParseFreeSpace("2G")).To(Equal(cleaner.FreeSpace{Bytes: 2 * 1024 * 1024 * 1024}))
+		Expect(cleaner.ParseFreeSpace("4096")).To(Equal(cleaner.FreeSpace{Bytes: 4096}))
+	})
+
+	It("rejects percentages over 100", func() {
+		_, err := cleaner.ParseFreeSpace("101%")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects garbage", func() {
+		_, err := cleaner.ParseFreeSpace("lots")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,89 @@
+package cleaner
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/pkg/atomicfile"
+)
+
+// LastUsed records when layers were last used to create a container, so that
+// GC can remove the least recently used chains first. The times are saved to
+// Path whenever a layer is touched or forgotten.
+type LastUsed struct {
+	Path  string
+	Clock clock.Clock
+
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+}
+
+type lastUsedFile struct {
+	Layers map[string]time.Time `json:"layers"`
+}
+
+// LoadLastUsed loads the times saved at path, or starts with none if there
+// are none yet
+func LoadLastUsed(path string, clock clock.Clock) (*LastUsed, error) {
+	lastUsed := &LastUsed{
+		Path:     path,
+		Clock:    clock,
+		lastUsed: make(map[string]time.Time),
+	}
+
+	if err := atomicfile.JSONFile(path).Load(&lastUsedFile{Layers: lastUsed.lastUsed}); err != nil {
+		return nil, err
+	}
+
+	return lastUsed, nil
+}
+
+// Touch marks the layer as used now
+func (l *LastUsed) Touch(id layercake.ID) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.lastUsed == nil {
+		l.lastUsed = make(map[string]time.Time)
+	}
+
+	l.lastUsed[id.GraphID()] = l.now()
+	return l.save()
+}
+
+// Get returns when the layer was last used, if it has been used since the
+// times were first recorded
+func (l *LastUsed) Get(id layercake.ID) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, ok := l.lastUsed[id.GraphID()]
+	return t, ok
+}
+
+// Forget drops the time of a layer, once it has been removed
+func (l *LastUsed) Forget(id layercake.ID) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.lastUsed[id.GraphID()]; !ok {
+		return nil
+	}
+
+	delete(l.lastUsed, id.GraphID())
+	return l.save()
+}
+
+func (l *LastUsed) now() time.Time {
+	if l.Clock == nil {
+		return time.Now()
+	}
+
+	return l.Clock.Now()
+}
+
+func (l *LastUsed) save() error {
+	return atomicfile.JSONFile(l.Path).Save(lastUsedFile{Layers: l.lastUsed})
+}
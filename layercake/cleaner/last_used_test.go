@@ -0,0 +1,85 @@
+package cleaner_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LastUsed", func() {
+	var (
+		dir       string
+		path      string
+		fakeClock *fakeclock.FakeClock
+		lastUsed  *cleaner.LastUsed
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "last-used")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = filepath.Join(dir, "garden-info", "last-used.json")
+		fakeClock = fakeclock.NewFakeClock(time.Unix(1000, 0))
+
+		lastUsed, err = cleaner.LoadLastUsed(path, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("does not know layers which have not been used", func() {
+		_, ok := lastUsed.Get(layercake.DockerImageID("some-layer"))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("records when a layer was used", func() {
+		Expect(lastUsed.Touch(layercake.DockerImageID("some-layer"))).To(Succeed())
+		fakeClock.Increment(time.Minute)
+
+		t, ok := lastUsed.Get(layercake.DockerImageID("some-layer"))
+		Expect(ok).To(BeTrue())
+		Expect(t).To(Equal(time.Unix(1000, 0)))
+	})
+
+	It("persists the times across loads", func() {
+		Expect(lastUsed.Touch(layercake.DockerImageID("some-layer"))).To(Succeed())
+
+		loaded, err := cleaner.LoadLastUsed(path, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+
+		t, ok := loaded.Get(layercake.DockerImageID("some-layer"))
+		Expect(ok).To(BeTrue())
+		Expect(t.Equal(time.Unix(1000, 0))).To(BeTrue())
+	})
+
+	It("forgets layers", func() {
+		Expect(lastUsed.Touch(layercake.DockerImageID("some-layer"))).To(Succeed())
+		Expect(lastUsed.Forget(layercake.DockerImageID("some-layer"))).To(Succeed())
+
+		loaded, err := cleaner.LoadLastUsed(path, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, ok := loaded.Get(layercake.DockerImageID("some-layer"))
+		Expect(ok).To(BeFalse())
+	})
+
+	Context("when the saved times are corrupt", func() {
+		It("returns an error", func() {
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path, []byte("{"), 0644)).To(Succeed())
+
+			_, err := cleaner.LoadLastUsed(path, fakeClock)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
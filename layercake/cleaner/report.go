@@ -0,0 +1,183 @@
+package cleaner
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"github.com/docker/docker/image"
+)
+
+// Reason says why GC removed or kept a layer
+type Reason string
+
+const (
+	ReasonUnused              Reason = "unused"
+	ReasonRetained            Reason = "retained"
+	ReasonContainer           Reason = "container"
+	ReasonNonLeaf             Reason = "non-leaf"
+	ReasonNotFound            Reason = "not-found"
+	ReasonBelowThreshold      Reason = "below-threshold"
+	ReasonLowWaterMarkReached Reason = "low-water-mark-reached"
+)
+
+// Report describes what GC did, or in a dry run what it would do
+type Report struct {
+	DryRun            bool          `json:"dry_run"`
+	ThresholdExceeded bool          `json:"threshold_exceeded"`
+	Reclaimed         int64         `json:"reclaimed"`
+	Layers            []LayerReport `json:"layers"`
+
+	// chains are the leaves which were removed, with their ancestors
+	chains []layercake.ID
+}
+
+type LayerReport struct {
+	ID      string `json:"id"`
+	Size    int64  `json:"size"`
+	Removed bool   `json:"removed"`
+	Reason  Reason `json:"reason"`
+
+	// Parents are the ancestors of the layer, nearest first
+	Parents []string `json:"parents,omitempty"`
+
+	// References are the images the layer belongs to, where known
+	References []string `json:"references,omitempty"`
+}
+
+func (r *Report) keep(id layercake.ID, reason Reason) {
+	r.Layers = append(r.Layers, LayerReport{ID: id.GraphID(), Reason: reason})
+}
+
+func (r *Report) remove(id layercake.ID) {
+	r.Layers = append(r.Layers, LayerReport{ID: id.GraphID(), Removed: true, Reason: ReasonUnused})
+}
+
+func (r *Report) removed(id layercake.ID) bool {
+	for _, layer := range r.Layers {
+		if layer.ID == id.GraphID() {
+			return layer.Removed
+		}
+	}
+
+	return false
+}
+
+// describe fills in the sizes, parents and references of the layers in the
+// report, and adds the layers GC did not get to, which are kept since they
+// have children
+func (g *OvenCleaner) describe(cake layercake.Cake, report *Report) {
+	sizes := make(map[string]int64)
+	for _, layer := range cake.All() {
+		sizes[layer.ID] = layer.Size
+	}
+
+	reported := make(map[string]bool)
+	for _, layer := range report.Layers {
+		reported[layer.ID] = true
+	}
+
+	for _, layer := range cake.All() {
+		if !reported[layer.ID] {
+			report.keep(layercake.DockerImageID(layer.ID), ReasonNonLeaf)
+		}
+	}
+
+	parents := parents(cake)
+	for i := range report.Layers {
+		layer := &report.Layers[i]
+		layer.Size = sizes[layer.ID]
+
+		for parent := parents[layer.ID]; parent != ""; parent = parents[parent] {
+			layer.Parents = append(layer.Parents, parent)
+		}
+
+		layer.References = g.references(layer)
+	}
+}
+
+// parents returns the parent of every layer in the cake. They are asked of
+// the cake one by one rather than taken from All, since namespaced layers
+// have no parent as far as docker is concerned and the cake tracks their
+// parents itself.
+func parents(cake layercake.Cake) map[string]string {
+	parents := make(map[string]string)
+	for _, layer := range cake.All() {
+		if img, err := cake.Get(layercake.DockerImageID(layer.ID)); err == nil && img.Parent != "" {
+			parents[layer.ID] = img.Parent
+		}
+	}
+
+	return parents
+}
+
+// references returns the references of the images whose top layer is the
+// layer or, failing that, its nearest ancestor which is the top layer of one
+func (g *OvenCleaner) references(layer *LayerReport) []string {
+	if g.References == nil {
+		return nil
+	}
+
+	for _, id := range append([]string{layer.ID}, layer.Parents...) {
+		if refs := g.References.References(id); len(refs) > 0 {
+			return refs
+		}
+	}
+
+	return nil
+}
+
+// dryRunGraph pretends to remove layers from a cake, keeping track of which
+// layers would be left without children
+type dryRunGraph struct {
+	layercake.Cake
+
+	children map[string]int
+	removed  map[string]bool
+}
+
+func newDryRunGraph(cake layercake.Cake) *dryRunGraph {
+	graph := &dryRunGraph{
+		Cake:     cake,
+		children: make(map[string]int),
+		removed:  make(map[string]bool),
+	}
+
+	for _, parent := range parents(cake) {
+		graph.children[parent]++
+	}
+
+	return graph
+}
+
+func (d *dryRunGraph) Get(id layercake.ID) (*image.Image, error) {
+	if d.removed[id.GraphID()] {
+		return nil, errors.New("layer would have been removed")
+	}
+
+	return d.Cake.Get(id)
+}
+
+// IsLeaf reports whether the layer is a leaf of the cake, or would be once
+// the children of it the dry run removed were
+func (d *dryRunGraph) IsLeaf(id layercake.ID) (bool, error) {
+	if leaf, err := d.Cake.IsLeaf(id); err != nil || leaf {
+		return leaf, err
+	}
+
+	children, ok := d.children[id.GraphID()]
+	return ok && children == 0, nil
+}
+
+func (d *dryRunGraph) Remove(id layercake.ID) error {
+	img, err := d.Cake.Get(id)
+	if err != nil {
+		return err
+	}
+
+	d.removed[id.GraphID()] = true
+	if img.Parent != "" {
+		d.children[img.Parent]--
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+package cleaner_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
+	fakes "code.cloudfoundry.org/garden-shed/layercake/cleaner/cleanerfakes"
+	"code.cloudfoundry.org/garden-shed/layercake/fake_cake"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/docker/docker/image"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Planning GC", func() {
+	var (
+		gc            *cleaner.OvenCleaner
+		retainer      cleaner.RetainChecker
+		fakeCake      *fake_cake.FakeCake
+		fakeThreshold *fakes.FakeThreshold
+		logger        lager.Logger
+		layers        map[string]*image.Image
+
+		// namespacedParents are the parents of namespaced layers, which like
+		// the aufs and overlay cakes the fake cake tracks outside of docker
+		namespacedParents map[string]string
+	)
+
+	layerReport := func(report *cleaner.Report, id string) cleaner.LayerReport {
+		for _, layer := range report.Layers {
+			if layer.ID == id {
+				return layer
+			}
+		}
+
+		Fail("no report for layer " + id)
+		return cleaner.LayerReport{}
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		fakeThreshold = new(fakes.FakeThreshold)
+		fakeThreshold.ExceededReturns(true)
+		retainer = cleaner.NewRetainer()
+
+		//       base
+		//        |
+		//       top
+		//      /   \
+		// namespaced container      lone  pinned
+		//
+		// the namespaced layer has no docker parent, as in the aufs and
+		// overlay cakes
+		layers = map[string]*image.Image{
+			"base":       {ID: "base", Size: 100},
+			"top":        {ID: "top", Parent: "base", Size: 20},
+			"namespaced": {ID: "namespaced", Size: 30},
+			"container":  {ID: "container", Parent: "top", Container: "handle"},
+			"lone":       {ID: "lone", Size: 5},
+			"pinned":     {ID: "pinned", Size: 7},
+		}
+		namespacedParents = map[string]string{"namespaced": "top"}
+
+		fakeCake = new(fake_cake.FakeCake)
+		fakeCake.AllStub = func() []*image.Image {
+			var all []*image.Image
+			for _, id := range []string{"base", "top", "namespaced", "container", "lone", "pinned"} {
+				if layer, ok := layers[id]; ok {
+					all = append(all, layer)
+				}
+			}
+			return all
+		}
+		fakeCake.GetStub = func(id layercake.ID) (*image.Image, error) {
+			layer, ok := layers[id.GraphID()]
+			if !ok {
+				return nil, errors.New("no such layer")
+			}
+
+			img := *layer
+			if parent, ok := namespacedParents[id.GraphID()]; ok {
+				img.Parent = parent
+			}
+			return &img, nil
+		}
+		fakeCake.IsLeafStub = func(id layercake.ID) (bool, error) {
+			for child, layer := range layers {
+				if layer.Parent == id.GraphID() || namespacedParents[child] == id.GraphID() {
+					return false, nil
+				}
+			}
+			return true, nil
+		}
+		fakeCake.GetAllLeavesReturns([]layercake.ID{
+			layercake.DockerImageID("namespaced"),
+			layercake.DockerImageID("container"),
+			layercake.DockerImageID("lone"),
+			layercake.DockerImageID("pinned"),
+		}, nil)
+	})
+
+	JustBeforeEach(func() {
+		retainer.Retain(logger, layercake.DockerImageID("pinned"))
+
+		gc = cleaner.NewOvenCleaner(retainer, fakeThreshold)
+		gc.References = cleaner.ReferencesFunc(func(imageID string) []string {
+			if imageID == "top" {
+				return []string{"docker:///busybox"}
+			}
+			return nil
+		})
+	})
+
+	Describe("DryRun", func() {
+		It("does not remove anything", func() {
+			_, err := gc.DryRun(logger, fakeCake)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCake.RemoveCallCount()).To(Equal(0))
+		})
+
+		It("reports the unused chains as removed", func() {
+			report, err := gc.DryRun(logger, fakeCake)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.DryRun).To(BeTrue())
+			Expect(report.ThresholdExceeded).To(BeTrue())
+			Expect(report.Reclaimed).To(BeEquivalentTo(35))
+
+			Expect(layerReport(report, "namespaced")).To(Equal(cleaner.LayerReport{
+				ID:         "namespaced",
+				Size:       30,
+				Removed:    true,
+				Reason:     cleaner.ReasonUnused,
+				Parents:    []string{"top", "base"},
+				References: []string{"docker:///busybox"},
+			}))
+			Expect(layerReport(report, "lone").Removed).To(BeTrue())
+		})
+
+		It("reports why the other layers are kept", func() {
+			report, err := gc.DryRun(logger, fakeCake)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(layerReport(report, "container").Reason).To(Equal(cleaner.ReasonContainer))
+			Expect(layerReport(report, "pinned").Reason).To(Equal(cleaner.ReasonRetained))
+			Expect(layerReport(report, "top").Reason).To(Equal(cleaner.ReasonNonLeaf))
+			Expect(layerReport(report, "base").Reason).To(Equal(cleaner.ReasonNonLeaf))
+			Expect(layerReport(report, "top").References).To(Equal([]string{"docker:///busybox"}))
+			Expect(report.Layers).To(HaveLen(6))
+		})
+
+		Context("when the parent would be left without children", func() {
+			BeforeEach(func() {
+				delete(layers, "container")
+				fakeCake.GetAllLeavesReturns([]layercake.ID{
+					layercake.DockerImageID("namespaced"),
+					layercake.DockerImageID("lone"),
+					layercake.DockerImageID("pinned"),
+				}, nil)
+			})
+
+			It("reports it as removed too", func() {
+				report, err := gc.DryRun(logger, fakeCake)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(layerReport(report, "top").Removed).To(BeTrue())
+				Expect(layerReport(report, "base").Removed).To(BeTrue())
+				Expect(report.Reclaimed).To(BeEquivalentTo(155))
+			})
+		})
+
+		Context("when the low-water mark would be reached", func() {
+			JustBeforeEach(func() {
+				fakeTarget := new(fakes.FakeTarget)
+				fakeTarget.ExcessReturns(1, nil)
+				gc.LowWaterMark = fakeTarget
+			})
+
+			It("reports the remaining leaves as kept", func() {
+				report, err := gc.DryRun(logger, fakeCake)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(layerReport(report, "namespaced").Removed).To(BeTrue())
+				Expect(layerReport(report, "lone").Reason).To(Equal(cleaner.ReasonLowWaterMarkReached))
+			})
+		})
+
+		Context("when the threshold is not exceeded", func() {
+			BeforeEach(func() {
+				fakeThreshold.ExceededReturns(false)
+			})
+
+			It("reports every layer as kept", func() {
+				report, err := gc.DryRun(logger, fakeCake)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(report.ThresholdExceeded).To(BeFalse())
+				Expect(report.Reclaimed).To(BeZero())
+				Expect(layerReport(report, "lone").Reason).To(Equal(cleaner.ReasonBelowThreshold))
+				Expect(layerReport(report, "top").Reason).To(Equal(cleaner.ReasonNonLeaf))
+			})
+		})
+
+		Context("when getting the list of leaves fails", func() {
+			It("returns the error", func() {
+				fakeCake.GetAllLeavesReturns(nil, errors.New("firey potato"))
+				_, err := gc.DryRun(logger, fakeCake)
+				Expect(err).To(MatchError("firey potato"))
+			})
+		})
+	})
+
+	Describe("Candidates", func() {
+		It("returns the leaves whose chains would be removed, without removing anything", func() {
+			Expect(gc.Candidates(logger, fakeCake)).To(Equal([]layercake.ID{
+				layercake.DockerImageID("namespaced"),
+				layercake.DockerImageID("lone"),
+			}))
+			Expect(fakeCake.RemoveCallCount()).To(Equal(0))
+		})
+
+		Context("when removing a namespaced chain would reach the low-water mark", func() {
+			BeforeEach(func() {
+				delete(layers, "container")
+			})
+
+			JustBeforeEach(func() {
+				fakeTarget := new(fakes.FakeTarget)
+				fakeTarget.ExcessReturns(150, nil)
+				gc.LowWaterMark = fakeTarget
+			})
+
+			It("counts its ancestors towards it and picks no further chains", func() {
+				Expect(gc.Candidates(logger, fakeCake)).To(Equal([]layercake.ID{
+					layercake.DockerImageID("namespaced"),
+				}))
+			})
+		})
+
+		Context("when the threshold is not exceeded", func() {
+			BeforeEach(func() {
+				fakeThreshold.ExceededReturns(false)
+			})
+
+			It("returns nothing", func() {
+				Expect(gc.Candidates(logger, fakeCake)).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("RemoveChain", func() {
+		BeforeEach(func() {
+			fakeCake.RemoveStub = func(id layercake.ID) error {
+				delete(layers, id.GraphID())
+				return nil
+			}
+		})
+
+		It("removes the leaf and returns how much it freed", func() {
+			Expect(gc.RemoveChain(logger, fakeCake, layercake.DockerImageID("namespaced"))).To(BeEquivalentTo(30))
+			Expect(fakeCake.RemoveCallCount()).To(Equal(1))
+			Expect(fakeCake.RemoveArgsForCall(0)).To(Equal(layercake.DockerImageID("namespaced")))
+		})
+
+		Context("when the ancestors are left without children", func() {
+			BeforeEach(func() {
+				delete(layers, "container")
+			})
+
+			It("removes them too", func() {
+				Expect(gc.RemoveChain(logger, fakeCake, layercake.DockerImageID("namespaced"))).To(BeEquivalentTo(150))
+				Expect(fakeCake.RemoveCallCount()).To(Equal(3))
+			})
+		})
+
+		Context("when the leaf has since been used for a container", func() {
+			BeforeEach(func() {
+				layers["new-container"] = &image.Image{ID: "new-container", Parent: "lone", Container: "new-handle"}
+			})
+
+			It("leaves it alone", func() {
+				Expect(gc.RemoveChain(logger, fakeCake, layercake.DockerImageID("lone"))).To(BeZero())
+				Expect(fakeCake.RemoveCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the leaf has since been retained", func() {
+			It("leaves it alone", func() {
+				Expect(gc.RemoveChain(logger, fakeCake, layercake.DockerImageID("pinned"))).To(BeZero())
+				Expect(fakeCake.RemoveCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the last use of the layer was recorded", func() {
+			It("forgets it", func() {
+				lastUsed := &cleaner.LastUsed{}
+				Expect(lastUsed.Touch(layercake.DockerImageID("lone"))).To(Succeed())
+				gc.LastUsed = lastUsed
+
+				_, err := gc.RemoveChain(logger, fakeCake, layercake.DockerImageID("lone"))
+				Expect(err).NotTo(HaveOccurred())
+
+				_, ok := lastUsed.Get(layercake.DockerImageID("lone"))
+				Expect(ok).To(BeFalse())
+			})
+		})
+
+		Context("when removing fails", func() {
+			It("returns the error", func() {
+				fakeCake.RemoveStub = nil
+				fakeCake.RemoveReturns(errors.New("cake failure"))
+				_, err := gc.RemoveChain(logger, fakeCake, layercake.DockerImageID("lone"))
+				Expect(err).To(MatchError("cake failure"))
+			})
+		})
+	})
+})
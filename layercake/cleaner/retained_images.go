@@ -0,0 +1,132 @@
+package cleaner
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/pkg/atomicfile"
+	"code.cloudfoundry.org/lager"
+)
+
+//go:generate counterfeiter . ImageResolver
+type ImageResolver interface {
+	// Resolve returns the layers GC has to keep for the image to be retained
+	Resolve(log lager.Logger, image string) ([]layercake.ID, error)
+}
+
+// RetainedImages is a Checker of images retained at runtime, which GC keeps
+// until they are released. Images are saved to Path along with the layers
+// they resolved to, so that once loaded again they are retained straight away
+// without resolving them.
+type RetainedImages struct {
+	Path     string
+	Resolver ImageResolver
+
+	mu     sync.RWMutex
+	images map[string][]string
+}
+
+type retainedImagesFile struct {
+	Images map[string][]string `json:"images"`
+}
+
+// LoadRetainedImages loads the images saved at path, or starts with none if
+// there are none yet
+func LoadRetainedImages(path string, resolver ImageResolver) (*RetainedImages, error) {
+	retained := &RetainedImages{
+		Path:     path,
+		Resolver: resolver,
+		images:   make(map[string][]string),
+	}
+
+	if err := atomicfile.JSONFile(path).Load(&retainedImagesFile{Images: retained.images}); err != nil {
+		return nil, err
+	}
+
+	return retained, nil
+}
+
+// Retain resolves the image and keeps its layers until it is released.
+// Retaining an image again resolves it again, so a tag is pinned to whatever
+// it refers to at the time.
+func (r *RetainedImages) Retain(log lager.Logger, image string) error {
+	log = log.Session("retain-image", lager.Data{"image": image})
+
+	if r.Resolver == nil {
+		return errors.New("retained images: no image resolver")
+	}
+
+	ids, err := r.Resolver.Resolve(log, image)
+	if err != nil {
+		return fmt.Errorf("retained images: resolve %s: %s", image, err)
+	}
+
+	layers := make([]string, 0, len(ids))
+	for _, id := range ids {
+		layers = append(layers, id.GraphID())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.images == nil {
+		r.images = make(map[string][]string)
+	}
+
+	r.images[image] = layers
+	log.Info("retained", lager.Data{"layers": layers})
+
+	return r.save()
+}
+
+// Release stops retaining the image, so that GC can remove it once it is
+// unused. Releasing an image which is not retained does nothing.
+func (r *RetainedImages) Release(log lager.Logger, image string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.images[image]; !ok {
+		return nil
+	}
+
+	delete(r.images, image)
+	log.Info("released-image", lager.Data{"image": image})
+
+	return r.save()
+}
+
+// Retained returns the retained images, in order
+func (r *RetainedImages) Retained() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	images := make([]string, 0, len(r.images))
+	for image := range r.images {
+		images = append(images, image)
+	}
+
+	sort.Strings(images)
+	return images
+}
+
+func (r *RetainedImages) Check(id layercake.ID) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, layers := range r.images {
+		for _, layer := range layers {
+			if layer == id.GraphID() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (r *RetainedImages) save() error {
+	return atomicfile.JSONFile(r.Path).Save(retainedImagesFile{Images: r.images})
+}
@@ -0,0 +1,165 @@
+package cleaner_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
+	fakes "code.cloudfoundry.org/garden-shed/layercake/cleaner/cleanerfakes"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetainedImages", func() {
+	var (
+		dir          string
+		path         string
+		logger       *lagertest.TestLogger
+		fakeResolver *fakes.FakeImageResolver
+		retained     *cleaner.RetainedImages
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "retained-images")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = filepath.Join(dir, "garden-info", "retained-images.json")
+		logger = lagertest.NewTestLogger("test")
+
+		fakeResolver = new(fakes.FakeImageResolver)
+		fakeResolver.ResolveStub = func(_ lager.Logger, image string) ([]layercake.ID, error) {
+			return []layercake.ID{
+				layercake.DockerImageID(image),
+				layercake.NamespacedID(layercake.DockerImageID(image), "namespaced"),
+			}, nil
+		}
+
+		retained, err = cleaner.LoadRetainedImages(path, fakeResolver)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("does not retain anything to begin with", func() {
+		Expect(retained.Retained()).To(BeEmpty())
+		Expect(retained.Check(layercake.DockerImageID("some-image"))).To(BeFalse())
+	})
+
+	It("retains the layers the image resolves to", func() {
+		Expect(retained.Retain(logger, "some-image")).To(Succeed())
+
+		Expect(fakeResolver.ResolveCallCount()).To(Equal(1))
+		_, image := fakeResolver.ResolveArgsForCall(0)
+		Expect(image).To(Equal("some-image"))
+
+		Expect(retained.Retained()).To(Equal([]string{"some-image"}))
+		Expect(retained.Check(layercake.DockerImageID("some-image"))).To(BeTrue())
+		Expect(retained.Check(layercake.NamespacedID(layercake.DockerImageID("some-image"), "namespaced"))).To(BeTrue())
+		Expect(retained.Check(layercake.DockerImageID("some-other-image"))).To(BeFalse())
+	})
+
+	It("lists the retained images in order", func() {
+		Expect(retained.Retain(logger, "b")).To(Succeed())
+		Expect(retained.Retain(logger, "a")).To(Succeed())
+
+		Expect(retained.Retained()).To(Equal([]string{"a", "b"}))
+	})
+
+	It("pins the image to the layers it resolves to when it is retained again", func() {
+		Expect(retained.Retain(logger, "some-image")).To(Succeed())
+
+		fakeResolver.ResolveStub = nil
+		fakeResolver.ResolveReturns([]layercake.ID{layercake.DockerImageID("new-layer")}, nil)
+		Expect(retained.Retain(logger, "some-image")).To(Succeed())
+
+		Expect(retained.Check(layercake.DockerImageID("new-layer"))).To(BeTrue())
+		Expect(retained.Check(layercake.DockerImageID("some-image"))).To(BeFalse())
+	})
+
+	It("persists the images across loads, without resolving them again", func() {
+		Expect(retained.Retain(logger, "some-image")).To(Succeed())
+
+		loaded, err := cleaner.LoadRetainedImages(path, fakeResolver)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(loaded.Retained()).To(Equal([]string{"some-image"}))
+		Expect(loaded.Check(layercake.DockerImageID("some-image"))).To(BeTrue())
+		Expect(fakeResolver.ResolveCallCount()).To(Equal(1))
+	})
+
+	Context("when the image cannot be resolved", func() {
+		BeforeEach(func() {
+			fakeResolver.ResolveStub = nil
+			fakeResolver.ResolveReturns(nil, errors.New("potato"))
+		})
+
+		It("returns the error without retaining anything", func() {
+			Expect(retained.Retain(logger, "some-image")).To(MatchError(ContainSubstring("potato")))
+			Expect(retained.Retained()).To(BeEmpty())
+		})
+	})
+
+	Context("when there is no resolver", func() {
+		It("returns an error", func() {
+			retained.Resolver = nil
+			Expect(retained.Retain(logger, "some-image")).NotTo(Succeed())
+		})
+	})
+
+	Describe("Release", func() {
+		BeforeEach(func() {
+			Expect(retained.Retain(logger, "some-image")).To(Succeed())
+			Expect(retained.Retain(logger, "some-other-image")).To(Succeed())
+		})
+
+		It("stops retaining the image", func() {
+			Expect(retained.Release(logger, "some-image")).To(Succeed())
+
+			Expect(retained.Retained()).To(Equal([]string{"some-other-image"}))
+			Expect(retained.Check(layercake.DockerImageID("some-image"))).To(BeFalse())
+			Expect(retained.Check(layercake.DockerImageID("some-other-image"))).To(BeTrue())
+		})
+
+		It("persists the release", func() {
+			Expect(retained.Release(logger, "some-image")).To(Succeed())
+
+			loaded, err := cleaner.LoadRetainedImages(path, fakeResolver)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loaded.Retained()).To(Equal([]string{"some-other-image"}))
+		})
+
+		It("does nothing for an image which is not retained", func() {
+			Expect(retained.Release(logger, "never-retained")).To(Succeed())
+			Expect(retained.Retained()).To(HaveLen(2))
+		})
+
+		Context("when a layer is shared with another retained image", func() {
+			It("keeps retaining the layer", func() {
+				fakeResolver.ResolveStub = nil
+				fakeResolver.ResolveReturns([]layercake.ID{layercake.DockerImageID("some-other-image")}, nil)
+				Expect(retained.Retain(logger, "some-other-tag")).To(Succeed())
+
+				Expect(retained.Release(logger, "some-other-image")).To(Succeed())
+				Expect(retained.Check(layercake.DockerImageID("some-other-image"))).To(BeTrue())
+			})
+		})
+	})
+
+	Context("when the file is corrupt", func() {
+		It("returns an error", func() {
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path, []byte("{"), 0644)).To(Succeed())
+
+			_, err := cleaner.LoadRetainedImages(path, fakeResolver)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -1,23 +1,34 @@
 package cleaner
 
 import (
+	"os"
+	"path/filepath"
+	"syscall"
+
 	"code.cloudfoundry.org/garden-shed/layercake"
 	"code.cloudfoundry.org/lager"
 )
 
-type threshold int64
+type threshold struct {
+	limit int64
+	paths []string
+}
+
 type disabled bool
 
-func NewThreshold(limit int64) Threshold {
+// NewThreshold returns a Threshold which is exceeded once the layers in the
+// graph, plus the files under paths, such as backing stores and partial
+// downloads, add up to more than limit bytes
+func NewThreshold(limit int64, paths ...string) Threshold {
 	if limit < 0 {
 		return disabled(false)
 	}
 
-	return threshold(limit)
+	return threshold{limit: limit, paths: paths}
 }
 
 func (t threshold) Exceeded(log lager.Logger, cake layercake.Cake) bool {
-	log = log.Session("threshold", lager.Data{"limit": t})
+	log = log.Session("threshold", lager.Data{"limit": t.limit})
 	log.Info("start")
 
 	var size int64
@@ -25,12 +36,18 @@ func (t threshold) Exceeded(log lager.Logger, cake layercake.Cake) bool {
 		size += layer.Size
 
 		log.Info("layer", lager.Data{"size": layer.Size, "total": size})
-		if size > int64(t) {
+		if size > t.limit {
 			log.Info("finish", lager.Data{"exceeded": true})
 			return true
 		}
 	}
 
+	size += filesSize(log, t.paths)
+	if size > t.limit {
+		log.Info("finish", lager.Data{"exceeded": true, "total": size})
+		return true
+	}
+
 	log.Info("finish", lager.Data{"exceeded": false})
 	return false
 }
@@ -38,3 +55,68 @@ func (t threshold) Exceeded(log lager.Logger, cake layercake.Cake) bool {
 func (disabled) Exceeded(log lager.Logger, cake layercake.Cake) bool {
 	return false
 }
+
+// lowWaterMark is a Target of a total layer and file size
+type lowWaterMark threshold
+
+// NewLowWaterMark returns a Target which GC reaches once the layers in the
+// graph, plus the files under paths, add up to no more than limit bytes
+func NewLowWaterMark(limit int64, paths ...string) Target {
+	return lowWaterMark{limit: limit, paths: paths}
+}
+
+func (l lowWaterMark) Excess(log lager.Logger, cake layercake.Cake) (int64, error) {
+	var size int64
+	for _, layer := range cake.All() {
+		size += layer.Size
+	}
+
+	size += filesSize(log, l.paths)
+
+	log.Info("low-water-mark", lager.Data{"limit": l.limit, "total": size})
+	if size <= l.limit {
+		return 0, nil
+	}
+
+	return size - l.limit, nil
+}
+
+// filesSize returns the space taken by the files under paths, skipping empty
+// ones. Backing stores are sparse, so it counts the blocks allocated to files
+// rather than their apparent sizes.
+func filesSize(log lager.Logger, paths []string) int64 {
+	var size int64
+	for _, path := range paths {
+		if path != "" {
+			size += pathSize(log, path)
+		}
+	}
+
+	return size
+}
+
+func pathSize(log lager.Logger, path string) int64 {
+	var size int64
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			size += int64(stat.Blocks) * 512
+		} else {
+			size += info.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Error("measure-files-failed", err, lager.Data{"path": path})
+	}
+
+	return size
+}
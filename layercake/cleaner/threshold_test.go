@@ -1,6 +1,10 @@
 package cleaner_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
 	"code.cloudfoundry.org/garden-shed/layercake/fake_cake"
 	"code.cloudfoundry.org/lager"
@@ -116,3 +120,103 @@ var _ = Describe("Threshold", func() {
 		})
 	})
 })
+
+var _ = Describe("Threshold with backing stores", func() {
+	var (
+		fakeCake      *fake_cake.FakeCake
+		logger        lager.Logger
+		backingStores string
+	)
+
+	BeforeEach(func() {
+		fakeCake = new(fake_cake.FakeCake)
+		logger = lagertest.NewTestLogger("test")
+
+		var err error
+		backingStores, err = ioutil.TempDir("", "backing-stores")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(backingStores, "container-id"), make([]byte, 8192), 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(backingStores)).To(Succeed())
+	})
+
+	It("counts the backing stores along with the layers", func() {
+		Expect(cleaner.NewThreshold(4096).Exceeded(logger, fakeCake)).To(BeFalse())
+		Expect(cleaner.NewThreshold(4096, backingStores).Exceeded(logger, fakeCake)).To(BeTrue())
+	})
+
+	It("counts the backing stores towards the low-water mark", func() {
+		fakeCake.AllReturns([]*image.Image{{Size: 1000}})
+
+		excess, err := cleaner.NewLowWaterMark(1000, backingStores).Excess(logger, fakeCake)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(excess).To(BeNumerically(">=", 8192))
+	})
+
+	Context("when there are other files to count, such as partial downloads", func() {
+		var partials string
+
+		BeforeEach(func() {
+			var err error
+			partials, err = ioutil.TempDir("", "partial-blobs")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(filepath.Join(partials, "sha256-abc"), make([]byte, 8192), 0600)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(partials)).To(Succeed())
+		})
+
+		It("counts them along with the backing stores", func() {
+			Expect(cleaner.NewThreshold(12288, backingStores).Exceeded(logger, fakeCake)).To(BeFalse())
+			Expect(cleaner.NewThreshold(12288, backingStores, partials).Exceeded(logger, fakeCake)).To(BeTrue())
+		})
+
+		It("counts them towards the low-water mark", func() {
+			excess, err := cleaner.NewLowWaterMark(0, backingStores, partials).Excess(logger, fakeCake)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(excess).To(BeNumerically(">=", 16384))
+		})
+	})
+
+	Context("when the backing stores directory does not exist", func() {
+		It("counts only the layers", func() {
+			fakeCake.AllReturns([]*image.Image{{Size: 1000}})
+			Expect(cleaner.NewThreshold(4096, filepath.Join(backingStores, "missing")).Exceeded(logger, fakeCake)).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("LowWaterMark", func() {
+	var (
+		fakeCake *fake_cake.FakeCake
+		logger   lager.Logger
+	)
+
+	BeforeEach(func() {
+		fakeCake = new(fake_cake.FakeCake)
+		logger = lagertest.NewTestLogger("test")
+
+		fakeCake.AllReturns([]*image.Image{{Size: 600}, {Size: 400}})
+	})
+
+	Context("when the layers add up to more than the limit", func() {
+		It("returns how much they exceed it by", func() {
+			excess, err := cleaner.NewLowWaterMark(700).Excess(logger, fakeCake)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(excess).To(BeEquivalentTo(300))
+		})
+	})
+
+	Context("when the layers add up to no more than the limit", func() {
+		It("returns zero", func() {
+			excess, err := cleaner.NewLowWaterMark(1000).Excess(logger, fakeCake)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(excess).To(BeZero())
+		})
+	})
+})
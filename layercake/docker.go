@@ -72,8 +72,8 @@ func (d *Docker) Path(id ID) (result string, err error) {
 }
 
 func (d *Docker) QuotaedPath(id ID, quota int64) (string, error) {
-	if d.DriverName() == "aufs" {
-		return d.Driver.(QuotaedDriver).GetQuotaed(id.GraphID(), "", quota)
+	if driver, ok := d.Driver.(QuotaedDriver); ok {
+		return driver.GetQuotaed(id.GraphID(), "", quota)
 	} else {
 		return "", errors.New("quotas are not supported for this driver")
 	}
@@ -111,6 +111,20 @@ type LocalImageID struct {
 	ModifiedTime time.Time
 }
 
+// LocalArchiveID identifies a rootfs tarball by the digest of its content, so
+// that copies of the same archive share a layer wherever they live
+type LocalArchiveID struct {
+	Path   string
+	Digest string
+}
+
+// LocalTreeID identifies a rootfs directory by a hash over the content,
+// modes and ownership of everything in it
+type LocalTreeID struct {
+	Path   string
+	Digest string
+}
+
 type NamespacedLayerID struct {
 	LayerID  ID
 	CacheKey string
@@ -132,6 +146,14 @@ func (c LocalImageID) GraphID() string {
 	return shaID(fmt.Sprintf("%s-%d", c.Path, c.ModifiedTime.Nanosecond()))
 }
 
+func (a LocalArchiveID) GraphID() string {
+	return shaID("archive:" + a.Digest)
+}
+
+func (t LocalTreeID) GraphID() string {
+	return shaID("tree:" + t.Digest)
+}
+
 func (n NamespacedLayerID) GraphID() string {
 	return shaID(n.LayerID.GraphID() + "@" + n.CacheKey)
 }
@@ -0,0 +1,33 @@
+package layercake
+
+import "github.com/docker/docker/image"
+
+// OverlayCake is the overlay counterpart of AufsCake. Overlay, like aufs,
+// cannot chown a lower layer in place, so namespaced layers are created as
+// fresh base layers containing a copy of their parent, and the parent/child
+// relationship is tracked under GraphRoot exactly as AufsCake does it.
+type OverlayCake AufsCake
+
+func (o *OverlayCake) Create(childID, parentID ID, id string) error {
+	return o.aufs().Create(childID, parentID, id)
+}
+
+func (o *OverlayCake) IsLeaf(id ID) (bool, error) {
+	return o.aufs().IsLeaf(id)
+}
+
+func (o *OverlayCake) GetAllLeaves() ([]ID, error) {
+	return o.aufs().GetAllLeaves()
+}
+
+func (o *OverlayCake) Get(id ID) (*image.Image, error) {
+	return o.aufs().Get(id)
+}
+
+func (o *OverlayCake) Remove(id ID) error {
+	return o.aufs().Remove(id)
+}
+
+func (o *OverlayCake) aufs() *AufsCake {
+	return (*AufsCake)(o)
+}
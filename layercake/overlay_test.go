@@ -0,0 +1,129 @@
+package layercake_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/commandrunner/linux_command_runner"
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/layercake/fake_cake"
+	"code.cloudfoundry.org/garden-shed/layercake/fake_id"
+	"github.com/docker/docker/image"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Overlay", func() {
+	var (
+		overlayCake        *layercake.OverlayCake
+		cake               *fake_cake.FakeCake
+		parentID           *fake_id.FakeID
+		namespacedChildID  layercake.ID
+		baseDirectory      string
+		parentDir          string
+		namespacedChildDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		baseDirectory, err = ioutil.TempDir("", "overlayTestGraphRoot")
+		Expect(err).NotTo(HaveOccurred())
+
+		parentDir, err = ioutil.TempDir("", "parent-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		namespacedChildDir, err = ioutil.TempDir("", "namespaced-child-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		parentID = new(fake_id.FakeID)
+		parentID.GraphIDReturns("graph-id")
+		namespacedChildID = layercake.NamespacedID(parentID, "test")
+
+		cake = new(fake_cake.FakeCake)
+		cake.PathStub = func(id layercake.ID) (string, error) {
+			if id == parentID {
+				return parentDir, nil
+			}
+
+			return namespacedChildDir, nil
+		}
+		cake.GetReturns(&image.Image{}, nil)
+		cake.IsLeafReturns(true, nil)
+		cake.GetAllLeavesReturns([]layercake.ID{parentID, layercake.DockerImageID(namespacedChildID.GraphID())}, nil)
+
+		overlayCake = &layercake.OverlayCake{
+			Cake:      cake,
+			Runner:    linux_command_runner.New(),
+			GraphRoot: baseDirectory,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(baseDirectory)).To(Succeed())
+		Expect(os.RemoveAll(parentDir)).To(Succeed())
+		Expect(os.RemoveAll(namespacedChildDir)).To(Succeed())
+	})
+
+	Describe("Create", func() {
+		Context("when the image ID is not namespaced", func() {
+			It("should delegate to the cake", func() {
+				Expect(overlayCake.Create(layercake.ContainerID("potato"), parentID, "potato")).To(Succeed())
+				Expect(cake.CreateCallCount()).To(Equal(1))
+				cid, iid, containerID := cake.CreateArgsForCall(0)
+				Expect(cid).To(Equal(layercake.ContainerID("potato")))
+				Expect(iid).To(Equal(parentID))
+				Expect(containerID).To(Equal("potato"))
+			})
+		})
+
+		Context("when the image ID is namespaced", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filepath.Join(parentDir, "somefile"), []byte("somecontents"), 0755)).To(Succeed())
+			})
+
+			It("creates a layer with no parent containing a copy of the parent", func() {
+				Expect(overlayCake.Create(namespacedChildID, parentID, "")).To(Succeed())
+
+				layerID, layerParentID, _ := cake.CreateArgsForCall(0)
+				Expect(layerID).To(Equal(namespacedChildID))
+				Expect(layerParentID).To(Equal(layercake.DockerImageID("")))
+				Expect(filepath.Join(namespacedChildDir, "somefile")).To(BeAnExistingFile())
+			})
+		})
+	})
+
+	Describe("Parent-child relationship", func() {
+		JustBeforeEach(func() {
+			Expect(overlayCake.Create(namespacedChildID, parentID, "")).To(Succeed())
+		})
+
+		It("does not consider the parent a leaf", func() {
+			isLeaf, err := overlayCake.IsLeaf(parentID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(isLeaf).To(BeFalse())
+		})
+
+		It("does not return the parent as a leaf", func() {
+			leaves, err := overlayCake.GetAllLeaves()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leaves).To(ConsistOf(layercake.DockerImageID(namespacedChildID.GraphID())))
+		})
+
+		It("returns the parent of the namespaced layer", func() {
+			img, err := overlayCake.Get(namespacedChildID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(img.Parent).To(Equal(parentID.GraphID()))
+		})
+
+		Context("when the namespaced layer is removed", func() {
+			It("makes the parent a leaf again", func() {
+				Expect(overlayCake.Remove(namespacedChildID)).To(Succeed())
+
+				isLeaf, err := overlayCake.IsLeaf(parentID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(isLeaf).To(BeTrue())
+			})
+		})
+	})
+})
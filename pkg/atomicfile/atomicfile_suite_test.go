@@ -0,0 +1,13 @@
+package atomicfile_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestAtomicfile(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Atomicfile Suite")
+}
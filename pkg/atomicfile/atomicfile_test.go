@@ -0,0 +1,56 @@
+package atomicfile_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/garden-shed/pkg/atomicfile"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteFile", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "atomicfile")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("writes the file, creating its directory", func() {
+		path := filepath.Join(dir, "some", "dir", "file.json")
+		Expect(atomicfile.WriteFile(path, []byte("hello"))).To(Succeed())
+
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte("hello")))
+	})
+
+	It("replaces an existing file", func() {
+		path := filepath.Join(dir, "file.json")
+		Expect(ioutil.WriteFile(path, []byte("old contents"), 0644)).To(Succeed())
+
+		Expect(atomicfile.WriteFile(path, []byte("new"))).To(Succeed())
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte("new")))
+	})
+
+	It("does not leave temporary files behind", func() {
+		path := filepath.Join(dir, "file.json")
+		Expect(atomicfile.WriteFile(path, []byte("hello"))).To(Succeed())
+
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+	})
+
+	Context("when the directory cannot be created", func() {
+		It("returns an error", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "not-a-dir"), nil, 0644)).To(Succeed())
+			Expect(atomicfile.WriteFile(filepath.Join(dir, "not-a-dir", "file.json"), nil)).NotTo(Succeed())
+		})
+	})
+})
@@ -0,0 +1,44 @@
+package atomicfile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// JSONFile is the path of a file holding a value encoded as JSON, which is
+// saved with WriteFile. An empty path stands for a value that is only kept in
+// memory: there is nothing to load and saving does nothing.
+type JSONFile string
+
+// Load decodes the file into v, leaving v as it is if nothing has been saved
+// yet
+func (f JSONFile) Load(v interface{}) error {
+	if f == "" {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(contents, v)
+}
+
+// Save encodes v and writes it to the file
+func (f JSONFile) Save(v interface{}) error {
+	if f == "" {
+		return nil
+	}
+
+	contents, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(string(f), contents)
+}
@@ -0,0 +1,82 @@
+package atomicfile_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/garden-shed/pkg/atomicfile"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSONFile", func() {
+	type contents struct {
+		Names []string `json:"names"`
+	}
+
+	var (
+		dir  string
+		file atomicfile.JSONFile
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "json-file")
+		Expect(err).NotTo(HaveOccurred())
+
+		file = atomicfile.JSONFile(filepath.Join(dir, "garden-info", "file.json"))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("loads what was saved", func() {
+		Expect(file.Save(contents{Names: []string{"a", "b"}})).To(Succeed())
+
+		var loaded contents
+		Expect(file.Load(&loaded)).To(Succeed())
+		Expect(loaded.Names).To(Equal([]string{"a", "b"}))
+	})
+
+	It("saves the value as JSON", func() {
+		Expect(file.Save(contents{Names: []string{"a"}})).To(Succeed())
+		Expect(ioutil.ReadFile(string(file))).To(MatchJSON(`{"names": ["a"]}`))
+	})
+
+	Context("when nothing has been saved yet", func() {
+		It("loads nothing, without failing", func() {
+			var loaded contents
+			Expect(file.Load(&loaded)).To(Succeed())
+			Expect(loaded.Names).To(BeNil())
+		})
+	})
+
+	Context("when the file is corrupt", func() {
+		It("returns an error", func() {
+			Expect(os.MkdirAll(filepath.Dir(string(file)), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(string(file), []byte("{"), 0644)).To(Succeed())
+
+			var loaded contents
+			Expect(file.Load(&loaded)).NotTo(Succeed())
+		})
+	})
+
+	Context("when there is no path", func() {
+		BeforeEach(func() {
+			file = ""
+		})
+
+		It("saves nothing", func() {
+			Expect(file.Save(contents{Names: []string{"a"}})).To(Succeed())
+			Expect(ioutil.ReadDir(dir)).To(BeEmpty())
+		})
+
+		It("loads nothing", func() {
+			var loaded contents
+			Expect(file.Load(&loaded)).To(Succeed())
+			Expect(loaded.Names).To(BeNil())
+		})
+	})
+})
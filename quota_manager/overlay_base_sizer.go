@@ -0,0 +1,22 @@
+package quota_manager
+
+import (
+	"path/filepath"
+
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/lager"
+)
+
+// OverlayBaseSizer sizes overlay rootfses, whose paths end in a "merged" or
+// "root" directory inside the layer directory rather than in the layer ID.
+type OverlayBaseSizer struct {
+	aufsBaseSizer *AUFSBaseSizer
+}
+
+func NewOverlayBaseSizer(cake layercake.Cake) *OverlayBaseSizer {
+	return &OverlayBaseSizer{aufsBaseSizer: NewAUFSBaseSizer(cake)}
+}
+
+func (o *OverlayBaseSizer) BaseSize(logger lager.Logger, containerRootFSPath string) (uint64, error) {
+	return o.aufsBaseSizer.BaseSize(logger, filepath.Dir(containerRootFSPath))
+}
@@ -0,0 +1,45 @@
+package quota_manager_test
+
+import (
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/layercake/fake_cake"
+	"code.cloudfoundry.org/garden-shed/quota_manager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/docker/docker/image"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OverlayBaseSizer", func() {
+	Describe("BaseSize", func() {
+		var (
+			baseSizer *quota_manager.OverlayBaseSizer
+			fakeCake  *fake_cake.FakeCake
+		)
+
+		BeforeEach(func() {
+			fakeCake = new(fake_cake.FakeCake)
+			fakeCake.GetStub = func(id layercake.ID) (*image.Image, error) {
+				if id.GraphID() == "54321" {
+					return &image.Image{Size: 10, Parent: "12345"}, nil
+				}
+
+				return &image.Image{Size: 5}, nil
+			}
+
+			baseSizer = quota_manager.NewOverlayBaseSizer(fakeCake)
+		})
+
+		It("asks for the size of the layer containing the merged directory", func() {
+			baseSizer.BaseSize(lagertest.NewTestLogger("test"), "/some/overlay/54321/merged")
+			Expect(fakeCake.GetArgsForCall(0)).To(Equal(layercake.DockerImageID("54321")))
+		})
+
+		It("returns the size of the layer and its parents", func() {
+			size, err := baseSizer.BaseSize(lagertest.NewTestLogger("test"), "/some/overlay/54321/merged")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(BeEquivalentTo(15))
+		})
+	})
+})
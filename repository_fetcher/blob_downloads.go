@@ -0,0 +1,158 @@
+package repository_fetcher
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/docker/distribution/digest"
+)
+
+// blobDownloads shares one download of a blob between the layers waiting for
+// it at the same time, whether they are at more than one position of an
+// image, like the empty layers of schema1 images, or belong to images being
+// fetched at once. Each layer reads the blob on its own, and the blob is
+// closed once the last of them is done with it. The zero value is ready to
+// use.
+type blobDownloads struct {
+	mu        sync.Mutex
+	downloads map[digest.Digest]*blobDownload
+}
+
+type blobDownload struct {
+	done    chan struct{}
+	blob    io.ReaderAt
+	closer  io.Closer
+	size    int64
+	err     error
+	readers int
+}
+
+// get returns a reader of the verified blob d and its size. The blob is
+// downloaded with download, unless a download of it is in progress already or
+// still being read, in which case that one is waited for instead. A download
+// that fails is not shared with the layers which ask for the blob afterwards.
+func (b *blobDownloads) get(d digest.Digest, download func() (io.ReadCloser, int64, error)) (*sharedBlob, int64, error) {
+	b.mu.Lock()
+	if b.downloads == nil {
+		b.downloads = make(map[digest.Digest]*blobDownload)
+	}
+
+	shared, inProgress := b.downloads[d]
+	if !inProgress {
+		shared = &blobDownload{done: make(chan struct{})}
+		b.downloads[d] = shared
+	}
+
+	shared.readers++
+	b.mu.Unlock()
+
+	if !inProgress {
+		b.complete(d, shared, download)
+	}
+
+	<-shared.done
+
+	if shared.err != nil {
+		b.release(d, shared)
+		return nil, 0, shared.err
+	}
+
+	return &sharedBlob{
+		SectionReader: io.NewSectionReader(shared.blob, 0, math.MaxInt64),
+		release:       func() { b.release(d, shared) },
+	}, shared.size, nil
+}
+
+func (b *blobDownloads) complete(d digest.Digest, shared *blobDownload, download func() (io.ReadCloser, int64, error)) {
+	defer close(shared.done)
+
+	blob, size, err := download()
+	if err == nil {
+		shared.blob, shared.closer, err = readerAt(blob)
+	}
+
+	if err != nil {
+		b.mu.Lock()
+		delete(b.downloads, d)
+		b.mu.Unlock()
+
+		shared.err = err
+		return
+	}
+
+	shared.size = size
+}
+
+func (b *blobDownloads) release(d digest.Digest, shared *blobDownload) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if shared.readers--; shared.readers > 0 {
+		return
+	}
+
+	if b.downloads[d] == shared {
+		delete(b.downloads, d)
+	}
+
+	if shared.closer != nil {
+		shared.closer.Close()
+	}
+}
+
+// readerAt returns the blob as something each of its readers can read from
+// where they are. A verified blob is normally a file, but one which is not is
+// copied to a temporary file.
+func readerAt(blob io.ReadCloser) (io.ReaderAt, io.Closer, error) {
+	if ra, ok := blob.(io.ReaderAt); ok {
+		return ra, blob, nil
+	}
+
+	defer blob.Close()
+
+	tmp, err := ioutil.TempFile("", "shared-blob")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := io.Copy(tmp, blob); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, nil, err
+	}
+
+	return tmp, &removeCloser{tmp}, nil
+}
+
+// sharedBlob is one reader of a shared download
+type sharedBlob struct {
+	*io.SectionReader
+
+	release func()
+	closed  bool
+}
+
+func (s *sharedBlob) Close() error {
+	if !s.closed {
+		s.closed = true
+		s.release()
+	}
+
+	return nil
+}
+
+type removeCloser struct {
+	*os.File
+}
+
+func (rc *removeCloser) Close() error {
+	err := rc.File.Close()
+	if rerr := os.Remove(rc.File.Name()); rerr != nil && err == nil {
+		err = rerr
+	}
+
+	return err
+}
@@ -15,6 +15,10 @@ type CompositeFetcher struct {
 
 	// fetchers used for docker:// urls, depending on the version
 	RemoteFetcher RepositoryFetcher
+
+	// fetcher used for oci:// and oci-layout:// urls, which refer to image
+	// layouts on the local filesystem
+	LayoutFetcher RepositoryFetcher
 }
 
 func (f *CompositeFetcher) Fetch(log lager.Logger, repoURL *url.URL, username, password string, diskQuota int64) (*Image, error) {
@@ -22,6 +26,10 @@ func (f *CompositeFetcher) Fetch(log lager.Logger, repoURL *url.URL, username, p
 		return f.LocalFetcher.Fetch(log, repoURL, "", "", diskQuota)
 	}
 
+	if IsLayoutURL(repoURL) {
+		return f.LayoutFetcher.Fetch(log, repoURL, "", "", diskQuota)
+	}
+
 	return f.RemoteFetcher.Fetch(log, repoURL, username, password, diskQuota)
 }
 
@@ -30,9 +38,19 @@ func (f *CompositeFetcher) FetchID(log lager.Logger, repoURL *url.URL) (layercak
 		return f.LocalFetcher.FetchID(log, repoURL)
 	}
 
+	if IsLayoutURL(repoURL) {
+		return f.LayoutFetcher.FetchID(log, repoURL)
+	}
+
 	return f.RemoteFetcher.FetchID(log, repoURL)
 }
 
+// IsLayoutURL tells whether u refers to an image layout on the local
+// filesystem, as in oci:///path/to/layout#tag
+func IsLayoutURL(u *url.URL) bool {
+	return u.Scheme == "oci" || u.Scheme == "oci-layout"
+}
+
 type dockerImage struct {
 	layers []*dockerLayer
 }
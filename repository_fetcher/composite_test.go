@@ -16,6 +16,7 @@ var _ = Describe("CompositeFetcher", func() {
 		logger            *lagertest.TestLogger
 		fakeLocalFetcher  *fakes.FakeRepositoryFetcher
 		fakeRemoteFetcher *fakes.FakeRepositoryFetcher
+		fakeLayoutFetcher *fakes.FakeRepositoryFetcher
 		factory           *CompositeFetcher
 	)
 
@@ -23,10 +24,12 @@ var _ = Describe("CompositeFetcher", func() {
 		logger = lagertest.NewTestLogger("test")
 		fakeLocalFetcher = new(fakes.FakeRepositoryFetcher)
 		fakeRemoteFetcher = new(fakes.FakeRepositoryFetcher)
+		fakeLayoutFetcher = new(fakes.FakeRepositoryFetcher)
 
 		factory = &CompositeFetcher{
 			LocalFetcher:  fakeLocalFetcher,
 			RemoteFetcher: fakeRemoteFetcher,
+			LayoutFetcher: fakeLayoutFetcher,
 		}
 	})
 
@@ -57,4 +60,22 @@ var _ = Describe("CompositeFetcher", func() {
 			Expect(fakeLocalFetcher.FetchIDCallCount()).To(Equal(0))
 		})
 	})
+
+	for _, scheme := range []string{"oci", "oci-layout"} {
+		scheme := scheme
+
+		Context("when the scheme is "+scheme+"://", func() {
+			It("delegates .Fetch to the layout fetcher", func() {
+				factory.Fetch(logger, &url.URL{Scheme: scheme, Path: "/cake.tar"}, "", "", 24)
+				Expect(fakeLayoutFetcher.FetchCallCount()).To(Equal(1))
+				Expect(fakeRemoteFetcher.FetchCallCount()).To(Equal(0))
+			})
+
+			It("delegates .FetchID to the layout fetcher", func() {
+				factory.FetchID(logger, &url.URL{Scheme: scheme, Path: "/cake.tar"})
+				Expect(fakeLayoutFetcher.FetchIDCallCount()).To(Equal(1))
+				Expect(fakeRemoteFetcher.FetchIDCallCount()).To(Equal(0))
+			})
+		})
+	}
 })
@@ -0,0 +1,39 @@
+package repository_fetcher
+
+import "fmt"
+
+// wrappedError adds context to an error while keeping the original error
+// available to the ErrorClassifier
+type wrappedError struct {
+	msg   string
+	cause error
+}
+
+func wrapError(cause error, format string, args ...interface{}) error {
+	return &wrappedError{
+		msg:   fmt.Sprintf(format, args...),
+		cause: cause,
+	}
+}
+
+func (e *wrappedError) Error() string {
+	return e.msg
+}
+
+func (e *wrappedError) Cause() error {
+	return e.cause
+}
+
+// rootCause unwraps errors wrapped by wrapError
+func rootCause(err error) error {
+	for {
+		wrapped, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			return err
+		}
+
+		err = wrapped.Cause()
+	}
+}
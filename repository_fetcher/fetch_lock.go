@@ -5,6 +5,8 @@ import (
 	"sync"
 )
 
+// FetchLock serializes work per key, letting work on different keys proceed
+// in parallel. The zero value is ready to use.
 type FetchLock struct {
 	locks map[string]*sync.Mutex
 	mutex sync.Mutex
@@ -20,6 +22,10 @@ func (l *FetchLock) Acquire(key string) {
 	var lock *sync.Mutex
 
 	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sync.Mutex)
+	}
+
 	if _, ok := l.locks[key]; !ok {
 		l.locks[key] = new(sync.Mutex)
 	}
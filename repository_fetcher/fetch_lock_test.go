@@ -20,6 +20,15 @@ var _ = Describe("Graph Lock", func() {
 		lock = repository_fetcher.NewFetchLock()
 	})
 
+	Context("when it is the zero value", func() {
+		It("can be acquired and released", func() {
+			var zeroLock repository_fetcher.FetchLock
+
+			zeroLock.Acquire("some-key")
+			Expect(zeroLock.Release("some-key")).To(Succeed())
+		})
+	})
+
 	Describe("Acquire", func() {
 		Context("when the layer is locked", func() {
 			BeforeEach(func() {
@@ -0,0 +1,148 @@
+package repository_fetcher
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"code.cloudfoundry.org/garden-shed/pkg/atomicfile"
+)
+
+// FileDigests remembers the sha256 digests of files so that a file which has
+// not changed since, going by its inode, size and modification time, is not
+// hashed again. A nil *FileDigests hashes every time. FileDigests with a
+// Path can be saved to it, so that they survive restarts.
+type FileDigests struct {
+	Path string
+
+	mu      sync.Mutex
+	digests map[string]fileDigest
+	dirty   bool
+}
+
+type fileDigest struct {
+	Stat   fileStat `json:"stat"`
+	Digest string   `json:"digest"`
+}
+
+type fileStat struct {
+	Inode   uint64 `json:"inode"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+}
+
+type fileDigestsFile struct {
+	Files map[string]fileDigest `json:"files"`
+}
+
+func NewFileDigests() *FileDigests {
+	return &FileDigests{
+		digests: make(map[string]fileDigest),
+	}
+}
+
+// LoadFileDigests loads the digests saved at path, or starts with none if
+// there are none yet
+func LoadFileDigests(path string) (*FileDigests, error) {
+	digests := NewFileDigests()
+	digests.Path = path
+
+	if err := atomicfile.JSONFile(path).Load(&fileDigestsFile{Files: digests.digests}); err != nil {
+		return nil, err
+	}
+
+	return digests, nil
+}
+
+// Digest returns the hex encoded sha256 digest of the file at path
+func (f *FileDigests) Digest(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	return f.digest(path, info)
+}
+
+// digest is Digest for a file which has already been stat'ed
+func (f *FileDigests) digest(path string, info os.FileInfo) (string, error) {
+	stat := statOf(info)
+	if f != nil {
+		f.mu.Lock()
+		known, ok := f.digests[path]
+		f.mu.Unlock()
+
+		if ok && known.Stat == stat {
+			return known.Digest, nil
+		}
+	}
+
+	digest, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if f != nil {
+		f.mu.Lock()
+		f.digests[path] = fileDigest{Stat: stat, Digest: digest}
+		f.dirty = true
+		f.mu.Unlock()
+	}
+
+	return digest, nil
+}
+
+// Save writes the digests to Path, if they changed since they were loaded or
+// last saved. Digests of files which no longer exist are dropped.
+func (f *FileDigests) Save() error {
+	if f == nil || f.Path == "" {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.dirty {
+		return nil
+	}
+
+	for path := range f.digests {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			delete(f.digests, path)
+		}
+	}
+
+	if err := atomicfile.JSONFile(f.Path).Save(fileDigestsFile{Files: f.digests}); err != nil {
+		return err
+	}
+
+	f.dirty = false
+	return nil
+}
+
+func statOf(info os.FileInfo) fileStat {
+	stat := fileStat{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+		stat.Inode = uint64(sys.Ino)
+	}
+
+	return stat
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
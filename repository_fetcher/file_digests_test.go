@@ -0,0 +1,127 @@
+package repository_fetcher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/garden-shed/repository_fetcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FileDigests", func() {
+	var (
+		dir     string
+		file    string
+		digests *repository_fetcher.FileDigests
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "file-digests")
+		Expect(err).NotTo(HaveOccurred())
+
+		file = filepath.Join(dir, "rootfs.tar")
+		Expect(ioutil.WriteFile(file, []byte("hello"), 0600)).To(Succeed())
+
+		digests = repository_fetcher.NewFileDigests()
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("returns the sha256 of the file", func() {
+		Expect(digests.Digest(file)).To(Equal("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"))
+	})
+
+	It("does not hash the file again if it does not appear to have changed", func() {
+		modTime := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+		Expect(os.Chtimes(file, modTime, modTime)).To(Succeed())
+		before, err := digests.Digest(file)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(file, []byte("HELLO"), 0600)).To(Succeed())
+		Expect(os.Chtimes(file, modTime, modTime)).To(Succeed())
+		Expect(digests.Digest(file)).To(Equal(before))
+	})
+
+	It("hashes the file again once it has changed", func() {
+		before, err := digests.Digest(file)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(file, []byte("goodbye"), 0600)).To(Succeed())
+		Expect(digests.Digest(file)).NotTo(Equal(before))
+	})
+
+	Describe("saving", func() {
+		var digestsPath string
+
+		BeforeEach(func() {
+			digestsPath = filepath.Join(dir, "garden-info", "file-digests.json")
+		})
+
+		It("loads the digests it saved", func() {
+			digests, err := repository_fetcher.LoadFileDigests(digestsPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			modTime := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+			Expect(os.Chtimes(file, modTime, modTime)).To(Succeed())
+
+			digest, err := digests.Digest(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(digests.Save()).To(Succeed())
+
+			Expect(ioutil.WriteFile(file, []byte("HELLO"), 0600)).To(Succeed())
+			Expect(os.Chtimes(file, modTime, modTime)).To(Succeed())
+
+			loaded, err := repository_fetcher.LoadFileDigests(digestsPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loaded.Digest(file)).To(Equal(digest))
+		})
+
+		It("drops the digests of files which no longer exist", func() {
+			digests, err := repository_fetcher.LoadFileDigests(digestsPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			digest, err := digests.Digest(file)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.Remove(file)).To(Succeed())
+			Expect(digests.Save()).To(Succeed())
+			Expect(ioutil.ReadFile(digestsPath)).NotTo(ContainSubstring(digest))
+		})
+
+		It("does not save digests without a path", func() {
+			_, err := digests.Digest(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(digests.Save()).To(Succeed())
+		})
+
+		Context("when the saved digests are corrupt", func() {
+			It("returns an error", func() {
+				Expect(os.MkdirAll(filepath.Dir(digestsPath), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(digestsPath, []byte("{"), 0600)).To(Succeed())
+
+				_, err := repository_fetcher.LoadFileDigests(digestsPath)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("when it is nil", func() {
+		It("still hashes the file", func() {
+			var nilDigests *repository_fetcher.FileDigests
+			Expect(nilDigests.Digest(file)).To(Equal("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"))
+		})
+	})
+
+	Context("when the file does not exist", func() {
+		It("returns an error", func() {
+			_, err := digests.Digest(filepath.Join(dir, "missing"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,226 @@
+package repository_fetcher
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/garden-shed/pkg/atomicfile"
+	"github.com/docker/distribution/digest"
+)
+
+// IndexedImage is what a reference resolved to when it was last fetched
+type IndexedImage struct {
+	Reference      string        `json:"reference"`
+	ImageID        string        `json:"image_id"`
+	ManifestDigest digest.Digest `json:"manifest_digest,omitempty"`
+	Env            []string      `json:"env,omitempty"`
+	Volumes        []string      `json:"volumes,omitempty"`
+	Size           int64         `json:"size"`
+	LastUsed       time.Time     `json:"last_used"`
+
+	User         string   `json:"user,omitempty"`
+	WorkingDir   string   `json:"working_dir,omitempty"`
+	Entrypoint   []string `json:"entrypoint,omitempty"`
+	Cmd          []string `json:"cmd,omitempty"`
+	StopSignal   string   `json:"stop_signal,omitempty"`
+	ExposedPorts []string `json:"exposed_ports,omitempty"`
+}
+
+func indexedImage(ref string, manifestDigest digest.Digest, image *Image) IndexedImage {
+	return IndexedImage{
+		Reference:      ref,
+		ImageID:        image.ImageID,
+		ManifestDigest: manifestDigest,
+		Env:            image.Env,
+		Volumes:        image.Volumes,
+		Size:           image.Size,
+		User:           image.User,
+		WorkingDir:     image.WorkingDir,
+		Entrypoint:     image.Entrypoint,
+		Cmd:            image.Cmd,
+		StopSignal:     image.StopSignal,
+		ExposedPorts:   image.ExposedPorts,
+	}
+}
+
+func (i IndexedImage) Image() *Image {
+	return &Image{
+		ImageID: i.ImageID,
+		Env:     append([]string(nil), i.Env...),
+		Volumes: append([]string(nil), i.Volumes...),
+		Size:    i.Size,
+
+		User:         i.User,
+		WorkingDir:   i.WorkingDir,
+		Entrypoint:   append([]string(nil), i.Entrypoint...),
+		Cmd:          append([]string(nil), i.Cmd...),
+		StopSignal:   i.StopSignal,
+		ExposedPorts: append([]string(nil), i.ExposedPorts...),
+	}
+}
+
+// ImageIndex maps references, i.e. host/repository:tag or
+// host/repository@digest, to the images they resolved to, and pins manifest
+// digests to the IDs of their images. Every change is saved to Path, so that
+// references can be resolved without the registry after a restart.
+type ImageIndex struct {
+	Path  string
+	Clock clock.Clock
+
+	mu     sync.Mutex
+	images map[string]IndexedImage
+	pins   map[digest.Digest]string
+}
+
+type imageIndexFile struct {
+	Images map[string]IndexedImage  `json:"images"`
+	Pins   map[digest.Digest]string `json:"pins,omitempty"`
+}
+
+// LoadImageIndex loads the index saved at path, or starts an empty one if
+// there is none yet
+func LoadImageIndex(path string, clock clock.Clock) (*ImageIndex, error) {
+	index := &ImageIndex{
+		Path:   path,
+		Clock:  clock,
+		images: make(map[string]IndexedImage),
+	}
+
+	file := imageIndexFile{Images: index.images}
+	if err := atomicfile.JSONFile(path).Load(&file); err != nil {
+		return nil, err
+	}
+
+	if len(file.Pins) > 0 {
+		index.pins = file.Pins
+	}
+
+	return index, nil
+}
+
+func (x *ImageIndex) Get(ref string) (IndexedImage, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	image, ok := x.images[ref]
+	return image, ok
+}
+
+// Put records what ref resolved to, as used now
+func (x *ImageIndex) Put(image IndexedImage) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.images == nil {
+		x.images = make(map[string]IndexedImage)
+	}
+
+	image.LastUsed = x.now()
+	x.images[image.Reference] = image
+
+	return x.save()
+}
+
+// Touch marks ref as used now
+func (x *ImageIndex) Touch(ref string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	image, ok := x.images[ref]
+	if !ok {
+		return nil
+	}
+
+	image.LastUsed = x.now()
+	x.images[ref] = image
+
+	return x.save()
+}
+
+// Remove forgets ref
+func (x *ImageIndex) Remove(ref string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, ok := x.images[ref]; !ok {
+		return nil
+	}
+
+	delete(x.images, ref)
+	return x.save()
+}
+
+// Pin records the ID of the image a manifest digest resolved to. A manifest
+// digest always resolves to the same image, so unlike references pins never
+// go stale, though the image may since have been removed from the cake.
+func (x *ImageIndex) Pin(manifestDigest digest.Digest, imageID string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.pins[manifestDigest] == imageID {
+		return nil
+	}
+
+	if x.pins == nil {
+		x.pins = make(map[digest.Digest]string)
+	}
+
+	x.pins[manifestDigest] = imageID
+	return x.save()
+}
+
+// Pinned returns the ID of the image the manifest digest was pinned to
+func (x *ImageIndex) Pinned(manifestDigest digest.Digest) (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	imageID, ok := x.pins[manifestDigest]
+	return imageID, ok
+}
+
+// List returns all indexed images, ordered by reference
+func (x *ImageIndex) List() []IndexedImage {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	images := make([]IndexedImage, 0, len(x.images))
+	for _, image := range x.images {
+		images = append(images, image)
+	}
+
+	sort.Sort(byReference(images))
+	return images
+}
+
+// References returns the references that resolved to the image with the given
+// top layer ID
+func (x *ImageIndex) References(imageID string) []string {
+	var refs []string
+	for _, image := range x.List() {
+		if image.ImageID == imageID {
+			refs = append(refs, image.Reference)
+		}
+	}
+
+	return refs
+}
+
+func (x *ImageIndex) now() time.Time {
+	if x.Clock == nil {
+		return time.Now()
+	}
+
+	return x.Clock.Now()
+}
+
+func (x *ImageIndex) save() error {
+	return atomicfile.JSONFile(x.Path).Save(imageIndexFile{Images: x.images, Pins: x.pins})
+}
+
+type byReference []IndexedImage
+
+func (b byReference) Len() int           { return len(b) }
+func (b byReference) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byReference) Less(i, j int) bool { return b[i].Reference < b[j].Reference }
@@ -0,0 +1,158 @@
+package repository_fetcher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/garden-shed/repository_fetcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ImageIndex", func() {
+	var (
+		tmpDir    string
+		indexPath string
+		fakeClock *fakeclock.FakeClock
+		index     *repository_fetcher.ImageIndex
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "image-index")
+		Expect(err).NotTo(HaveOccurred())
+
+		indexPath = filepath.Join(tmpDir, "garden-info", "images.json")
+		fakeClock = fakeclock.NewFakeClock(time.Unix(1000, 0))
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		index, err = repository_fetcher.LoadImageIndex(indexPath, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	someImage := repository_fetcher.IndexedImage{
+		Reference:      "registry-1.docker.io/library/busybox:latest",
+		ImageID:        "some-id",
+		ManifestDigest: "sha256:abc",
+		Env:            []string{"PATH=/bin"},
+		Volumes:        []string{"/data"},
+		Size:           42,
+	}
+
+	It("starts empty when nothing has been saved", func() {
+		Expect(index.List()).To(BeEmpty())
+	})
+
+	It("returns the images put into it, marked as used", func() {
+		Expect(index.Put(someImage)).To(Succeed())
+
+		image, ok := index.Get(someImage.Reference)
+		Expect(ok).To(BeTrue())
+		Expect(image.ImageID).To(Equal("some-id"))
+		Expect(image.ManifestDigest).To(BeEquivalentTo("sha256:abc"))
+		Expect(image.LastUsed).To(Equal(fakeClock.Now()))
+	})
+
+	It("persists the images across loads", func() {
+		Expect(index.Put(someImage)).To(Succeed())
+
+		reloaded, err := repository_fetcher.LoadImageIndex(indexPath, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+
+		image, ok := reloaded.Get(someImage.Reference)
+		Expect(ok).To(BeTrue())
+		Expect(image.Env).To(Equal([]string{"PATH=/bin"}))
+		Expect(image.Volumes).To(Equal([]string{"/data"}))
+		Expect(image.Size).To(BeEquivalentTo(42))
+		Expect(image.LastUsed.Equal(fakeClock.Now())).To(BeTrue())
+	})
+
+	It("updates the last used time when touched", func() {
+		Expect(index.Put(someImage)).To(Succeed())
+
+		fakeClock.Increment(time.Hour)
+		Expect(index.Touch(someImage.Reference)).To(Succeed())
+
+		image, _ := index.Get(someImage.Reference)
+		Expect(image.LastUsed).To(Equal(fakeClock.Now()))
+	})
+
+	It("forgets removed images", func() {
+		Expect(index.Put(someImage)).To(Succeed())
+		Expect(index.Remove(someImage.Reference)).To(Succeed())
+
+		reloaded, err := repository_fetcher.LoadImageIndex(indexPath, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reloaded.List()).To(BeEmpty())
+	})
+
+	It("returns the references that resolved to an image", func() {
+		other := someImage
+		other.Reference = "registry-1.docker.io/library/busybox:1.24"
+		unrelated := someImage
+		unrelated.Reference = "registry-1.docker.io/library/alpine:latest"
+		unrelated.ImageID = "other-id"
+
+		Expect(index.Put(someImage)).To(Succeed())
+		Expect(index.Put(other)).To(Succeed())
+		Expect(index.Put(unrelated)).To(Succeed())
+
+		Expect(index.References("some-id")).To(Equal([]string{
+			"registry-1.docker.io/library/busybox:1.24",
+			"registry-1.docker.io/library/busybox:latest",
+		}))
+	})
+
+	It("pins manifest digests to image IDs across loads", func() {
+		Expect(index.Pin("sha256:abc", "some-id")).To(Succeed())
+
+		reloaded, err := repository_fetcher.LoadImageIndex(indexPath, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+
+		imageID, ok := reloaded.Pinned("sha256:abc")
+		Expect(ok).To(BeTrue())
+		Expect(imageID).To(Equal("some-id"))
+	})
+
+	It("does not know digests which have not been pinned", func() {
+		_, ok := index.Pinned("sha256:abc")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("keeps pins apart from the images", func() {
+		Expect(index.Pin("sha256:abc", "some-id")).To(Succeed())
+		Expect(index.List()).To(BeEmpty())
+		Expect(index.References("some-id")).To(BeEmpty())
+	})
+
+	Context("when the saved index is corrupt", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Dir(indexPath), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(indexPath, []byte("{"), 0644)).To(Succeed())
+		})
+
+		It("fails to load", func() {
+			_, err := repository_fetcher.LoadImageIndex(indexPath, fakeClock)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when the index has no path", func() {
+		It("keeps the images in memory", func() {
+			memoryIndex := new(repository_fetcher.ImageIndex)
+			Expect(memoryIndex.Put(someImage)).To(Succeed())
+
+			_, ok := memoryIndex.Get(someImage.Reference)
+			Expect(ok).To(BeTrue())
+		})
+	})
+})
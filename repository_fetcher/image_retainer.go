@@ -1,6 +1,7 @@
 package repository_fetcher
 
 import (
+	"fmt"
 	"net/url"
 
 	"code.cloudfoundry.org/garden-shed/layercake"
@@ -12,6 +13,12 @@ type RemoteImageIDFetcher interface {
 	FetchID(log lager.Logger, u *url.URL) (layercake.ID, error)
 }
 
+type FetchIDFunc func(log lager.Logger, u *url.URL) (layercake.ID, error)
+
+func (fn FetchIDFunc) FetchID(log lager.Logger, u *url.URL) (layercake.ID, error) {
+	return fn(log, u)
+}
+
 type ImageRetainer struct {
 	DirectoryRootfsIDProvider ContainerIDProvider
 	DockerImageIDFetcher      RemoteImageIDFetcher
@@ -21,40 +28,73 @@ type ImageRetainer struct {
 	Logger lager.Logger
 }
 
-func (i *ImageRetainer) Retain(imageList []string) {
+// Retain retains the images in the list, and returns those whose IDs could
+// not be found so that they can be retried, for instance once the registry
+// can be reached
+func (i *ImageRetainer) Retain(imageList []string) []string {
 	log := i.Logger.Session("retain")
 
 	log.Info("starting")
 	defer log.Info("retained")
 
+	var unresolved []string
 	for _, image := range imageList {
 		log := log.WithData(lager.Data{"url": image})
 		log.Info("retaining")
 
-		rootfsURL, err := url.Parse(image)
+		ids, err := i.Resolve(log, image)
 		if err != nil {
-			log.Error("parse-rootfs-failed", err)
-			continue
-		}
+			log.Error("resolve-failed", err)
+
+			// an image which is not even a URL will never resolve
+			if _, ok := err.(*url.Error); !ok {
+				unresolved = append(unresolved, image)
+			}
 
-		var id layercake.ID
-		if id, err = i.toID(rootfsURL); err != nil {
-			log.Error("convert-to-id-failed", err)
 			continue
 		}
 
-		i.GraphRetainer.Retain(log, id)
-		i.GraphRetainer.Retain(log, layercake.NamespacedID(id, i.NamespaceCacheKey))
+		for _, id := range ids {
+			i.GraphRetainer.Retain(log, id)
+		}
 
 		log.Info("retaining-complete")
 	}
+
+	return unresolved
 }
 
-func (i *ImageRetainer) toID(u *url.URL) (id layercake.ID, err error) {
-	switch u.Scheme {
-	case "docker":
-		return i.DockerImageIDFetcher.FetchID(i.Logger, u)
-	default:
+// Resolve returns the IDs of the layers which have to be retained for the
+// image to be: the image itself, and its namespaced version
+func (i *ImageRetainer) Resolve(log lager.Logger, image string) ([]layercake.ID, error) {
+	rootfsURL, err := url.Parse(image)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := i.toID(log, rootfsURL)
+	if err != nil {
+		return nil, fmt.Errorf("convert to id: %s", err)
+	}
+
+	return []layercake.ID{id, layercake.NamespacedID(id, i.NamespaceCacheKey)}, nil
+}
+
+// IsDirectoryImage says whether image is a local rootfs, which is identified
+// by the DirectoryRootfsIDProvider rather than by fetching its ID
+func IsDirectoryImage(image string) bool {
+	u, err := url.Parse(image)
+	return err == nil && isDirectoryURL(u)
+}
+
+func isDirectoryURL(u *url.URL) bool {
+	return u.Scheme != "docker" && !IsLayoutURL(u)
+}
+
+func (i *ImageRetainer) toID(log lager.Logger, u *url.URL) (id layercake.ID, err error) {
+	if isDirectoryURL(u) {
 		return i.DirectoryRootfsIDProvider.ProvideID(u.Path), nil
 	}
+
+	return i.DockerImageIDFetcher.FetchID(log, u)
 }
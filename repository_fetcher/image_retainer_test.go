@@ -101,6 +101,18 @@ var _ = Describe("ImageRetainer", func() {
 				Expect(id).To(Equal(layercake.NamespacedID(layercake.DockerImageID("/fetched//bar/baz"), "chip-sandwhich")))
 			})
 		})
+
+		Context("and it is an image layout", func() {
+			It("retains the image it refers to", func() {
+				imageRetainer.Retain([]string{
+					"oci:///images/busybox.tar#latest",
+				})
+
+				Expect(fakeGraphRetainer.RetainCallCount()).To(Equal(2))
+				_, id := fakeGraphRetainer.RetainArgsForCall(0)
+				Expect(id).To(Equal(layercake.DockerImageID("/fetched//images/busybox.tar")))
+			})
+		})
 	})
 
 	Context("when multiple images are passed", func() {
@@ -132,6 +144,62 @@ var _ = Describe("ImageRetainer", func() {
 
 				Expect(fakeGraphRetainer.RetainCallCount()).To(Equal(4)) // both images, both namespaced version
 			})
+
+			It("returns the images it could not resolve, so they can be retried", func() {
+				fakeRemoteImageIDProvider.FetchIDStub = func(log lager.Logger, u *url.URL) (layercake.ID, error) {
+					if u.Path == "/potato" {
+						return nil, errors.New("boom")
+					}
+
+					return nil, nil
+				}
+
+				unresolved := imageRetainer.Retain([]string{
+					"docker://foo/bar/baz",
+					":",
+					"docker:///potato",
+				})
+
+				Expect(unresolved).To(Equal([]string{"docker:///potato"}))
+			})
+		})
+	})
+
+	Describe("IsDirectoryImage", func() {
+		It("is true of local rootfs paths", func() {
+			Expect(repository_fetcher.IsDirectoryImage("/foo/bar/baz")).To(BeTrue())
+		})
+
+		It("is false of docker images and image layouts", func() {
+			Expect(repository_fetcher.IsDirectoryImage("docker:///potato")).To(BeFalse())
+			Expect(repository_fetcher.IsDirectoryImage("oci:///some/layout")).To(BeFalse())
+		})
+	})
+
+	Describe("Resolve", func() {
+		It("returns the image and its namespaced version", func() {
+			ids, err := imageRetainer.Resolve(lagertest.NewTestLogger("test"), "docker://foo/bar/baz")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids).To(Equal([]layercake.ID{
+				layercake.DockerImageID("/fetched//bar/baz"),
+				layercake.NamespacedID(layercake.DockerImageID("/fetched//bar/baz"), "chip-sandwhich"),
+			}))
+		})
+
+		It("does not retain anything", func() {
+			_, err := imageRetainer.Resolve(lagertest.NewTestLogger("test"), "/foo/bar/baz")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeGraphRetainer.RetainCallCount()).To(Equal(0))
+		})
+
+		Context("when the image id cannot be fetched", func() {
+			It("returns an error", func() {
+				fakeRemoteImageIDProvider.FetchIDReturns(nil, errors.New("boom"))
+				fakeRemoteImageIDProvider.FetchIDStub = nil
+
+				_, err := imageRetainer.Resolve(lagertest.NewTestLogger("test"), "docker://foo/bar/baz")
+				Expect(err).To(MatchError(ContainSubstring("boom")))
+			})
 		})
 	})
 })
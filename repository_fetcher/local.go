@@ -3,9 +3,10 @@ package repository_fetcher
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
-	"sync"
+	"path/filepath"
 	"time"
 
 	"code.cloudfoundry.org/garden-shed/layercake"
@@ -24,7 +25,12 @@ type Local struct {
 	DefaultRootFSPath string
 	IDProvider        ContainerIDProvider
 
-	mu sync.RWMutex
+	// imports are locked per path, so that a rootfs is identified, which can
+	// mean hashing all of it, by one import at a time, and then per graph ID,
+	// so that different rootfses import in parallel while concurrent imports
+	// of the same one happen only once
+	pathLocks FetchLock
+	locks     FetchLock
 }
 
 func (l *Local) Fetch(log lager.Logger, repoURL *url.URL, _, _ string, _ int64) (*Image, error) {
@@ -58,22 +64,23 @@ func (l *Local) fetch(log lager.Logger, path string) (string, error) {
 		return "", err
 	}
 
+	path = filepath.Clean(path)
+	l.pathLocks.Acquire(path)
+	defer l.pathLocks.Release(path)
+
 	id := l.IDProvider.ProvideID(path)
 
-	// synchronize all downloads, we could optimize by only mutexing around each
-	// particular rootfs path, but in practice importing local rootfses is decently fast,
-	// and concurrently importing local rootfses is rare.
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.locks.Acquire(id.GraphID())
+	defer l.locks.Release(id.GraphID())
 
 	if _, err := l.Cake.Get(id); err == nil {
 		log.Info("using-cache", lager.Data{"graphID": id.GraphID()})
 		return id.GraphID(), nil // use cache
 	}
 
-	tar, err := archive.Tar(path, archive.Uncompressed)
+	tar, err := openRootFS(path)
 	if err != nil {
-		return "", fmt.Errorf("repository_fetcher: fetch local rootfs: untar rootfs: %v", err)
+		return "", err
 	}
 	defer tar.Close()
 
@@ -85,6 +92,32 @@ func (l *Local) fetch(log lager.Logger, path string) (string, error) {
 	return id.GraphID(), nil
 }
 
+// openRootFS returns the rootfs at path as a tar stream. Directories are
+// tarred up on the fly, while tarballs are passed on as they are: the cake
+// decompresses gzip, bzip2 and xz layers itself.
+func openRootFS(path string) (io.ReadCloser, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("repository_fetcher: fetch local rootfs: stat rootfs: %v", err)
+	}
+
+	if info.Mode().IsRegular() {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("repository_fetcher: fetch local rootfs: open rootfs archive: %v", err)
+		}
+
+		return file, nil
+	}
+
+	tar, err := archive.Tar(path, archive.Uncompressed)
+	if err != nil {
+		return nil, fmt.Errorf("repository_fetcher: fetch local rootfs: untar rootfs: %v", err)
+	}
+
+	return tar, nil
+}
+
 func resolve(path string) (string, error) {
 	fileInfo, err := os.Lstat(path)
 	if err != nil {
@@ -99,10 +132,14 @@ func resolve(path string) (string, error) {
 	return path, nil
 }
 
+// LayerIDProvider identifies a local rootfs directory by its path and
+// modification time, and a rootfs tarball by the digest of its content.
+// Digests, if set, saves hashing tarballs which have not changed.
 type LayerIDProvider struct {
+	Digests *FileDigests
 }
 
-func (LayerIDProvider) ProvideID(path string) layercake.ID {
+func (p LayerIDProvider) ProvideID(path string) layercake.ID {
 	path, err := resolve(path)
 	if err != nil {
 		return layercake.LocalImageID{
@@ -119,8 +156,27 @@ func (LayerIDProvider) ProvideID(path string) layercake.ID {
 		}
 	}
 
+	if info.Mode().IsRegular() {
+		return archiveID(p.Digests, path, info)
+	}
+
 	return layercake.LocalImageID{
 		Path:         path,
 		ModifiedTime: info.ModTime(),
 	}
 }
+
+func archiveID(digests *FileDigests, path string, info os.FileInfo) layercake.ID {
+	digest, err := digests.digest(path, info)
+	if err != nil {
+		return layercake.LocalImageID{
+			Path:         path,
+			ModifiedTime: time.Time{},
+		}
+	}
+
+	return layercake.LocalArchiveID{
+		Path:   path,
+		Digest: digest,
+	}
+}
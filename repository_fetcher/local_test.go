@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/garden-shed/layercake"
@@ -90,6 +91,39 @@ var _ = Describe("LayerIDProvider", func() {
 			Expect(symlinkID).To(Equal(pathID))
 		})
 	})
+
+	Context("when path is a tarball", func() {
+		var tarball1, tarball2 string
+
+		BeforeEach(func() {
+			tarball1 = path.Join(path1, "rootfs.tar.gz")
+			tarball2 = path.Join(path2, "other-rootfs.tar.gz")
+			Expect(ioutil.WriteFile(tarball1, []byte("some-rootfs"), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(tarball2, []byte("some-rootfs"), 0600)).To(Succeed())
+
+			idp = repository_fetcher.LayerIDProvider{Digests: repository_fetcher.NewFileDigests()}
+		})
+
+		It("returns the same ID for tarballs with the same content", func() {
+			Expect(idp.ProvideID(tarball1).GraphID()).To(Equal(idp.ProvideID(tarball2).GraphID()))
+		})
+
+		It("returns the same ID if only the modification time changes", func() {
+			beforeID := idp.ProvideID(tarball1)
+			Expect(os.Chtimes(tarball1, accessTime, modifiedTime.Add(time.Second*1))).To(Succeed())
+			Expect(idp.ProvideID(tarball1).GraphID()).To(Equal(beforeID.GraphID()))
+		})
+
+		It("returns a different ID if the content changes", func() {
+			beforeID := idp.ProvideID(tarball1)
+			Expect(ioutil.WriteFile(tarball1, []byte("another-rootfs"), 0600)).To(Succeed())
+			Expect(idp.ProvideID(tarball1).GraphID()).NotTo(Equal(beforeID.GraphID()))
+		})
+
+		It("returns a different ID than the directory containing it", func() {
+			Expect(idp.ProvideID(tarball1).GraphID()).NotTo(Equal(idp.ProvideID(path1).GraphID()))
+		})
+	})
 })
 
 var _ = Describe("Local", func() {
@@ -285,6 +319,127 @@ var _ = Describe("Local", func() {
 			})
 		})
 
+		Context("when the path is a tarball", func() {
+			var tarball string
+
+			BeforeEach(func() {
+				tarball = path.Join(tmpDir, "rootfs.tar.gz")
+				Expect(ioutil.WriteFile(tarball, []byte("compressed-rootfs"), 0600)).To(Succeed())
+			})
+
+			It("registers the content of the tarball as it is", func() {
+				var content []byte
+				fakeCake.RegisterStub = func(image *image.Image, layer archive.ArchiveReader) error {
+					var err error
+					content, err = ioutil.ReadAll(layer)
+					return err
+				}
+
+				_, err := fetcher.Fetch(fakeLogger, &url.URL{Path: tarball}, "", "", 0)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(content).To(Equal([]byte("compressed-rootfs")))
+			})
+		})
+
+		Context("when rootfses are imported concurrently", func() {
+			var (
+				dirA, dirB string
+				unblock    chan struct{}
+			)
+
+			BeforeEach(func() {
+				dirA = path.Join(tmpDir, "a")
+				dirB = path.Join(tmpDir, "b")
+				Expect(os.MkdirAll(dirA, 0700)).To(Succeed())
+				Expect(os.MkdirAll(dirB, 0700)).To(Succeed())
+
+				unblock = make(chan struct{})
+
+				var (
+					mu         sync.Mutex
+					registered = make(map[string]bool)
+				)
+
+				fakeCake.GetStub = func(id layercake.ID) (*image.Image, error) {
+					mu.Lock()
+					defer mu.Unlock()
+
+					if registered[id.GraphID()] {
+						return &image.Image{ID: id.GraphID()}, nil
+					}
+
+					return nil, errors.New("no image")
+				}
+
+				fakeCake.RegisterStub = func(img *image.Image, layer archive.ArchiveReader) error {
+					if strings.HasSuffix(img.ID, "_a") {
+						<-unblock
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+
+					registered[img.ID] = true
+					return nil
+				}
+			})
+
+			fetchInBackground := func(path string) chan struct{} {
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					defer close(done)
+
+					_, err := fetcher.Fetch(fakeLogger, &url.URL{Path: path}, "", "", 0)
+					Expect(err).NotTo(HaveOccurred())
+				}()
+
+				return done
+			}
+
+			It("imports different rootfses in parallel", func() {
+				doneA := fetchInBackground(dirA)
+				Eventually(fakeCake.RegisterCallCount).Should(Equal(1))
+
+				_, err := fetcher.Fetch(fakeLogger, &url.URL{Path: dirB}, "", "", 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(doneA).NotTo(BeClosed())
+
+				close(unblock)
+				Eventually(doneA).Should(BeClosed())
+			})
+
+			It("imports the same rootfs only once", func() {
+				done1 := fetchInBackground(dirA)
+				Eventually(fakeCake.RegisterCallCount).Should(Equal(1))
+
+				done2 := fetchInBackground(dirA)
+				Consistently(done2, "100ms").ShouldNot(BeClosed())
+
+				close(unblock)
+				Eventually(done1).Should(BeClosed())
+				Eventually(done2).Should(BeClosed())
+				Expect(fakeCake.RegisterCallCount()).To(Equal(1))
+			})
+
+			It("identifies the same rootfs once the import in progress is done", func() {
+				ider := &countingIDer{}
+				fetcher.IDProvider = ider
+
+				done1 := fetchInBackground(dirA)
+				Eventually(fakeCake.RegisterCallCount).Should(Equal(1))
+
+				done2 := fetchInBackground(dirA + "/")
+				Consistently(ider.Calls, "100ms").Should(Equal(1))
+
+				close(unblock)
+				Eventually(done1).Should(BeClosed())
+				Eventually(done2).Should(BeClosed())
+				Expect(ider.Calls()).To(Equal(2))
+			})
+		})
+
 		Context("when the path does not exist", func() {
 			It("returns an error", func() {
 				_, err := fetcher.Fetch(fakeLogger, &url.URL{Path: "does-not-exist"}, "", "", 0)
@@ -334,3 +489,25 @@ type UnderscoreIDer struct {
 func (u UnderscoreIDer) ProvideID(path string) layercake.ID {
 	return layercake.DockerImageID(strings.Replace(path, "/", "_", -1))
 }
+
+type countingIDer struct {
+	UnderscoreIDer
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *countingIDer) ProvideID(path string) layercake.ID {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+
+	return c.UnderscoreIDer.ProvideID(path)
+}
+
+func (c *countingIDer) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.calls
+}
@@ -0,0 +1,290 @@
+package repository_fetcher
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/garden-shed/distclient"
+	"code.cloudfoundry.org/garden-shed/quotaedreader"
+	"code.cloudfoundry.org/lager"
+	"github.com/docker/distribution/digest"
+)
+
+// DefaultPartialBlobMaxAge is how long an interrupted download is kept for
+// once nothing is written to it any more
+const DefaultPartialBlobMaxAge = 24 * time.Hour
+
+// PartialBlobs keeps blobs on disk while they are being downloaded, so that a
+// fetch retried after a broken download resumes where the previous attempt
+// stopped instead of downloading the whole blob again. Downloads which are
+// never resumed are expired once they are MaxAge old.
+type PartialBlobs struct {
+	Dir    string
+	MaxAge time.Duration
+
+	lock *FetchLock
+
+	mu          sync.Mutex
+	active      map[string]int
+	lastExpired time.Time
+}
+
+func NewPartialBlobs(dir string) (*PartialBlobs, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &PartialBlobs{
+		Dir:    dir,
+		MaxAge: DefaultPartialBlobMaxAge,
+		lock:   NewFetchLock(),
+		active: make(map[string]int),
+	}, nil
+}
+
+// Download completes the download of the blob d from conn, after whatever
+// earlier attempts already downloaded, and returns the whole blob opened for
+// reading. The blob is more than limit bytes, unless limit is negative, the
+// download fails with a quotaedreader.QuotaExceededErr and is thrown away;
+// any other failure keeps what was downloaded for the next attempt to
+// resume.
+//
+// Only one download of a blob can be in progress at a time. Once it is
+// complete, the blob is moved out of the way of the next download of it, and
+// closing the returned blob removes it, since by then it has either been
+// verified or found to be corrupt.
+func (p *PartialBlobs) Download(log lager.Logger, conn distclient.Conn, d digest.Digest, limit int64) (*PartialBlob, error) {
+	p.expireIfDue(log)
+
+	path := p.path(d)
+	p.activate(path)
+	defer p.deactivate(path)
+
+	p.lock.Acquire(path)
+	defer p.lock.Release(path)
+
+	return p.download(log, conn, d, limit)
+}
+
+func (p *PartialBlobs) Remove(d digest.Digest) error {
+	if err := os.Remove(p.path(d)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Expire removes the partial downloads which have not been written to for
+// MaxAge, apart from those being downloaded
+func (p *PartialBlobs) Expire(log lager.Logger) {
+	log = log.Session("expire-partial-blobs", lager.Data{"dir": p.Dir})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastExpired = time.Now()
+
+	files, err := ioutil.ReadDir(p.Dir)
+	if err != nil {
+		log.Error("failed-to-list-partial-blobs", err)
+		return
+	}
+
+	for _, file := range files {
+		path := filepath.Join(p.Dir, file.Name())
+		if p.active[path] > 0 || time.Since(file.ModTime()) < p.MaxAge {
+			continue
+		}
+
+		log.Info("expiring", lager.Data{"blob": file.Name(), "size": file.Size(), "modified": file.ModTime()})
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Error("failed-to-expire-partial-blob", err, lager.Data{"blob": file.Name()})
+		}
+	}
+}
+
+func (p *PartialBlobs) expireIfDue(log lager.Logger) {
+	p.mu.Lock()
+	due := time.Since(p.lastExpired) >= p.MaxAge
+	p.mu.Unlock()
+
+	if due {
+		p.Expire(log)
+	}
+}
+
+func (p *PartialBlobs) activate(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active == nil {
+		p.active = make(map[string]int)
+	}
+
+	p.active[path]++
+}
+
+func (p *PartialBlobs) deactivate(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active[path]--; p.active[path] <= 0 {
+		delete(p.active, path)
+	}
+}
+
+func (p *PartialBlobs) download(log lager.Logger, conn distclient.Conn, d digest.Digest, limit int64) (*PartialBlob, error) {
+	file, err := os.OpenFile(p.path(d), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	offset := info.Size()
+
+	// the digest is worked out as the blob is downloaded, going through what
+	// was downloaded before just once, so that verifying it does not have to
+	// read the whole blob again
+	var digester digest.Digester
+	if d.Algorithm().Available() {
+		digester = d.Algorithm().New()
+		if _, err := io.Copy(digester.Hash(), io.NewSectionReader(file, 0, offset)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	var remote io.ReadCloser
+	if offset > 0 {
+		log.Info("resuming-blob", lager.Data{"digest": d, "offset": offset})
+		remote, err = conn.GetBlobRangeReader(log, d, offset)
+	} else {
+		remote, err = conn.GetBlobReader(log, d)
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if limit >= 0 {
+		remote = quotaedreader.New(remote, limit-offset)
+	}
+
+	var w io.Writer = file
+	if digester != nil {
+		w = io.MultiWriter(file, digester.Hash())
+	}
+
+	n, err := io.Copy(w, remote)
+	remote.Close()
+	if err != nil {
+		file.Close()
+		if _, ok := err.(quotaedreader.QuotaExceededErr); ok {
+			os.Remove(p.path(d))
+		}
+
+		return nil, err
+	}
+
+	downloaded, err := p.moveAside(p.path(d))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		file.Close()
+		os.Remove(downloaded)
+		p.deactivate(downloaded)
+		return nil, err
+	}
+
+	blob := &PartialBlob{
+		File:    file,
+		Resumed: offset > 0,
+		path:    downloaded,
+		size:    offset + n,
+		release: func() { p.deactivate(downloaded) },
+	}
+
+	if digester != nil {
+		blob.digest = digester.Digest()
+	}
+
+	return blob, nil
+}
+
+// moveAside moves a completely downloaded blob to a name of its own, where
+// it is read from until it is closed, and returns that name. The blob is
+// active, so that it is not expired, until it is deactivated.
+func (p *PartialBlobs) moveAside(path string) (string, error) {
+	tmp, err := ioutil.TempFile(p.Dir, filepath.Base(path)+".downloaded.")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+
+	p.activate(tmp.Name())
+	if err := os.Rename(path, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		p.deactivate(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+func (p *PartialBlobs) path(d digest.Digest) string {
+	return filepath.Join(p.Dir, string(d.Algorithm())+"-"+d.Hex())
+}
+
+// PartialBlob is a completely downloaded blob, read from where it was
+// downloaded to. Resumed says whether an earlier attempt downloaded some of
+// it.
+type PartialBlob struct {
+	*os.File
+	Resumed bool
+
+	path    string
+	size    int64
+	digest  digest.Digest
+	release func()
+	closed  bool
+}
+
+// Digest returns the digest of the blob as it was downloaded, or nothing if
+// the blob was asked for by a digest whose algorithm is not available
+func (b *PartialBlob) Digest() digest.Digest {
+	return b.digest
+}
+
+func (b *PartialBlob) Size() int64 {
+	return b.size
+}
+
+// Close closes and removes the blob
+func (b *PartialBlob) Close() error {
+	if b.closed {
+		return nil
+	}
+
+	b.closed = true
+	defer b.release()
+
+	err := b.File.Close()
+	if rerr := os.Remove(b.path); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+		err = rerr
+	}
+
+	return err
+}
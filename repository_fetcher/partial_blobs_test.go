@@ -0,0 +1,293 @@
+package repository_fetcher_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/garden-shed/distclient/fake_distclient"
+	"code.cloudfoundry.org/garden-shed/quotaedreader"
+	"code.cloudfoundry.org/garden-shed/repository_fetcher"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/docker/distribution/digest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PartialBlobs", func() {
+	var (
+		logger   *lagertest.TestLogger
+		dir      string
+		partials *repository_fetcher.PartialBlobs
+		fakeConn *fake_distclient.FakeConn
+		blobSum  digest.Digest
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+
+		var err error
+		dir, err = ioutil.TempDir("", "partial-blobs")
+		Expect(err).NotTo(HaveOccurred())
+
+		partials, err = repository_fetcher.NewPartialBlobs(filepath.Join(dir, "partials"))
+		Expect(err).NotTo(HaveOccurred())
+
+		blobSum = "sha256:0123456789012345678901234567890123456789012345678901234567890123"
+
+		fakeConn = new(fake_distclient.FakeConn)
+		fakeConn.GetBlobReaderReturns(&brokenReader{Reader: bytes.NewReader([]byte("01234"))}, nil)
+		fakeConn.GetBlobRangeReaderReturns(ioutil.NopCloser(bytes.NewReader([]byte("56789"))), nil)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	download := func() ([]byte, error) {
+		blob, err := partials.Download(logger, fakeConn, blobSum, -1)
+		if err != nil {
+			return nil, err
+		}
+		defer blob.Close()
+
+		return ioutil.ReadAll(blob)
+	}
+
+	It("downloads the whole blob the first time", func() {
+		_, err := download()
+		Expect(err).To(MatchError("connection reset"))
+
+		Expect(fakeConn.GetBlobReaderCallCount()).To(Equal(1))
+		Expect(fakeConn.GetBlobRangeReaderCallCount()).To(Equal(0))
+	})
+
+	It("keeps what was downloaded when the download is interrupted", func() {
+		_, err := download()
+		Expect(err).To(HaveOccurred())
+
+		Expect(ioutil.ReadDir(partials.Dir)).To(HaveLen(1))
+	})
+
+	Context("when an earlier download was interrupted", func() {
+		BeforeEach(func() {
+			_, err := download()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("resumes the download where it stopped", func() {
+			_, err := download()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeConn.GetBlobRangeReaderCallCount()).To(Equal(1))
+			_, d, offset := fakeConn.GetBlobRangeReaderArgsForCall(0)
+			Expect(d).To(Equal(blobSum))
+			Expect(offset).To(BeEquivalentTo(5))
+		})
+
+		It("returns the downloaded part followed by the rest of the blob", func() {
+			Expect(download()).To(Equal([]byte("0123456789")))
+		})
+
+		It("says that the download was resumed", func() {
+			blob, err := partials.Download(logger, fakeConn, blobSum, -1)
+			Expect(err).NotTo(HaveOccurred())
+			defer blob.Close()
+
+			Expect(blob.Resumed).To(BeTrue())
+		})
+
+		It("works out the digest of the whole blob as it downloads the rest", func() {
+			blob, err := partials.Download(logger, fakeConn, blobSum, -1)
+			Expect(err).NotTo(HaveOccurred())
+			defer blob.Close()
+
+			Expect(blob.Digest()).To(Equal(digestOf("0123456789")))
+			Expect(blob.Size()).To(BeEquivalentTo(10))
+		})
+
+		It("removes the blob once it is closed", func() {
+			_, err := download()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.ReadDir(partials.Dir)).To(BeEmpty())
+		})
+
+		Context("and the partial download is removed", func() {
+			BeforeEach(func() {
+				Expect(partials.Remove(blobSum)).To(Succeed())
+				fakeConn.GetBlobReaderReturns(ioutil.NopCloser(bytes.NewReader([]byte("0123456789"))), nil)
+			})
+
+			It("downloads the whole blob again", func() {
+				Expect(download()).To(Equal([]byte("0123456789")))
+				Expect(fakeConn.GetBlobRangeReaderCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("and the rest of the blob exceeds the quota", func() {
+			It("throws the partial download away", func() {
+				_, err := partials.Download(logger, fakeConn, blobSum, 7)
+				Expect(err).To(BeAssignableToTypeOf(quotaedreader.QuotaExceededErr{}))
+
+				Expect(ioutil.ReadDir(partials.Dir)).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when the blob fits in the quota", func() {
+		BeforeEach(func() {
+			fakeConn.GetBlobReaderReturns(ioutil.NopCloser(bytes.NewReader([]byte("0123456789"))), nil)
+		})
+
+		It("downloads it", func() {
+			blob, err := partials.Download(logger, fakeConn, blobSum, 10)
+			Expect(err).NotTo(HaveOccurred())
+			defer blob.Close()
+
+			Expect(blob.Resumed).To(BeFalse())
+			Expect(ioutil.ReadAll(blob)).To(Equal([]byte("0123456789")))
+		})
+	})
+
+	Context("when the downloaded blob is still open", func() {
+		var blob *repository_fetcher.PartialBlob
+
+		BeforeEach(func() {
+			fakeConn.GetBlobReaderStub = func(lager.Logger, digest.Digest) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader([]byte("0123456789"))), nil
+			}
+
+			var err error
+			blob, err = partials.Download(logger, fakeConn, blobSum, -1)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			blob.Close()
+		})
+
+		It("lets the blob be downloaded again", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+
+				Expect(download()).To(Equal([]byte("0123456789")))
+			}()
+
+			Eventually(done).Should(BeClosed())
+			Expect(fakeConn.GetBlobRangeReaderCallCount()).To(Equal(0))
+			Expect(ioutil.ReadAll(blob)).To(Equal([]byte("0123456789")))
+		})
+	})
+
+	Context("when the blob cannot be fetched", func() {
+		BeforeEach(func() {
+			fakeConn.GetBlobReaderReturns(nil, errors.New("no such blob"))
+		})
+
+		It("returns the error", func() {
+			_, err := download()
+			Expect(err).To(MatchError("no such blob"))
+		})
+
+		It("does not keep the blob locked", func() {
+			_, err := download()
+			Expect(err).To(HaveOccurred())
+
+			_, err = download()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("does not fail to remove a blob that has no partial download", func() {
+		Expect(partials.Remove(blobSum)).To(Succeed())
+	})
+
+	Describe("Expire", func() {
+		var stale, fresh string
+
+		BeforeEach(func() {
+			stale = filepath.Join(partials.Dir, "sha256-stale")
+			fresh = filepath.Join(partials.Dir, "sha256-fresh")
+
+			Expect(ioutil.WriteFile(stale, []byte("stale"), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(fresh, []byte("fresh"), 0600)).To(Succeed())
+
+			old := time.Now().Add(-repository_fetcher.DefaultPartialBlobMaxAge - time.Minute)
+			Expect(os.Chtimes(stale, old, old)).To(Succeed())
+		})
+
+		It("removes the partial downloads that have not been written to for the max age", func() {
+			partials.Expire(logger)
+
+			Expect(stale).NotTo(BeAnExistingFile())
+			Expect(fresh).To(BeAnExistingFile())
+		})
+
+		It("does not remove a blob that has been downloaded but is still open", func() {
+			path := filepath.Join(partials.Dir, "sha256-0123456789012345678901234567890123456789012345678901234567890123")
+			Expect(ioutil.WriteFile(path, []byte("01234"), 0600)).To(Succeed())
+
+			fakeConn.GetBlobRangeReaderReturns(ioutil.NopCloser(bytes.NewReader([]byte("56789"))), nil)
+			blob, err := partials.Download(logger, fakeConn, blobSum, -1)
+			Expect(err).NotTo(HaveOccurred())
+			defer blob.Close()
+
+			Expect(os.Remove(stale)).To(Succeed())
+			Expect(os.Remove(fresh)).To(Succeed())
+
+			files, err := ioutil.ReadDir(partials.Dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(1))
+
+			old := time.Now().Add(-repository_fetcher.DefaultPartialBlobMaxAge - time.Minute)
+			downloaded := filepath.Join(partials.Dir, files[0].Name())
+			Expect(os.Chtimes(downloaded, old, old)).To(Succeed())
+
+			partials.Expire(logger)
+			Expect(downloaded).To(BeAnExistingFile())
+			Expect(ioutil.ReadAll(blob)).To(Equal([]byte("0123456789")))
+		})
+
+		It("is done before downloading once the max age has passed", func() {
+			fakeConn.GetBlobReaderReturns(ioutil.NopCloser(bytes.NewReader([]byte("0123456789"))), nil)
+
+			_, err := download()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(stale).NotTo(BeAnExistingFile())
+		})
+	})
+})
+
+// brokenReader returns an error once its content has been read, like a
+// connection that breaks in the middle of a download
+type brokenReader struct {
+	io.Reader
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+
+	return n, err
+}
+
+func (b *brokenReader) Close() error {
+	return nil
+}
+
+func digestOf(content string) digest.Digest {
+	return digest.Digest(fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content))))
+}
@@ -0,0 +1,38 @@
+package repository_fetcher
+
+import "fmt"
+
+// PullPolicy decides when Remote asks the registry for an image it has
+// fetched before
+type PullPolicy string
+
+const (
+	// PullAlways resolves the reference with the registry on every fetch
+	PullAlways PullPolicy = "always"
+	// PullIfNotPresent only contacts the registry for images that are not in
+	// the cake yet
+	PullIfNotPresent PullPolicy = "if-not-present"
+	// PullNever never contacts the registry and fails for images that are not
+	// in the cake
+	PullNever PullPolicy = "never"
+)
+
+// ParsePullPolicy parses a pull policy. An empty string is PullAlways.
+func ParsePullPolicy(s string) (PullPolicy, error) {
+	switch PullPolicy(s) {
+	case "", PullAlways:
+		return PullAlways, nil
+	case PullIfNotPresent, PullNever:
+		return PullPolicy(s), nil
+	default:
+		return "", fmt.Errorf("invalid pull policy %q: expected %s, %s or %s", s, PullAlways, PullIfNotPresent, PullNever)
+	}
+}
+
+type ImageNotPresentError struct {
+	Reference string
+}
+
+func (e *ImageNotPresentError) Error() string {
+	return fmt.Sprintf("image %s is not present and the pull policy is %s", e.Reference, PullNever)
+}
@@ -0,0 +1,24 @@
+package repository_fetcher_test
+
+import (
+	"code.cloudfoundry.org/garden-shed/repository_fetcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParsePullPolicy", func() {
+	It("defaults to always", func() {
+		Expect(repository_fetcher.ParsePullPolicy("")).To(Equal(repository_fetcher.PullAlways))
+	})
+
+	It("parses the known policies", func() {
+		Expect(repository_fetcher.ParsePullPolicy("always")).To(Equal(repository_fetcher.PullAlways))
+		Expect(repository_fetcher.ParsePullPolicy("if-not-present")).To(Equal(repository_fetcher.PullIfNotPresent))
+		Expect(repository_fetcher.ParsePullPolicy("never")).To(Equal(repository_fetcher.PullNever))
+	})
+
+	It("rejects unknown policies", func() {
+		_, err := repository_fetcher.ParsePullPolicy("sometimes")
+		Expect(err).To(MatchError(ContainSubstring(`invalid pull policy "sometimes"`)))
+	})
+})
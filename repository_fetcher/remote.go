@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"sort"
 	"strings"
 
 	"github.com/docker/docker/image"
@@ -17,6 +18,10 @@ import (
 	"code.cloudfoundry.org/lager"
 )
 
+// DefaultMaxConcurrentDownloads is the number of layers of an image that are
+// downloaded at once unless configured otherwise
+const DefaultMaxConcurrentDownloads = 3
+
 type Remote struct {
 	DefaultHost string
 	Dial        Dialer
@@ -24,6 +29,28 @@ type Remote struct {
 	Verifier    Verifier
 
 	FetchLock *FetchLock
+
+	// MaxConcurrentDownloads bounds the number of layers of a single image
+	// being downloaded and verified at the same time
+	MaxConcurrentDownloads int
+
+	// PartialBlobs, if set, keeps interrupted downloads so they can be resumed
+	PartialBlobs *PartialBlobs
+
+	// TrustPolicy, if set, has to accept an image before any of its layers
+	// are registered
+	TrustPolicy TrustPolicy
+
+	// PullPolicy decides whether images fetched before are looked up in the
+	// registry again. The zero value is PullAlways.
+	PullPolicy PullPolicy
+
+	// Index records what every fetched reference resolved to, and pins the
+	// manifest digests of images fetched by digest to their IDs so that they
+	// can be resolved without the registry, including after a restart
+	Index *ImageIndex
+
+	blobs blobDownloads
 }
 
 func NewRemote(defaultHost string, cake layercake.Cake, dialer Dialer, verifier Verifier) *Remote {
@@ -33,6 +60,9 @@ func NewRemote(defaultHost string, cake layercake.Cake, dialer Dialer, verifier
 		Cake:        cake,
 		Verifier:    verifier,
 		FetchLock:   NewFetchLock(),
+		Index:       new(ImageIndex),
+
+		MaxConcurrentDownloads: DefaultMaxConcurrentDownloads,
 	}
 }
 
@@ -42,11 +72,30 @@ func (r *Remote) Fetch(log lager.Logger, u *url.URL, username, password string,
 	log.Info("start")
 	defer log.Info("finished")
 
+	if indexed, ok, err := r.presentImage(log, u); err != nil {
+		return nil, err
+	} else if ok {
+		// the image may have been fetched before the policy was what it is
+		if err := r.verifyTrust(log, u, indexed.ManifestDigest); err != nil {
+			return nil, err
+		}
+
+		if diskQuota > 0 && indexed.Size > diskQuota {
+			return nil, errors.New("quota exceeded")
+		}
+
+		return indexed.Image(), nil
+	}
+
 	conn, manifest, err := r.manifest(log, u, username, password)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := r.verifyTrust(log, u, manifest.Digest); err != nil {
+		return nil, err
+	}
+
 	totalImageSize := int64(0)
 	for _, layer := range manifest.Layers {
 		totalImageSize += layer.Image.Size
@@ -62,29 +111,65 @@ func (r *Remote) Fetch(log lager.Logger, u *url.URL, username, password string,
 	if diskQuota <= 0 {
 		remainingQuota = -1
 	}
-	for _, layer := range manifest.Layers {
+
+	cancel := make(chan struct{})
+	downloads := r.downloadLayers(log, conn, manifest.Layers, remainingQuota, cancel)
+
+	// layers are downloaded concurrently, but have to be registered parent
+	// first
+	for i, layer := range manifest.Layers {
 		if layer.Image.Config != nil {
-			env = append(env, layer.Image.Config.Env...)
-			vols = append(vols, keys(layer.Image.Config.Volumes)...)
+			env = mergeEnv(env, layer.Image.Config.Env)
+			vols = mergeVolumes(vols, layer.Image.Config.Volumes)
 		}
 
-		size, err := r.fetchLayer(log, conn, layer, remainingQuota)
+		size, err := r.registerLayer(log, <-downloads[i], remainingQuota)
 		if err != nil {
+			close(cancel)
+			go r.discardDownloads(downloads[i+1:])
 			return nil, err
 		}
 		remainingQuota -= size
 	}
 
-	return &Image{
+	image := &Image{
 		ImageID: hex(manifest.Layers[len(manifest.Layers)-1].StrongID),
 		Env:     env,
 		Volumes: vols,
 		Size:    totalImageSize,
-	}, nil
+	}
+	setProcessDefaults(image, manifest)
+
+	if r.Index != nil {
+		if err := r.Index.Put(indexedImage(r.imageRef(u), manifest.Digest, image)); err != nil {
+			log.Error("failed-to-update-image-index", err)
+		}
+	}
+
+	return image, nil
 }
 
 func (r *Remote) FetchID(log lager.Logger, u *url.URL) (layercake.ID, error) {
-	_, manifest, err := r.manifest(log.Session("fetch-id"), u, "", "")
+	log = log.Session("fetch-id")
+
+	// an image referenced by digest cannot change, so there is no need to ask
+	// the registry again once it is in the cake
+	if _, ref := splitReference(u); isDigest(ref) {
+		if id, ok := r.pinnedID(digest.Digest(ref)); ok {
+			if _, err := r.Cake.Get(id); err == nil {
+				log.Debug("got-pinned-id", lager.Data{"id": id})
+				return id, nil
+			}
+		}
+	}
+
+	if indexed, ok, err := r.presentImage(log, u); err != nil {
+		return nil, err
+	} else if ok {
+		return layercake.DockerImageID(indexed.ImageID), nil
+	}
+
+	_, manifest, err := r.manifest(log, u, "", "")
 	if err != nil {
 		return nil, err
 	}
@@ -92,80 +177,355 @@ func (r *Remote) FetchID(log lager.Logger, u *url.URL) (layercake.ID, error) {
 	return layercake.DockerImageID(hex(manifest.Layers[len(manifest.Layers)-1].StrongID)), nil
 }
 
+// LocalID returns the ID of the image u resolved to when it was last fetched,
+// as long as it is still in the cake, without asking the registry
+func (r *Remote) LocalID(log lager.Logger, u *url.URL) (layercake.ID, error) {
+	ref := r.imageRef(u)
+
+	if _, digestRef := splitReference(u); isDigest(digestRef) {
+		if id, ok := r.pinnedID(digest.Digest(digestRef)); ok {
+			if _, err := r.Cake.Get(id); err == nil {
+				return id, nil
+			}
+		}
+	}
+
+	if r.Index != nil {
+		if indexed, ok := r.Index.Get(ref); ok {
+			id := layercake.DockerImageID(indexed.ImageID)
+			if _, err := r.Cake.Get(id); err == nil {
+				log.Debug("got-local-id", lager.Data{"ref": ref, "id": id})
+				return id, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("image %s has not been fetched", ref)
+}
+
 func (r *Remote) manifest(log lager.Logger, u *url.URL, username, password string) (distclient.Conn, *distclient.Manifest, error) {
 	log = log.Session("get-manifest", lager.Data{"url": u})
 
 	log.Debug("started")
 	defer log.Debug("got")
 
-	host := u.Host
+	host, path, ref := r.repository(u)
+
+	conn, err := r.Dial.Dial(log, host, path, username, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest, err := conn.GetManifest(log, ref)
+	if err != nil {
+		return nil, nil, wrapError(err, "get manifest for %s on repo %s: %s", ref, u, err)
+	}
+
+	if isDigest(ref) && len(manifest.Layers) > 0 {
+		r.pin(log, digest.Digest(ref), manifest.Layers[len(manifest.Layers)-1].StrongID)
+	}
+
+	return conn, manifest, err
+}
+
+// repository returns the registry host and repository path of u, and the tag
+// or manifest digest it refers to
+func (r *Remote) repository(u *url.URL) (host, path, ref string) {
+	host = u.Host
 	if host == "" {
 		host = r.DefaultHost
 	}
 
+	path, ref = splitReference(u)
+
 	isDockerHub := host == "registry-1.docker.io"
-	path := u.Path[1:] // strip off initial '/'
 	isOfficialImage := strings.Index(path, "/") < 0
 	if isDockerHub && isOfficialImage {
 		// The Docker Hub keeps manifests of official images under library/
 		path = "library/" + path
 	}
 
-	tag := u.Fragment
-	if tag == "" {
-		tag = "latest"
+	return host, path, ref
+}
+
+// presentImage returns the image u resolved to when it was last fetched, as
+// long as the pull policy allows using it and it is still in the cake
+func (r *Remote) presentImage(log lager.Logger, u *url.URL) (IndexedImage, bool, error) {
+	if r.PullPolicy == "" || r.PullPolicy == PullAlways {
+		return IndexedImage{}, false, nil
+	}
+
+	ref := r.imageRef(u)
+	if r.Index != nil {
+		if indexed, ok := r.Index.Get(ref); ok {
+			if _, err := r.Cake.Get(layercake.DockerImageID(indexed.ImageID)); err == nil {
+				log.Info("using-present-image", lager.Data{"ref": ref, "id": indexed.ImageID})
+
+				if err := r.Index.Touch(ref); err != nil {
+					log.Error("failed-to-update-image-index", err)
+				}
+
+				return indexed, true, nil
+			}
+		}
 	}
 
-	conn, err := r.Dial.Dial(log, host, path, username, password)
-	if err != nil {
-		return nil, nil, err
+	if r.PullPolicy == PullNever {
+		return IndexedImage{}, false, &ImageNotPresentError{Reference: ref}
 	}
 
-	manifest, err := conn.GetManifest(log, tag)
-	if err != nil {
-		return nil, nil, fmt.Errorf("get manifest for tag %s on repo %s: %s", u.Fragment, u, err)
+	return IndexedImage{}, false, nil
+}
+
+// verifyTrust checks the image u refers to, going by its manifest digest,
+// against the TrustPolicy if there is one
+func (r *Remote) verifyTrust(log lager.Logger, u *url.URL, manifestDigest digest.Digest) error {
+	if r.TrustPolicy == nil {
+		return nil
 	}
 
-	return conn, manifest, err
+	host, path, _ := r.repository(u)
+	if err := r.TrustPolicy.Verify(log, host, path, manifestDigest); err != nil {
+		log.Error("untrusted-image", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Remote) imageRef(u *url.URL) string {
+	host, path, ref := r.repository(u)
+	if isDigest(ref) {
+		return host + "/" + path + "@" + ref
+	}
+
+	return host + "/" + path + ":" + ref
+}
+
+// pin records the image a manifest digest resolved to in the index
+func (r *Remote) pin(log lager.Logger, manifestDigest, topLayerID digest.Digest) {
+	if r.Index == nil {
+		return
+	}
+
+	if err := r.Index.Pin(manifestDigest, hex(topLayerID)); err != nil {
+		log.Error("failed-to-pin-manifest-digest", err, lager.Data{"digest": manifestDigest})
+	}
+}
+
+func (r *Remote) pinnedID(manifestDigest digest.Digest) (layercake.ID, bool) {
+	if r.Index == nil {
+		return nil, false
+	}
+
+	imageID, ok := r.Index.Pinned(manifestDigest)
+	if !ok {
+		return nil, false
+	}
+
+	return layercake.DockerImageID(imageID), true
+}
+
+// layerDownload is a downloaded and verified layer waiting to be registered,
+// or one that was already in the cake, in which case it has no blob
+type layerDownload struct {
+	layer distclient.Layer
+	blob  io.ReadCloser
+	size  int64
+	err   error
+}
+
+// downloadLayers downloads up to MaxConcurrentDownloads layers at once, in
+// order, and delivers each of them on its own channel. Layers that have not
+// started downloading when cancel is closed are skipped and their channels
+// closed.
+func (r *Remote) downloadLayers(log lager.Logger, conn distclient.Conn, layers []distclient.Layer, quota int64, cancel <-chan struct{}) []chan layerDownload {
+	downloads := make([]chan layerDownload, len(layers))
+	for i := range downloads {
+		downloads[i] = make(chan layerDownload, 1)
+	}
+
+	next := make(chan int)
+	go func() {
+		defer close(next)
+
+		for i := range layers {
+			select {
+			case next <- i:
+			case <-cancel:
+				for _, d := range downloads[i:] {
+					close(d)
+				}
+				return
+			}
+		}
+	}()
+
+	workers := r.MaxConcurrentDownloads
+	if workers < 1 {
+		workers = 1
+	}
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range next {
+				downloads[i] <- r.downloadLayer(log, conn, layers[i], quota)
+			}
+		}()
+	}
+
+	return downloads
 }
 
-func (r *Remote) fetchLayer(log lager.Logger, conn distclient.Conn, layer distclient.Layer, quota int64) (int64, error) {
-	log = log.Session("fetch-layer", lager.Data{"size": layer.Image.Size, "blobsum": layer.BlobSum, "id": layer.StrongID, "parent": layer.ParentStrongID})
+func (r *Remote) downloadLayer(log lager.Logger, conn distclient.Conn, layer distclient.Layer, quota int64) layerDownload {
+	log = log.Session("download-layer", lager.Data{"size": layer.Image.Size, "blobsum": layer.BlobSum, "id": layer.StrongID, "parent": layer.ParentStrongID})
 
 	log.Info("start")
-	defer log.Info("fetched")
+	defer log.Info("downloaded")
 
-	r.FetchLock.Acquire(layer.BlobSum.String())
-	defer r.FetchLock.Release(layer.BlobSum.String())
+	id := layercake.DockerImageID(hex(layer.StrongID))
+	for {
+		if image, err := r.Cake.Get(id); err == nil {
+			log.Info("got-cache")
+			return layerDownload{layer: layer, size: image.Size}
+		}
 
-	if image, err := r.Cake.Get(layercake.DockerImageID(hex(layer.StrongID))); err == nil {
-		log.Info("got-cache")
-		return image.Size, nil
+		// layers sharing the blob share its download, rather than waiting for
+		// each other, since they may well be registered in another order than
+		// they were downloaded in
+		verifiedBlob, size, err := r.blobs.get(layer.BlobSum, func() (io.ReadCloser, int64, error) {
+			// another fetch may have registered the layer and let go of the
+			// blob since the layer was looked up
+			if _, err := r.Cake.Get(id); err == nil {
+				return nil, 0, errLayerRegistered
+			}
+
+			return r.downloadBlob(log, conn, layer.BlobSum, quota)
+		})
+
+		if err == errLayerRegistered {
+			// which may have been another layer sharing the blob
+			continue
+		}
+
+		if err != nil {
+			return layerDownload{layer: layer, err: err}
+		}
+
+		if err := verifyDiffID(log, verifiedBlob, layer); err != nil {
+			verifiedBlob.Close()
+			return layerDownload{layer: layer, err: err}
+		}
+
+		log.Debug("verified")
+		return layerDownload{layer: layer, blob: verifiedBlob, size: size}
 	}
+}
 
-	blob, err := conn.GetBlobReader(log, layer.BlobSum)
-	if err != nil {
-		return 0, err
+// verifyDiffID checks the uncompressed layer against the diff ID its strong ID
+// was worked out from, so that a layer is never registered under the ID of
+// another, and rewinds it for registering
+func verifyDiffID(log lager.Logger, blob io.ReadSeeker, layer distclient.Layer) error {
+	// an uncompressed layer has been verified against its diff ID already
+	if layer.DiffID == "" || layer.DiffID == layer.BlobSum {
+		return nil
+	}
+
+	log.Debug("verifying-diff-id", lager.Data{"diff-id": layer.DiffID})
+	if err := VerifyDiffID(blob, layer.DiffID); err != nil {
+		log.Error("diff-id-verification-failed", err, lager.Data{"diff-id": layer.DiffID})
+		return err
 	}
 
-	blob = applyQuota(blob, quota)
+	_, err := blob.Seek(0, 0)
+	return err
+}
+
+var errLayerRegistered = errors.New("layer registered while its blob was being looked up")
+
+func (r *Remote) downloadBlob(log lager.Logger, conn distclient.Conn, d digest.Digest, quota int64) (io.ReadCloser, int64, error) {
+	if r.PartialBlobs == nil {
+		blob, err := conn.GetBlobReader(log, d)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer blob.Close()
+
+		log.Debug("verifying")
+		return r.Verifier.Verify(applyQuota(blob, quota), d)
+	}
+
+	verifiedBlob, size, resumed, err := r.downloadPartialBlob(log, conn, d, quota)
+	if err == ErrDigestMismatch && resumed {
+		// whatever was downloaded before may be what is corrupt, so the blob
+		// is downloaded again from scratch, once
+		log.Info("resumed-blob-corrupt-downloading-again")
+		verifiedBlob, size, _, err = r.downloadPartialBlob(log, conn, d, quota)
+	}
+
+	return verifiedBlob, size, err
+}
+
+// downloadPartialBlob downloads the blob through PartialBlobs and verifies it
+// where it was downloaded to. The partial download is thrown away unless the
+// download itself fails, in which case it is kept to be resumed.
+func (r *Remote) downloadPartialBlob(log lager.Logger, conn distclient.Conn, d digest.Digest, quota int64) (io.ReadCloser, int64, bool, error) {
+	blob, err := r.PartialBlobs.Download(log, conn, d, quota)
+	if err != nil {
+		return nil, 0, false, err
+	}
 
 	log.Debug("verifying")
-	verifiedBlob, size, err := r.Verifier.Verify(blob, layer.BlobSum)
-	blob.Close()
+	verifiedBlob, size, err := r.Verifier.Verify(blob, d)
 	if err != nil {
-		return 0, err
+		blob.Close()
+		return nil, 0, blob.Resumed, err
+	}
+
+	if verifiedBlob != io.ReadCloser(blob) {
+		blob.Close()
+	}
+
+	return verifiedBlob, size, blob.Resumed, nil
+}
+
+func (r *Remote) registerLayer(log lager.Logger, download layerDownload, quota int64) (int64, error) {
+	if download.err != nil {
+		return 0, download.err
+	}
+
+	if download.blob == nil {
+		return download.size, nil
+	}
+
+	layer := download.layer
+	log = log.Session("register-layer", lager.Data{"size": download.size, "blobsum": layer.BlobSum, "id": layer.StrongID, "parent": layer.ParentStrongID})
+
+	log.Info("start")
+	defer log.Info("registered")
+
+	defer download.blob.Close()
+
+	// fetches sharing the layer register it once, in whichever order they get
+	// to it
+	r.FetchLock.Acquire(layer.StrongID.String())
+	defer r.FetchLock.Release(layer.StrongID.String())
+
+	if image, err := r.Cake.Get(layercake.DockerImageID(hex(layer.StrongID))); err == nil {
+		log.Info("registered-by-another-fetch")
+		return image.Size, nil
 	}
 
-	log.Debug("verified")
-	defer verifiedBlob.Close()
+	// the layer was downloaded before the sizes of its parents were known
+	if quota >= 0 && download.size > quota {
+		return 0, quotaedreader.NewQuotaExceededErr()
+	}
 
-	log.Debug("registering")
-	err = r.Cake.RegisterWithQuota(&image.Image{
+	err := r.Cake.RegisterWithQuota(&image.Image{
 		ID:     hex(layer.StrongID),
 		Parent: hex(layer.ParentStrongID),
-		Size:   size,
-	}, verifiedBlob, quota)
+		Size:   download.size,
+	}, download.blob, quota)
 	if err != nil {
 		if strings.Contains(err.Error(), "unexpected EOF") {
 			err = fmt.Errorf("%v. Possible cause: %v", err, quotaedreader.NewQuotaExceededErr())
@@ -173,7 +533,19 @@ func (r *Remote) fetchLayer(log lager.Logger, conn distclient.Conn, layer distcl
 		return 0, err
 	}
 
-	return size, nil
+	return download.size, nil
+}
+
+// discardDownloads releases layers downloaded for a fetch that failed
+func (r *Remote) discardDownloads(downloads []chan layerDownload) {
+	for _, d := range downloads {
+		download, ok := <-d
+		if !ok || download.blob == nil {
+			continue
+		}
+
+		download.blob.Close()
+	}
 }
 
 func applyQuota(r io.ReadCloser, quota int64) io.ReadCloser {
@@ -188,13 +560,105 @@ type Dialer interface {
 	Dial(logger lager.Logger, host, repo, username, password string) (distclient.Conn, error)
 }
 
+// splitReference returns the repository path and the tag or manifest digest
+// referred to by u. Digests can be given docker style, as in
+// docker:///busybox@sha256:..., or in place of the tag, as in
+// docker:///busybox#sha256:...
+func splitReference(u *url.URL) (path, ref string) {
+	path = u.Path[1:] // strip off initial '/'
+
+	if i := strings.Index(path, "@"); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+
+	if u.Fragment == "" {
+		return path, "latest"
+	}
+
+	return path, u.Fragment
+}
+
+func isDigest(ref string) bool {
+	_, err := digest.ParseDigest(ref)
+	return err == nil
+}
+
+// mergeEnv sets the variables of a higher layer over env. A variable keeps
+// its position when it is overridden, and new ones are added at the end.
+func mergeEnv(env, layerEnv []string) []string {
+	for _, variable := range layerEnv {
+		name := variable
+		if i := strings.Index(variable, "="); i >= 0 {
+			name = variable[:i]
+		}
+
+		overridden := false
+		for i, existing := range env {
+			if existing == name || strings.HasPrefix(existing, name+"=") {
+				env[i] = variable
+				overridden = true
+				break
+			}
+		}
+
+		if !overridden {
+			env = append(env, variable)
+		}
+	}
+
+	return env
+}
+
+// mergeVolumes adds the volumes of a higher layer that vols does not have yet
+func mergeVolumes(vols []string, layerVols map[string]struct{}) []string {
+	for _, vol := range keys(layerVols) {
+		if !contains(vols, vol) {
+			vols = append(vols, vol)
+		}
+	}
+
+	return vols
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+
+	return false
+}
+
 func keys(m map[string]struct{}) (r []string) {
 	for k, _ := range m {
 		r = append(r, k)
 	}
+	sort.Strings(r)
 	return
 }
 
+// setProcessDefaults copies the process defaults of the image from the config
+// of its top layer, which describes the whole image
+func setProcessDefaults(image *Image, manifest *distclient.Manifest) {
+	image.StopSignal = manifest.StopSignal
+
+	config := manifest.Layers[len(manifest.Layers)-1].Image.Config
+	if config == nil {
+		return
+	}
+
+	image.User = config.User
+	image.WorkingDir = config.WorkingDir
+	image.Entrypoint = config.Entrypoint.Slice()
+	image.Cmd = config.Cmd.Slice()
+
+	for port := range config.ExposedPorts {
+		image.ExposedPorts = append(image.ExposedPorts, string(port))
+	}
+	sort.Strings(image.ExposedPorts)
+}
+
 func hex(d digest.Digest) string {
 	if d == "" {
 		return ""
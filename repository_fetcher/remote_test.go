@@ -2,14 +2,18 @@ package repository_fetcher_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"io"
 	"io/ioutil"
 	"net/url"
+	"os"
+	"strings"
 	"sync"
 
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/nat"
 	"github.com/docker/docker/runconfig"
 
 	"code.cloudfoundry.org/garden-shed/distclient"
@@ -227,10 +231,21 @@ var _ = Describe("Fetching from a Remote repo", func() {
 	})
 
 	Context("when the cake does not contain any of the layers", func() {
+		var registeredContents []string
+
 		JustBeforeEach(func() {
 			fakeVerifier.VerifyStub = func(r io.Reader, d digest.Digest) (io.ReadCloser, int64, error) {
 				return &verified{Reader: r}, 15, nil
 			}
+
+			// the blobs are closed once they have been registered
+			registeredContents = nil
+			fakeCake.RegisterWithQuotaStub = func(_ *image.Image, blob archive.ArchiveReader, _ int64) error {
+				content, err := ioutil.ReadAll(blob)
+				registeredContents = append(registeredContents, string(content))
+				return err
+			}
+
 			_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
 			Expect(err).NotTo(HaveOccurred())
 		})
@@ -240,13 +255,11 @@ var _ = Describe("Fetching from a Remote repo", func() {
 		})
 
 		It("registers the layer contents under its Strong IDs", func() {
-			image, reader, _ := fakeCake.RegisterWithQuotaArgsForCall(0)
+			image, _, _ := fakeCake.RegisterWithQuotaArgsForCall(0)
 			Expect(image.ID).To(Equal("abc-id"))
 			Expect(image.Parent).To(Equal("abc-parent-id"))
 
-			b, err := ioutil.ReadAll(reader)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(string(b)).To(Equal("abc-def-content"))
+			Expect(registeredContents[0]).To(Equal("abc-def-content"))
 		})
 
 		It("registers the layer with the correct size", func() {
@@ -297,6 +310,28 @@ var _ = Describe("Fetching from a Remote repo", func() {
 		Expect(img.Volumes).To(ConsistOf([]string{"vol1", "vol2"}))
 	})
 
+	Context("when higher layers override the config of lower layers", func() {
+		JustBeforeEach(func() {
+			layers := manifests["some-tag"].Layers
+			layers[0].Image.Config.Env = []string{"PATH=/bin", "HOME=/root", "LANG=C"}
+			layers[0].Image.Config.Volumes = map[string]struct{}{"/data": struct{}{}, "/logs": struct{}{}}
+			layers[2].Image.Config.Env = []string{"HOME=/root", "PATH=/usr/local/bin:/bin", "USER=app", "USER=nobody"}
+			layers[2].Image.Config.Volumes = map[string]struct{}{"/data": struct{}{}, "/cache": struct{}{}}
+		})
+
+		It("resolves each variable to its value in the highest layer, keeping the order", func() {
+			img, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(img.Env).To(Equal([]string{"PATH=/usr/local/bin:/bin", "HOME=/root", "LANG=C", "USER=nobody"}))
+		})
+
+		It("lists each volume once", func() {
+			img, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(img.Volumes).To(Equal([]string{"/data", "/logs", "/cache"}))
+		})
+	})
+
 	It("should enforce quota agains actual layer size", func() {
 		fakeVerifier.VerifyStub = func(r io.Reader, d digest.Digest) (io.ReadCloser, int64, error) {
 			content, err := ioutil.ReadAll(r)
@@ -333,27 +368,51 @@ var _ = Describe("Fetching from a Remote repo", func() {
 	})
 
 	It("should verify the image against its digest", func() {
+		fakeVerifier.VerifyStub = func(r io.Reader, d digest.Digest) (io.ReadCloser, int64, error) {
+			return &verified{Reader: strings.NewReader("verified " + string(d))}, 0, nil
+		}
+
+		var registered []string
+		fakeCake.RegisterWithQuotaStub = func(img *image.Image, blob archive.ArchiveReader, quota int64) error {
+			content, err := ioutil.ReadAll(blob)
+			registered = append(registered, string(content))
+			return err
+		}
+
 		remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
-		_, reader, _ := fakeCake.RegisterWithQuotaArgsForCall(0)
 
-		Expect(reader).To(BeAssignableToTypeOf(&verified{}))
-		_, digest1 := fakeVerifier.VerifyArgsForCall(0)
-		_, digest2 := fakeVerifier.VerifyArgsForCall(1)
-		Expect(string(digest1)).To(Equal("abc-def"))
-		Expect(string(digest2)).To(Equal("ghj-klm"))
+		Expect(registered).To(Equal([]string{"verified abc-def", "verified ghj-klm", "verified klm-nop"}))
+		var digests []digest.Digest
+		for i := 0; i < fakeVerifier.VerifyCallCount(); i++ {
+			_, d := fakeVerifier.VerifyArgsForCall(i)
+			digests = append(digests, d)
+		}
+
+		Expect(digests).To(ConsistOf(digest.Digest("abc-def"), digest.Digest("ghj-klm"), digest.Digest("klm-nop")))
 	})
 
-	It("should close the verified image reader after using it", func() {
-		var registeredBlob *verified
-		fakeCake.RegisterWithQuotaStub = func(img *image.Image, blob archive.ArchiveReader, quota int64) error {
-			Expect(blob).To(BeAssignableToTypeOf(&verified{}))
-			Expect(blob.(*verified).closed).To(BeFalse())
-			registeredBlob = blob.(*verified)
-			return nil
+	It("should close the verified image readers after using them", func() {
+		var (
+			mu            sync.Mutex
+			verifiedBlobs []*verified
+		)
+
+		fakeVerifier.VerifyStub = func(r io.Reader, d digest.Digest) (io.ReadCloser, int64, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			blob := &verified{Reader: r}
+			verifiedBlobs = append(verifiedBlobs, blob)
+			return blob, 0, nil
 		}
 
-		remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
-		Expect(registeredBlob.closed).To(BeTrue())
+		_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(verifiedBlobs).To(HaveLen(3))
+		for _, blob := range verifiedBlobs {
+			Expect(blob.closed).To(BeTrue())
+		}
 	})
 
 	Context("when the layer does not match its digest", func() {
@@ -371,6 +430,47 @@ var _ = Describe("Fetching from a Remote repo", func() {
 		})
 	})
 
+	Context("when the layers have diff IDs", func() {
+		const contents = "the uncompressed contents of the layer"
+		var registeredContents []string
+
+		JustBeforeEach(func() {
+			blobs["gzipped"] = gzipped(contents)
+			manifests["diff-ids"] = &distclient.Manifest{
+				Layers: []distclient.Layer{
+					{BlobSum: "gzipped", StrongID: "sha256:gzipped-id", DiffID: digestOf(contents)},
+				},
+			}
+
+			registeredContents = nil
+			fakeCake.RegisterWithQuotaStub = func(_ *image.Image, blob archive.ArchiveReader, _ int64) error {
+				content, err := ioutil.ReadAll(blob)
+				registeredContents = append(registeredContents, string(content))
+				return err
+			}
+		})
+
+		It("registers the layers that match them, as they were downloaded", func() {
+			_, err := remote.Fetch(logger, parseURL("docker:///foo#diff-ids"), "", "", 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(registeredContents).To(Equal([]string{gzipped(contents)}))
+		})
+
+		Context("when a layer does not match its diff ID", func() {
+			JustBeforeEach(func() {
+				manifests["diff-ids"].Layers[0].DiffID = digestOf("the contents the config promised")
+			})
+
+			It("fails the fetch without registering the layer", func() {
+				_, err := remote.Fetch(logger, parseURL("docker:///foo#diff-ids"), "", "", 0)
+				Expect(err).To(Equal(repository_fetcher.ErrDiffIDMismatch))
+
+				Expect(fakeCake.RegisterWithQuotaCallCount()).To(Equal(0))
+			})
+		})
+	})
+
 	Describe("concurrently fetching", func() {
 		It("serializes calls to cake.get and getblobreader", func() {
 			for i := 1; i < 100; i++ {
@@ -408,6 +508,508 @@ var _ = Describe("Fetching from a Remote repo", func() {
 		})
 	})
 
+	Describe("downloading layers concurrently", func() {
+		var (
+			mu            sync.Mutex
+			inFlight      int
+			maxInFlight   int
+			releaseBottom chan struct{}
+		)
+
+		JustBeforeEach(func() {
+			inFlight, maxInFlight = 0, 0
+			releaseBottom = make(chan struct{})
+
+			fakeConn.GetBlobReaderStub = func(_ lager.Logger, d digest.Digest) (io.ReadCloser, error) {
+				mu.Lock()
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				mu.Unlock()
+
+				defer func() {
+					mu.Lock()
+					inFlight--
+					mu.Unlock()
+				}()
+
+				// the bottom layer is the slowest to download
+				if d == "abc-def" && remote.MaxConcurrentDownloads > 1 {
+					<-releaseBottom
+				}
+
+				return ioutil.NopCloser(bytes.NewReader([]byte(blobs[d]))), nil
+			}
+		})
+
+		It("downloads the layers of an image at the same time", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+
+				_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+				Expect(err).NotTo(HaveOccurred())
+			}()
+
+			Eventually(fakeConn.GetBlobReaderCallCount).Should(Equal(3))
+			close(releaseBottom)
+			Eventually(done).Should(BeClosed())
+
+			Expect(maxInFlight).To(BeNumerically(">", 1))
+		})
+
+		It("registers the layers parent first", func() {
+			go func() {
+				defer GinkgoRecover()
+				Eventually(fakeConn.GetBlobReaderCallCount).Should(Equal(3))
+				close(releaseBottom)
+			}()
+
+			_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeCake.RegisterWithQuotaCallCount()).To(Equal(3))
+			for i, id := range []string{"abc-id", "ghj-id", "klm-id"} {
+				img, _, _ := fakeCake.RegisterWithQuotaArgsForCall(i)
+				Expect(img.ID).To(Equal(id))
+			}
+		})
+
+		Context("when the concurrency is limited to a single download", func() {
+			JustBeforeEach(func() {
+				remote.MaxConcurrentDownloads = 1
+			})
+
+			It("downloads one layer at a time", func() {
+				_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeConn.GetBlobReaderCallCount()).To(Equal(3))
+				Expect(maxInFlight).To(Equal(1))
+			})
+		})
+
+		Context("when a layer fails to download", func() {
+			JustBeforeEach(func() {
+				close(releaseBottom)
+
+				failed := false
+				fakeVerifier.VerifyStub = func(r io.Reader, d digest.Digest) (io.ReadCloser, int64, error) {
+					mu.Lock()
+					defer mu.Unlock()
+
+					if d == "ghj-klm" && !failed {
+						failed = true
+						return nil, 0, errors.New("boom")
+					}
+
+					return &verified{Reader: r}, 0, nil
+				}
+			})
+
+			It("does not register the layers above it", func() {
+				_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+				Expect(err).To(MatchError("boom"))
+
+				Expect(fakeCake.RegisterWithQuotaCallCount()).To(Equal(1))
+			})
+
+			It("releases the layers it downloaded", func() {
+				_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+				Expect(err).To(MatchError("boom"))
+
+				_, err = remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+	})
+
+	Context("when interrupted downloads are kept", func() {
+		var (
+			partialsDir    string
+			rangeBlobs     map[digest.Digest]string
+			brokenDownload bool
+		)
+
+		JustBeforeEach(func() {
+			var err error
+			partialsDir, err = ioutil.TempDir("", "partial-blobs")
+			Expect(err).NotTo(HaveOccurred())
+
+			remote.PartialBlobs, err = repository_fetcher.NewPartialBlobs(partialsDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			rangeBlobs = blobs
+			brokenDownload = true
+			fakeConn.GetBlobReaderStub = func(_ lager.Logger, d digest.Digest) (io.ReadCloser, error) {
+				if d == "ghj-klm" && brokenDownload {
+					brokenDownload = false
+					return &brokenReader{Reader: bytes.NewReader([]byte("ghj"))}, nil
+				}
+
+				return ioutil.NopCloser(bytes.NewReader([]byte(blobs[d]))), nil
+			}
+
+			fakeConn.GetBlobRangeReaderStub = func(_ lager.Logger, d digest.Digest, offset int64) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader([]byte(rangeBlobs[d][offset:]))), nil
+			}
+
+			fakeVerifier.VerifyStub = func(r io.Reader, d digest.Digest) (io.ReadCloser, int64, error) {
+				content, err := ioutil.ReadAll(r)
+				if err != nil {
+					return nil, 0, err
+				}
+
+				if string(content) != blobs[d] {
+					return nil, 0, repository_fetcher.ErrDigestMismatch
+				}
+
+				return &verified{Reader: bytes.NewReader(content)}, int64(len(content)), nil
+			}
+
+			_, err = remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+			Expect(err).To(MatchError("connection reset"))
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(partialsDir)).To(Succeed())
+		})
+
+		It("resumes them on the next fetch", func() {
+			_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeConn.GetBlobRangeReaderCallCount()).To(Equal(1))
+			_, d, offset := fakeConn.GetBlobRangeReaderArgsForCall(0)
+			Expect(d).To(BeEquivalentTo("ghj-klm"))
+			Expect(offset).To(BeEquivalentTo(3))
+		})
+
+		It("throws them away once they have been verified", func() {
+			_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.ReadDir(partialsDir)).To(BeEmpty())
+		})
+
+		Context("when a blob is at more than one position of an image", func() {
+			var secondDownloading chan struct{}
+
+			JustBeforeEach(func() {
+				remote.MaxConcurrentDownloads = 2
+
+				manifests["repeated-blob"] = &distclient.Manifest{
+					Layers: []distclient.Layer{
+						{BlobSum: "ghj-klm", StrongID: "sha256:first-id"},
+						{BlobSum: "ghj-klm", StrongID: "sha256:second-id", ParentStrongID: "sha256:first-id"},
+					},
+				}
+
+				secondDownloading = make(chan struct{})
+				var once sync.Once
+				fakeConn.GetBlobRangeReaderStub = func(_ lager.Logger, d digest.Digest, offset int64) (io.ReadCloser, error) {
+					once.Do(func() { close(secondDownloading) })
+					return ioutil.NopCloser(bytes.NewReader([]byte(rangeBlobs[d][offset:]))), nil
+				}
+
+				fakeCake.GetStub = func(id layercake.ID) (*image.Image, error) {
+					// the bottom layer only starts downloading once the layer
+					// above it has
+					if id.GraphID() == "first-id" {
+						<-secondDownloading
+					}
+
+					return nil, errors.New("doesnt exist")
+				}
+			})
+
+			It("registers both layers without waiting on each other", func() {
+				registered := fakeCake.RegisterWithQuotaCallCount()
+
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					defer close(done)
+
+					_, err := remote.Fetch(logger, parseURL("docker:///foo#repeated-blob"), "", "", 67)
+					Expect(err).NotTo(HaveOccurred())
+				}()
+
+				Eventually(done).Should(BeClosed())
+				Expect(fakeCake.RegisterWithQuotaCallCount()).To(Equal(registered + 2))
+			})
+		})
+
+		Context("when the resumed download does not match its digest", func() {
+			JustBeforeEach(func() {
+				rangeBlobs = map[digest.Digest]string{"ghj-klm": "ghj-xxx-something"}
+			})
+
+			It("throws it away and downloads the blob again from scratch", func() {
+				_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeConn.GetBlobRangeReaderCallCount()).To(Equal(1))
+				Expect(ioutil.ReadDir(partialsDir)).To(BeEmpty())
+			})
+
+			Context("and the blob downloaded again does not match either", func() {
+				var corruptDownloads int
+
+				JustBeforeEach(func() {
+					corruptDownloads = 0
+					fakeConn.GetBlobReaderStub = func(_ lager.Logger, d digest.Digest) (io.ReadCloser, error) {
+						if d == "ghj-klm" {
+							corruptDownloads++
+							return ioutil.NopCloser(bytes.NewReader([]byte("ghj-xxx-something"))), nil
+						}
+
+						return ioutil.NopCloser(bytes.NewReader([]byte(blobs[d]))), nil
+					}
+				})
+
+				It("returns the error without trying again", func() {
+					_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+					Expect(err).To(Equal(repository_fetcher.ErrDigestMismatch))
+
+					Expect(fakeConn.GetBlobRangeReaderCallCount()).To(Equal(1))
+					Expect(corruptDownloads).To(Equal(1))
+					Expect(ioutil.ReadDir(partialsDir)).To(BeEmpty())
+				})
+			})
+		})
+	})
+
+	Context("when the registry does not have the manifest", func() {
+		JustBeforeEach(func() {
+			fakeConn.GetManifestStub = nil
+			fakeConn.GetManifestReturns(nil, &distclient.StatusError{Fetching: "manifest some-tag", StatusCode: 404, Status: "404 Not Found"})
+		})
+
+		It("returns an error that is not worth retrying", func() {
+			_, err := remote.Fetch(logger, parseURL("docker:///foo#some-tag"), "", "", 67)
+			Expect(err).To(MatchError(ContainSubstring("404 Not Found")))
+
+			Expect(repository_fetcher.DefaultErrorClassifier{}.Classify(err)).To(Equal(repository_fetcher.ErrorClassPermanent))
+		})
+	})
+
+	Context("when a trust policy is configured", func() {
+		const manifestDigest = "sha256:0123456789012345678901234567890123456789012345678901234567890123"
+
+		var fakeTrustPolicy *fakes.FakeTrustPolicy
+
+		JustBeforeEach(func() {
+			manifests["some-tag"].Digest = manifestDigest
+
+			fakeTrustPolicy = new(fakes.FakeTrustPolicy)
+			remote.TrustPolicy = fakeTrustPolicy
+		})
+
+		It("verifies the manifest digest of the image against the policy", func() {
+			_, err := remote.Fetch(logger, parseURL("docker://some-host/some/repo#some-tag"), "", "", 67)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeTrustPolicy.VerifyCallCount()).To(Equal(1))
+			_, host, repo, d := fakeTrustPolicy.VerifyArgsForCall(0)
+			Expect(host).To(Equal("some-host"))
+			Expect(repo).To(Equal("some/repo"))
+			Expect(d).To(BeEquivalentTo(manifestDigest))
+		})
+
+		Context("when the policy rejects the image", func() {
+			JustBeforeEach(func() {
+				fakeTrustPolicy.VerifyReturns(&repository_fetcher.UntrustedImageError{Image: "some-image", Reason: "not signed"})
+			})
+
+			It("returns an error that is not worth retrying", func() {
+				_, err := remote.Fetch(logger, parseURL("docker://some-host/some/repo#some-tag"), "", "", 67)
+				Expect(err).To(MatchError("untrusted image some-image: not signed"))
+
+				Expect(repository_fetcher.DefaultErrorClassifier{}.Classify(err)).To(Equal(repository_fetcher.ErrorClassPermanent))
+			})
+
+			It("does not download or register any layer", func() {
+				remote.Fetch(logger, parseURL("docker://some-host/some/repo#some-tag"), "", "", 67)
+
+				Expect(fakeConn.GetBlobReaderCallCount()).To(Equal(0))
+				Expect(fakeCake.RegisterWithQuotaCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	It("records what the reference resolved to in the image index", func() {
+		manifests["some-tag"].Digest = "sha256:abc"
+
+		_, err := remote.Fetch(logger, parseURL("docker:///foo/bar#some-tag"), "", "", 67)
+		Expect(err).NotTo(HaveOccurred())
+
+		indexed, ok := remote.Index.Get("registry-1.docker.io/foo/bar:some-tag")
+		Expect(ok).To(BeTrue())
+		Expect(indexed.ImageID).To(Equal("klm-id"))
+		Expect(indexed.ManifestDigest).To(BeEquivalentTo("sha256:abc"))
+		Expect(indexed.Env).To(Equal([]string{"a", "b", "d", "e", "f"}))
+	})
+
+	Describe("LocalID", func() {
+		It("resolves a fetched image from the image index", func() {
+			_, err := remote.Fetch(logger, parseURL("docker:///foo/bar#some-tag"), "", "", 67)
+			Expect(err).NotTo(HaveOccurred())
+			existingLayers["klm-id"] = true
+
+			id, err := remote.LocalID(logger, parseURL("docker:///foo/bar#some-tag"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).To(Equal(layercake.DockerImageID("klm-id")))
+			Expect(fakeDialer.DialCallCount()).To(Equal(1))
+		})
+
+		It("fails without contacting the registry when the image has not been fetched", func() {
+			_, err := remote.LocalID(logger, parseURL("docker:///foo/bar#some-tag"))
+			Expect(err).To(MatchError("image registry-1.docker.io/foo/bar:some-tag has not been fetched"))
+			Expect(fakeDialer.DialCallCount()).To(Equal(0))
+		})
+
+		Context("when the image has since been removed from the cake", func() {
+			It("fails without contacting the registry", func() {
+				_, err := remote.Fetch(logger, parseURL("docker:///foo/bar#some-tag"), "", "", 67)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = remote.LocalID(logger, parseURL("docker:///foo/bar#some-tag"))
+				Expect(err).To(HaveOccurred())
+				Expect(fakeDialer.DialCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("pull policies", func() {
+		var pullPolicy repository_fetcher.PullPolicy
+
+		JustBeforeEach(func() {
+			remote.PullPolicy = pullPolicy
+		})
+
+		fetchTwice := func() *repository_fetcher.Image {
+			_, err := remote.Fetch(logger, parseURL("docker:///foo/bar#some-tag"), "", "", 67)
+			Expect(err).NotTo(HaveOccurred())
+			existingLayers["klm-id"] = true
+
+			image, err := remote.Fetch(logger, parseURL("docker:///foo/bar#some-tag"), "", "", 67)
+			Expect(err).NotTo(HaveOccurred())
+			return image
+		}
+
+		Context("when the pull policy is always", func() {
+			BeforeEach(func() {
+				pullPolicy = repository_fetcher.PullAlways
+			})
+
+			It("asks the registry every time", func() {
+				fetchTwice()
+				Expect(fakeDialer.DialCallCount()).To(Equal(2))
+			})
+		})
+
+		Context("when the pull policy is if-not-present", func() {
+			BeforeEach(func() {
+				pullPolicy = repository_fetcher.PullIfNotPresent
+			})
+
+			It("does not contact the registry for images it has fetched", func() {
+				image := fetchTwice()
+				Expect(fakeDialer.DialCallCount()).To(Equal(1))
+
+				Expect(image.ImageID).To(Equal("klm-id"))
+				Expect(image.Env).To(Equal([]string{"a", "b", "d", "e", "f"}))
+				Expect(image.Volumes).To(ConsistOf("vol1", "vol2"))
+			})
+
+			It("resolves the ID without contacting the registry", func() {
+				fetchTwice()
+
+				id, err := remote.FetchID(logger, parseURL("docker:///foo/bar#some-tag"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(id).To(Equal(layercake.DockerImageID("klm-id")))
+				Expect(fakeConn.GetManifestCallCount()).To(Equal(1))
+			})
+
+			It("asks the registry for other tags", func() {
+				fetchTwice()
+
+				_, err := remote.Fetch(logger, parseURL("docker:///foo/bar#i-am-a-lie"), "", "", 67)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeDialer.DialCallCount()).To(Equal(2))
+			})
+
+			Context("when a trust policy is configured", func() {
+				var fakeTrustPolicy *fakes.FakeTrustPolicy
+
+				JustBeforeEach(func() {
+					manifests["some-tag"].Digest = "sha256:abc"
+
+					fakeTrustPolicy = new(fakes.FakeTrustPolicy)
+					remote.TrustPolicy = fakeTrustPolicy
+				})
+
+				It("verifies the present image against the policy by its manifest digest", func() {
+					fetchTwice()
+
+					Expect(fakeTrustPolicy.VerifyCallCount()).To(Equal(2))
+					_, host, repo, d := fakeTrustPolicy.VerifyArgsForCall(1)
+					Expect(host).To(Equal("registry-1.docker.io"))
+					Expect(repo).To(Equal("foo/bar"))
+					Expect(d).To(BeEquivalentTo("sha256:abc"))
+				})
+
+				Context("when the policy has since come to reject the image", func() {
+					It("does not use the present image", func() {
+						_, err := remote.Fetch(logger, parseURL("docker:///foo/bar#some-tag"), "", "", 67)
+						Expect(err).NotTo(HaveOccurred())
+						existingLayers["klm-id"] = true
+
+						fakeTrustPolicy.VerifyReturns(&repository_fetcher.UntrustedImageError{Image: "some-image", Reason: "not signed"})
+
+						_, err = remote.Fetch(logger, parseURL("docker:///foo/bar#some-tag"), "", "", 67)
+						Expect(err).To(MatchError("untrusted image some-image: not signed"))
+						Expect(fakeDialer.DialCallCount()).To(Equal(1))
+					})
+				})
+			})
+
+			Context("when the image has since been removed from the cake", func() {
+				It("fetches it again", func() {
+					_, err := remote.Fetch(logger, parseURL("docker:///foo/bar#some-tag"), "", "", 67)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = remote.Fetch(logger, parseURL("docker:///foo/bar#some-tag"), "", "", 67)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fakeDialer.DialCallCount()).To(Equal(2))
+				})
+			})
+		})
+
+		Context("when the pull policy is never", func() {
+			BeforeEach(func() {
+				pullPolicy = repository_fetcher.PullNever
+			})
+
+			It("fails without contacting the registry when the image is not present", func() {
+				_, err := remote.Fetch(logger, parseURL("docker:///foo/bar#some-tag"), "", "", 67)
+				Expect(err).To(MatchError("image registry-1.docker.io/foo/bar:some-tag is not present and the pull policy is never"))
+				Expect(fakeDialer.DialCallCount()).To(Equal(0))
+
+				Expect(repository_fetcher.DefaultErrorClassifier{}.Classify(err)).To(Equal(repository_fetcher.ErrorClassPermanent))
+			})
+
+			It("fails to resolve the ID when the image is not present", func() {
+				_, err := remote.FetchID(logger, parseURL("docker:///foo/bar#some-tag"))
+				Expect(err).To(BeAssignableToTypeOf(&repository_fetcher.ImageNotPresentError{}))
+				Expect(fakeDialer.DialCallCount()).To(Equal(0))
+			})
+		})
+	})
+
 	Context("when credentials are provided", func() {
 		It("dials with the credentials", func() {
 			_, err := remote.Fetch(logger, parseURL("docker:///banana#some-tag"), "username", "password", 32)
@@ -435,11 +1037,127 @@ var _ = Describe("Fetching from a Remote repo", func() {
 		})
 	})
 
+	Context("when the url refers to a manifest digest", func() {
+		const manifestDigest = "sha256:0123456789012345678901234567890123456789012345678901234567890123"
+
+		JustBeforeEach(func() {
+			manifests[manifestDigest] = manifests["some-tag"]
+		})
+
+		It("dials the repository without the digest", func() {
+			_, err := remote.Fetch(logger, parseURL("docker:///foo/bar@"+manifestDigest), "", "", 67)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, _, repo, _, _ := fakeDialer.DialArgsForCall(0)
+			Expect(repo).To(Equal("foo/bar"))
+		})
+
+		It("fetches the manifest by digest", func() {
+			img, err := remote.Fetch(logger, parseURL("docker:///foo/bar@"+manifestDigest), "", "", 67)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(img.ImageID).To(Equal("klm-id"))
+
+			_, reference := fakeConn.GetManifestArgsForCall(0)
+			Expect(reference).To(Equal(manifestDigest))
+		})
+
+		It("accepts the digest in place of the tag", func() {
+			_, err := remote.Fetch(logger, parseURL("docker:///foo/bar#"+manifestDigest), "", "", 67)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, reference := fakeConn.GetManifestArgsForCall(0)
+			Expect(reference).To(Equal(manifestDigest))
+		})
+
+		Context("when the image has already been fetched", func() {
+			JustBeforeEach(func() {
+				_, err := remote.Fetch(logger, parseURL("docker:///foo/bar@"+manifestDigest), "", "", 67)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			Context("and its top layer is in the cake", func() {
+				JustBeforeEach(func() {
+					existingLayers["klm-id"] = true
+				})
+
+				It("fetches the ID without asking the registry", func() {
+					id, err := remote.FetchID(logger, parseURL("docker:///foo/bar@"+manifestDigest))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(id).To(Equal(layercake.DockerImageID("klm-id")))
+
+					Expect(fakeConn.GetManifestCallCount()).To(Equal(1))
+				})
+
+				It("fetches the ID without asking the registry after a restart", func() {
+					restarted := repository_fetcher.NewRemote(defaultDockerRegistryHost, fakeCake, fakeDialer, fakeVerifier)
+					restarted.Index = remote.Index
+
+					id, err := restarted.FetchID(logger, parseURL("docker:///foo/bar@"+manifestDigest))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(id).To(Equal(layercake.DockerImageID("klm-id")))
+
+					Expect(fakeConn.GetManifestCallCount()).To(Equal(1))
+				})
+
+				It("records the pin in the image index", func() {
+					imageID, ok := remote.Index.Pinned(manifestDigest)
+					Expect(ok).To(BeTrue())
+					Expect(imageID).To(Equal("klm-id"))
+				})
+			})
+
+			Context("and its top layer has since been removed from the cake", func() {
+				It("fetches the manifest again", func() {
+					id, err := remote.FetchID(logger, parseURL("docker:///foo/bar@"+manifestDigest))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(id).To(Equal(layercake.DockerImageID("klm-id")))
+
+					Expect(fakeConn.GetManifestCallCount()).To(Equal(2))
+				})
+			})
+		})
+
+		Context("when the image was fetched by tag", func() {
+			JustBeforeEach(func() {
+				existingLayers["klm-id"] = true
+			})
+
+			It("always asks the registry for the ID", func() {
+				_, err := remote.FetchID(logger, parseURL("docker:///foo/bar#some-tag"))
+				Expect(err).NotTo(HaveOccurred())
+				_, err = remote.FetchID(logger, parseURL("docker:///foo/bar#some-tag"))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeConn.GetManifestCallCount()).To(Equal(2))
+			})
+		})
+	})
+
 	It("returns the size of the image", func() {
 		image, err := remote.Fetch(logger, parseURL("docker:///banana#some-tag"), "", "", 50)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(image.Size).To(BeNumerically("==", 31))
 	})
+
+	It("returns the process defaults from the config of the top layer", func() {
+		manifests["some-tag"].StopSignal = "SIGQUIT"
+		config := manifests["some-tag"].Layers[2].Image.Config
+		config.User = "app:staff"
+		config.WorkingDir = "/home/app"
+		config.Entrypoint = runconfig.NewEntrypoint("/bin/sh", "-c")
+		config.Cmd = runconfig.NewCommand("echo hello")
+		config.ExposedPorts = map[nat.Port]struct{}{"8080/tcp": struct{}{}, "53/udp": struct{}{}}
+
+		image, err := remote.Fetch(logger, parseURL("docker:///banana#some-tag"), "", "", 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(image.User).To(Equal("app:staff"))
+		Expect(image.WorkingDir).To(Equal("/home/app"))
+		Expect(image.Entrypoint).To(Equal([]string{"/bin/sh", "-c"}))
+		Expect(image.Cmd).To(Equal([]string{"echo hello"}))
+		Expect(image.StopSignal).To(Equal("SIGQUIT"))
+		Expect(image.ExposedPorts).To(Equal([]string{"53/udp", "8080/tcp"}))
+	})
 })
 
 func parseURL(u string) *url.URL {
@@ -449,6 +1167,16 @@ func parseURL(u string) *url.URL {
 	return r
 }
 
+func gzipped(contents string) string {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	_, err := w.Write([]byte(contents))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(w.Close()).To(Succeed())
+
+	return buf.String()
+}
+
 type verified struct {
 	io.Reader
 	closed bool
@@ -53,6 +53,15 @@ type Image struct {
 	Env     []string
 	Volumes []string
 	Size    int64
+
+	// the defaults the image config sets for processes
+	User       string
+	WorkingDir string
+	Entrypoint []string
+	Cmd        []string
+	StopSignal string
+	// ExposedPorts are port/protocol pairs, e.g. 8080/tcp
+	ExposedPorts []string
 }
 
 var ErrInvalidDockerURL = errors.New("invalid docker url")
@@ -0,0 +1,198 @@
+package repository_fetcher
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/garden-shed/distclient"
+	"code.cloudfoundry.org/garden-shed/quotaedreader"
+	"code.cloudfoundry.org/lager"
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/client"
+)
+
+type ErrorClass string
+
+const (
+	// ErrorClassTransient errors may go away when the fetch is retried
+	ErrorClassTransient ErrorClass = "transient"
+	// ErrorClassPermanent errors will happen again however often the fetch is
+	// retried
+	ErrorClassPermanent ErrorClass = "permanent"
+)
+
+//go:generate counterfeiter . ErrorClassifier
+type ErrorClassifier interface {
+	Classify(err error) ErrorClass
+}
+
+// RetryPolicy decides how often and how quickly failed fetches are retried.
+// The zero value makes MAX_ATTEMPTS attempts without waiting in between.
+type RetryPolicy struct {
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the second attempt. It doubles with
+	// every further attempt, up to MaxBackoff if that is set.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter varies each delay randomly by up to this fraction of it, so that
+	// many fetches failing at once do not all retry at once
+	Jitter float64
+
+	Clock      clock.Clock
+	Classifier ErrorClassifier
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    MAX_ATTEMPTS,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         0.2,
+		Clock:          clock.NewClock(),
+		Classifier:     DefaultErrorClassifier{},
+	}
+}
+
+// Run calls fn until it succeeds, fails with a permanent error or runs out of
+// attempts, and returns the last error. Every failed attempt is logged as
+// action along with how its error was classified.
+func (p RetryPolicy) Run(log lager.Logger, action string, fn func() error) error {
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = MAX_ATTEMPTS
+	}
+
+	classifier := p.Classifier
+	if classifier == nil {
+		classifier = DefaultErrorClassifier{}
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		class := classifier.Classify(err)
+		log.Error(action, err, lager.Data{
+			"attempt":        attempt,
+			"of":             attempts,
+			"classification": class,
+		})
+
+		if class == ErrorClassPermanent {
+			return err
+		}
+
+		if attempt < attempts {
+			p.wait(attempt)
+		}
+	}
+
+	return err
+}
+
+func (p RetryPolicy) wait(attempt int) {
+	delay := p.Backoff(attempt)
+	if delay <= 0 {
+		return
+	}
+
+	clk := p.Clock
+	if clk == nil {
+		clk = clock.NewClock()
+	}
+
+	clk.Sleep(delay)
+}
+
+// Backoff returns the delay before the attempt following the given one
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		delay += time.Duration(float64(delay) * p.Jitter * (2*randomFraction() - 1))
+	}
+
+	return delay
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func randomFraction() float64 {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	return jitterRand.Float64()
+}
+
+// DefaultErrorClassifier treats errors saying the image does not exist, may
+// not be pulled, is not trusted, does not match its digest or is in an invalid
+// image layout as permanent, and everything
+// else, such as server errors, timeouts and broken connections, as transient.
+type DefaultErrorClassifier struct{}
+
+func (DefaultErrorClassifier) Classify(err error) ErrorClass {
+	err = rootCause(err)
+
+	switch e := err.(type) {
+	case quotaedreader.QuotaExceededErr:
+		return ErrorClassPermanent
+	case *distclient.DigestMismatchError:
+		return ErrorClassPermanent
+	case *distclient.LayoutError:
+		return ErrorClassPermanent
+	case *UntrustedImageError:
+		return ErrorClassPermanent
+	case *ImageNotPresentError:
+		return ErrorClassPermanent
+	case *distclient.StatusError:
+		return classifyStatus(e.StatusCode)
+	case *client.UnexpectedHTTPStatusError:
+		if code, err := strconv.Atoi(strings.SplitN(e.Status, " ", 2)[0]); err == nil {
+			return classifyStatus(code)
+		}
+	case errcode.Error:
+		return classifyStatus(e.Code.Descriptor().HTTPStatusCode)
+	case errcode.ErrorCode:
+		return classifyStatus(e.Descriptor().HTTPStatusCode)
+	case errcode.Errors:
+		if len(e) > 0 {
+			return DefaultErrorClassifier{}.Classify(e[0])
+		}
+	}
+
+	if err == ErrDigestMismatch || err == ErrDiffIDMismatch || err == distribution.ErrBlobUnknown {
+		return ErrorClassPermanent
+	}
+
+	return ErrorClassTransient
+}
+
+func classifyStatus(code int) ErrorClass {
+	switch {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return ErrorClassTransient
+	case code >= 400 && code < 500:
+		return ErrorClassPermanent
+	default:
+		return ErrorClassTransient
+	}
+}
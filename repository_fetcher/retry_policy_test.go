@@ -0,0 +1,78 @@
+package repository_fetcher_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"code.cloudfoundry.org/garden-shed/distclient"
+	"code.cloudfoundry.org/garden-shed/quotaedreader"
+	"code.cloudfoundry.org/garden-shed/repository_fetcher"
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	Describe("Backoff", func() {
+		var policy repository_fetcher.RetryPolicy
+
+		BeforeEach(func() {
+			policy = repository_fetcher.RetryPolicy{
+				InitialBackoff: time.Second,
+				MaxBackoff:     5 * time.Second,
+			}
+		})
+
+		It("doubles the delay after every attempt", func() {
+			Expect(policy.Backoff(1)).To(Equal(time.Second))
+			Expect(policy.Backoff(2)).To(Equal(2 * time.Second))
+			Expect(policy.Backoff(3)).To(Equal(4 * time.Second))
+		})
+
+		It("does not exceed the maximum backoff", func() {
+			Expect(policy.Backoff(4)).To(Equal(5 * time.Second))
+			Expect(policy.Backoff(100)).To(Equal(5 * time.Second))
+		})
+
+		Context("when there is jitter", func() {
+			BeforeEach(func() {
+				policy.Jitter = 0.5
+			})
+
+			It("varies the delay by up to the jitter fraction", func() {
+				for i := 0; i < 100; i++ {
+					Expect(policy.Backoff(2)).To(BeNumerically("~", 2*time.Second, time.Second))
+				}
+			})
+		})
+	})
+})
+
+var _ = Describe("DefaultErrorClassifier", func() {
+	itClassifies := func(description string, err error, class repository_fetcher.ErrorClass) {
+		It("classifies "+description+" as "+string(class), func() {
+			Expect(repository_fetcher.DefaultErrorClassifier{}.Classify(err)).To(Equal(class))
+		})
+	}
+
+	itClassifies("unauthorized responses", &distclient.StatusError{StatusCode: 401}, repository_fetcher.ErrorClassPermanent)
+	itClassifies("forbidden responses", &distclient.StatusError{StatusCode: 403}, repository_fetcher.ErrorClassPermanent)
+	itClassifies("not found responses", &distclient.StatusError{StatusCode: 404}, repository_fetcher.ErrorClassPermanent)
+	itClassifies("too many requests responses", &distclient.StatusError{StatusCode: 429}, repository_fetcher.ErrorClassTransient)
+	itClassifies("server errors", &distclient.StatusError{StatusCode: 503}, repository_fetcher.ErrorClassTransient)
+	itClassifies("unexpected statuses from the registry client", &client.UnexpectedHTTPStatusError{Status: "502 Bad Gateway"}, repository_fetcher.ErrorClassTransient)
+	itClassifies("registry error codes", v2.ErrorCodeUnauthorized.WithDetail(nil), repository_fetcher.ErrorClassPermanent)
+	itClassifies("unknown blobs", distribution.ErrBlobUnknown, repository_fetcher.ErrorClassPermanent)
+	itClassifies("layer digest mismatches", repository_fetcher.ErrDigestMismatch, repository_fetcher.ErrorClassPermanent)
+	itClassifies("layer diff ID mismatches", repository_fetcher.ErrDiffIDMismatch, repository_fetcher.ErrorClassPermanent)
+	itClassifies("manifest digest mismatches", &distclient.DigestMismatchError{Content: "manifest"}, repository_fetcher.ErrorClassPermanent)
+	itClassifies("invalid image layouts", &distclient.LayoutError{Path: "/some/layout", Reason: "no manifest named latest"}, repository_fetcher.ErrorClassPermanent)
+	itClassifies("exceeded quotas", quotaedreader.NewQuotaExceededErr(), repository_fetcher.ErrorClassPermanent)
+	itClassifies("broken connections", io.ErrUnexpectedEOF, repository_fetcher.ErrorClassTransient)
+	itClassifies("timeouts", &net.OpError{Op: "dial", Err: errors.New("i/o timeout")}, repository_fetcher.ErrorClassTransient)
+	itClassifies("other errors", errors.New("boom"), repository_fetcher.ErrorClassTransient)
+})
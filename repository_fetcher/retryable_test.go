@@ -3,7 +3,9 @@ package repository_fetcher_test
 import (
 	"errors"
 	"net/url"
+	"time"
 
+	"code.cloudfoundry.org/clock/fakeclock"
 	"code.cloudfoundry.org/garden-shed/layercake"
 	"code.cloudfoundry.org/garden-shed/quotaedreader"
 	"code.cloudfoundry.org/garden-shed/repository_fetcher"
@@ -98,6 +100,86 @@ var _ = Describe("Retryable", func() {
 		})
 	})
 
+	Describe("retry policy", func() {
+		var fakeClassifier *fakes.FakeErrorClassifier
+
+		BeforeEach(func() {
+			fakeClassifier = new(fakes.FakeErrorClassifier)
+			fakeClassifier.ClassifyReturns(repository_fetcher.ErrorClassTransient)
+
+			retryable.Policy = repository_fetcher.RetryPolicy{
+				MaxAttempts: 5,
+				Classifier:  fakeClassifier,
+			}
+
+			fakeRemoteFetcher.FetchReturns(nil, errors.New("error-talking-to-remote-repo"))
+		})
+
+		It("makes the configured number of attempts", func() {
+			_, err := retryable.Fetch(logger, repoURL, "", "", 0)
+			Expect(err).To(MatchError("error-talking-to-remote-repo"))
+
+			Expect(fakeRemoteFetcher.FetchCallCount()).To(Equal(5))
+		})
+
+		It("logs the classification of each failure", func() {
+			retryable.Fetch(logger, repoURL, "", "", 0)
+
+			Expect(logger.Logs()).To(HaveLen(5))
+			for _, log := range logger.Logs() {
+				Expect(log.Data).To(HaveKeyWithValue("classification", BeEquivalentTo("transient")))
+			}
+		})
+
+		Context("when the error is permanent", func() {
+			BeforeEach(func() {
+				fakeClassifier.ClassifyReturns(repository_fetcher.ErrorClassPermanent)
+			})
+
+			It("does not retry", func() {
+				_, err := retryable.Fetch(logger, repoURL, "", "", 0)
+				Expect(err).To(MatchError("error-talking-to-remote-repo"))
+
+				Expect(fakeRemoteFetcher.FetchCallCount()).To(Equal(1))
+				Expect(fakeClassifier.ClassifyArgsForCall(0)).To(MatchError("error-talking-to-remote-repo"))
+			})
+		})
+
+		Context("when there is a backoff", func() {
+			var fakeClock *fakeclock.FakeClock
+
+			BeforeEach(func() {
+				fakeClock = fakeclock.NewFakeClock(time.Now())
+
+				retryable.Policy.MaxAttempts = 3
+				retryable.Policy.InitialBackoff = time.Second
+				retryable.Policy.Clock = fakeClock
+			})
+
+			It("waits longer and longer between attempts", func() {
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					defer close(done)
+
+					retryable.Fetch(logger, repoURL, "", "", 0)
+				}()
+
+				Eventually(fakeRemoteFetcher.FetchCallCount).Should(Equal(1))
+
+				fakeClock.WaitForWatcherAndIncrement(time.Second)
+				Eventually(fakeRemoteFetcher.FetchCallCount).Should(Equal(2))
+
+				fakeClock.WaitForWatcherAndIncrement(time.Second)
+				Consistently(fakeRemoteFetcher.FetchCallCount).Should(Equal(2))
+
+				fakeClock.Increment(time.Second)
+				Eventually(fakeRemoteFetcher.FetchCallCount).Should(Equal(3))
+				Eventually(done).Should(BeClosed())
+			})
+		})
+	})
+
 	Describe("FetchID failures", func() {
 		Context("when fetching IDs fails twice", func() {
 			BeforeEach(func() {
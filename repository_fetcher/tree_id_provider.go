@@ -0,0 +1,128 @@
+package repository_fetcher
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/lager"
+)
+
+// TreeIDProvider identifies a local rootfs directory by a Merkle hash of its
+// tree: every directory is hashed over the names, modes, ownership and
+// contents of its entries, where the content of a subdirectory is its own
+// hash. Unlike LayerIDProvider it notices changes anywhere in the tree, and
+// identical trees share an ID wherever they live. Digests remembers the
+// hashes of files between calls, so that only changed files are read again.
+type TreeIDProvider struct {
+	Digests *FileDigests
+	Logger  lager.Logger
+}
+
+func NewTreeIDProvider(logger lager.Logger, digests *FileDigests) *TreeIDProvider {
+	return &TreeIDProvider{
+		Digests: digests,
+		Logger:  logger,
+	}
+}
+
+func (p *TreeIDProvider) ProvideID(path string) layercake.ID {
+	path, err := resolve(path)
+	if err != nil {
+		return layercake.LocalImageID{
+			Path:         path,
+			ModifiedTime: time.Time{},
+		}
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return layercake.LocalImageID{
+			Path:         path,
+			ModifiedTime: time.Time{},
+		}
+	}
+
+	defer p.saveDigests()
+
+	if !info.IsDir() {
+		return archiveID(p.Digests, path, info)
+	}
+
+	digest, err := p.treeDigest(path)
+	if err != nil {
+		p.Logger.Error("failed-to-hash-rootfs", err, lager.Data{"path": path})
+		return layercake.LocalImageID{
+			Path:         path,
+			ModifiedTime: info.ModTime(),
+		}
+	}
+
+	return layercake.LocalTreeID{
+		Path:   path,
+		Digest: digest,
+	}
+}
+
+func (p *TreeIDProvider) treeDigest(dir string) (string, error) {
+	// ReadDir lstats the entries and sorts them by name
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, info := range infos {
+		content, err := p.entryDigest(filepath.Join(dir, info.Name()), info)
+		if err != nil {
+			return "", err
+		}
+
+		uid, gid := owner(info)
+		fmt.Fprintf(hash, "%q %o %d:%d %s\n", info.Name(), uint32(info.Mode()), uid, gid, content)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// entryDigest describes the content of a directory entry
+func (p *TreeIDProvider) entryDigest(path string, info os.FileInfo) (string, error) {
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		return p.treeDigest(path)
+	case mode.IsRegular():
+		return p.Digests.digest(path, info)
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%q", target), nil
+	case mode&os.ModeDevice != 0:
+		if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+			return fmt.Sprintf("%d", sys.Rdev), nil
+		}
+	}
+
+	return "", nil
+}
+
+func (p *TreeIDProvider) saveDigests() {
+	if err := p.Digests.Save(); err != nil {
+		p.Logger.Error("failed-to-save-file-digests", err)
+	}
+}
+
+func owner(info os.FileInfo) (uint32, uint32) {
+	if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+		return sys.Uid, sys.Gid
+	}
+
+	return 0, 0
+}
@@ -0,0 +1,134 @@
+package repository_fetcher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/repository_fetcher"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TreeIDProvider", func() {
+	var (
+		tmpDir       string
+		rootfs       string
+		otherRootfs  string
+		digestsPath  string
+		idp          *repository_fetcher.TreeIDProvider
+		modifiedTime time.Time
+	)
+
+	writeTree := func(dir string) {
+		Expect(os.MkdirAll(filepath.Join(dir, "etc", "deep"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "etc", "deep", "file"), []byte("deep"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "top"), []byte("top"), 0644)).To(Succeed())
+		Expect(os.Symlink("top", filepath.Join(dir, "link"))).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "tree-id")
+		Expect(err).NotTo(HaveOccurred())
+
+		rootfs = filepath.Join(tmpDir, "rootfs")
+		otherRootfs = filepath.Join(tmpDir, "other-rootfs")
+		writeTree(rootfs)
+		writeTree(otherRootfs)
+
+		modifiedTime = time.Date(1966, time.February, 8, 3, 43, 2, 0, time.UTC)
+
+		digestsPath = filepath.Join(tmpDir, "file-digests.json")
+		digests, err := repository_fetcher.LoadFileDigests(digestsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		idp = repository_fetcher.NewTreeIDProvider(lagertest.NewTestLogger("test"), digests)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("returns a content-addressed ID", func() {
+		Expect(idp.ProvideID(rootfs)).To(BeAssignableToTypeOf(layercake.LocalTreeID{}))
+	})
+
+	It("returns the same ID for identical trees", func() {
+		Expect(idp.ProvideID(rootfs).GraphID()).To(Equal(idp.ProvideID(otherRootfs).GraphID()))
+	})
+
+	It("returns the same ID if only modification times change", func() {
+		before := idp.ProvideID(rootfs).GraphID()
+		Expect(os.Chtimes(filepath.Join(rootfs, "etc", "deep", "file"), modifiedTime, modifiedTime)).To(Succeed())
+		Expect(os.Chtimes(rootfs, modifiedTime, modifiedTime)).To(Succeed())
+		Expect(idp.ProvideID(rootfs).GraphID()).To(Equal(before))
+	})
+
+	It("returns a different ID if a file deep in the tree changes", func() {
+		before := idp.ProvideID(rootfs).GraphID()
+		Expect(ioutil.WriteFile(filepath.Join(rootfs, "etc", "deep", "file"), []byte("changed"), 0644)).To(Succeed())
+		Expect(idp.ProvideID(rootfs).GraphID()).NotTo(Equal(before))
+	})
+
+	It("returns a different ID if a file is renamed", func() {
+		before := idp.ProvideID(rootfs).GraphID()
+		Expect(os.Rename(filepath.Join(rootfs, "top"), filepath.Join(rootfs, "renamed"))).To(Succeed())
+		Expect(idp.ProvideID(rootfs).GraphID()).NotTo(Equal(before))
+	})
+
+	It("returns a different ID if the mode of a file changes", func() {
+		before := idp.ProvideID(rootfs).GraphID()
+		Expect(os.Chmod(filepath.Join(rootfs, "etc", "deep", "file"), 0755)).To(Succeed())
+		Expect(idp.ProvideID(rootfs).GraphID()).NotTo(Equal(before))
+	})
+
+	It("returns a different ID if a symlink points elsewhere", func() {
+		before := idp.ProvideID(rootfs).GraphID()
+		Expect(os.Remove(filepath.Join(rootfs, "link"))).To(Succeed())
+		Expect(os.Symlink("etc", filepath.Join(rootfs, "link"))).To(Succeed())
+		Expect(idp.ProvideID(rootfs).GraphID()).NotTo(Equal(before))
+	})
+
+	It("saves the digests of the files it hashed", func() {
+		file := filepath.Join(rootfs, "top")
+		Expect(os.Chtimes(file, modifiedTime, modifiedTime)).To(Succeed())
+
+		before := idp.ProvideID(rootfs).GraphID()
+
+		By("changing the file without changing its size or modification time")
+		Expect(ioutil.WriteFile(file, []byte("TOP"), 0644)).To(Succeed())
+		Expect(os.Chtimes(file, modifiedTime, modifiedTime)).To(Succeed())
+
+		digests, err := repository_fetcher.LoadFileDigests(digestsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		restarted := repository_fetcher.NewTreeIDProvider(lagertest.NewTestLogger("test"), digests)
+		Expect(restarted.ProvideID(rootfs).GraphID()).To(Equal(before))
+	})
+
+	Context("when the path is a symlink", func() {
+		It("returns the ID of the symlinked directory", func() {
+			symlink := filepath.Join(tmpDir, "symlink")
+			Expect(os.Symlink(rootfs, symlink)).To(Succeed())
+			Expect(idp.ProvideID(symlink)).To(Equal(idp.ProvideID(rootfs)))
+		})
+	})
+
+	Context("when the path is a tarball", func() {
+		It("returns the ID of its content", func() {
+			tarball := filepath.Join(tmpDir, "rootfs.tar")
+			Expect(ioutil.WriteFile(tarball, []byte("tarball"), 0644)).To(Succeed())
+			Expect(idp.ProvideID(tarball)).To(BeAssignableToTypeOf(layercake.LocalArchiveID{}))
+		})
+	})
+
+	Context("when the path does not exist", func() {
+		It("falls back to an ID of the path", func() {
+			Expect(idp.ProvideID(filepath.Join(tmpDir, "missing"))).To(BeAssignableToTypeOf(layercake.LocalImageID{}))
+		})
+	})
+})
@@ -0,0 +1,250 @@
+package repository_fetcher
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/docker/distribution/digest"
+)
+
+// TrustPolicy decides whether the image with the given manifest digest may be
+// pulled from a repository. It is consulted before any layer of the image is
+// registered.
+//
+//go:generate counterfeiter . TrustPolicy
+type TrustPolicy interface {
+	Verify(log lager.Logger, host, repo string, manifestDigest digest.Digest) error
+}
+
+type UntrustedImageError struct {
+	Image  string
+	Reason string
+}
+
+func (e *UntrustedImageError) Error() string {
+	return fmt.Sprintf("untrusted image %s: %s", e.Image, e.Reason)
+}
+
+type RegistryPolicyType string
+
+const (
+	PolicyAccept RegistryPolicyType = "accept"
+	PolicyReject RegistryPolicyType = "reject"
+	PolicySigned RegistryPolicyType = "signed"
+)
+
+// RegistryPolicy says which images of a registry are trusted: all of them,
+// none of them, or those signed by one of Keys
+type RegistryPolicy struct {
+	Type RegistryPolicyType `json:"type"`
+	Keys []string           `json:"keys,omitempty"`
+}
+
+// SignaturePolicy is a TrustPolicy configured per registry host. Signatures
+// are detached and stored locally, in SignaturesDir/<algorithm>-<hex>/ for
+// the manifest digest <algorithm>:<hex>. Every file there holds one SHA-256
+// RSA PKCS#1 v1.5 or ECDSA signature, raw or base64 encoded, of the manifest
+// digest string, as made by e.g.
+//
+//	printf sha256:... | openssl dgst -sha256 -sign key.pem
+type SignaturePolicy struct {
+	Default    RegistryPolicy
+	Registries map[string]RegistryPolicy
+
+	Keys          map[string]crypto.PublicKey
+	SignaturesDir string
+}
+
+type policyFile struct {
+	Default    RegistryPolicy            `json:"default"`
+	Registries map[string]RegistryPolicy `json:"registries"`
+}
+
+// LoadSignaturePolicy loads a SignaturePolicy from dir, which holds the
+// policy in policy.json, the PEM encoded public keys it refers to in
+// keys/<name>.pem and the signatures in signatures/
+//
+//	{
+//	  "default": {"type": "accept"},
+//	  "registries": {
+//	    "registry.example.com": {"type": "signed", "keys": ["release"]},
+//	    "untrusted.example.com": {"type": "reject"}
+//	  }
+//	}
+func LoadSignaturePolicy(dir string) (*SignaturePolicy, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "policy.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var file policyFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("parse trust policy: %s", err)
+	}
+
+	policy := &SignaturePolicy{
+		Default:       file.Default,
+		Registries:    file.Registries,
+		Keys:          make(map[string]crypto.PublicKey),
+		SignaturesDir: filepath.Join(dir, "signatures"),
+	}
+
+	registries := map[string]RegistryPolicy{"default": file.Default}
+	for host, p := range file.Registries {
+		registries[host] = p
+	}
+
+	for host, p := range registries {
+		switch p.Type {
+		case "", PolicyAccept, PolicyReject:
+		case PolicySigned:
+			if len(p.Keys) == 0 {
+				return nil, fmt.Errorf("trust policy for %s requires signatures but lists no keys", host)
+			}
+		default:
+			return nil, fmt.Errorf("trust policy for %s has unknown type %q", host, p.Type)
+		}
+
+		for _, name := range p.Keys {
+			if _, ok := policy.Keys[name]; ok {
+				continue
+			}
+
+			key, err := loadPublicKey(filepath.Join(dir, "keys", name+".pem"))
+			if err != nil {
+				return nil, fmt.Errorf("load key %s: %s", name, err)
+			}
+
+			policy.Keys[name] = key
+		}
+	}
+
+	return policy, nil
+}
+
+func (p *SignaturePolicy) Verify(log lager.Logger, host, repo string, manifestDigest digest.Digest) error {
+	log = log.Session("verify-trust", lager.Data{"host": host, "repo": repo, "digest": manifestDigest})
+
+	image := fmt.Sprintf("%s/%s@%s", host, repo, manifestDigest)
+
+	policy, ok := p.Registries[host]
+	if !ok {
+		policy = p.Default
+	}
+
+	switch policy.Type {
+	case "", PolicyAccept:
+		return nil
+	case PolicyReject:
+		return &UntrustedImageError{Image: image, Reason: "images from " + host + " are rejected by policy"}
+	case PolicySigned:
+		if manifestDigest == "" {
+			return &UntrustedImageError{Image: image, Reason: "manifest digest unknown"}
+		}
+
+		key, err := p.signingKey(manifestDigest, policy.Keys)
+		if err != nil {
+			return err
+		}
+
+		if key == "" {
+			return &UntrustedImageError{Image: image, Reason: fmt.Sprintf("not signed by any of %s", strings.Join(policy.Keys, ", "))}
+		}
+
+		log.Info("signed", lager.Data{"key": key})
+		return nil
+	default:
+		return &UntrustedImageError{Image: image, Reason: fmt.Sprintf("unknown policy type %q", policy.Type)}
+	}
+}
+
+// signingKey returns the name of the first of keys that signed d, if any
+func (p *SignaturePolicy) signingKey(d digest.Digest, keys []string) (string, error) {
+	sigDir := filepath.Join(p.SignaturesDir, string(d.Algorithm())+"-"+d.Hex())
+
+	files, err := ioutil.ReadDir(sigDir)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256([]byte(d.String()))
+
+	for _, f := range files {
+		sig, err := ioutil.ReadFile(filepath.Join(sigDir, f.Name()))
+		if err != nil {
+			return "", err
+		}
+
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+			sig = decoded
+		}
+
+		for _, name := range keys {
+			if verifySignature(p.Keys[name], hash[:], sig) {
+				return name, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func verifySignature(key crypto.PublicKey, hash, sig []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash, sig) == nil
+	case *ecdsa.PublicKey:
+		var ecdsaSig struct {
+			R, S *big.Int
+		}
+
+		if _, err := asn1.Unmarshal(sig, &ecdsaSig); err != nil {
+			return false
+		}
+
+		return ecdsa.Verify(k, hash, ecdsaSig.R, ecdsaSig.S)
+	default:
+		return false
+	}
+}
+
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T in %s", key, path)
+	}
+}
@@ -0,0 +1,160 @@
+package repository_fetcher_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/garden-shed/repository_fetcher"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/docker/distribution/digest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SignaturePolicy", func() {
+	const manifestDigest = digest.Digest("sha256:0123456789012345678901234567890123456789012345678901234567890123")
+
+	var (
+		logger     *lagertest.TestLogger
+		policyDir  string
+		releaseKey *ecdsa.PrivateKey
+		otherKey   *rsa.PrivateKey
+		policy     *repository_fetcher.SignaturePolicy
+	)
+
+	writeFile := func(path string, contents []byte) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(policyDir, path)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(policyDir, path), contents, 0644)).To(Succeed())
+	}
+
+	writePublicKey := func(name string, key crypto.PublicKey) {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		Expect(err).NotTo(HaveOccurred())
+
+		writeFile(filepath.Join("keys", name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+
+	sign := func(key crypto.Signer, d digest.Digest) []byte {
+		hash := sha256.Sum256([]byte(d.String()))
+
+		sig, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
+		Expect(err).NotTo(HaveOccurred())
+		return sig
+	}
+
+	signatureFile := func(name string) string {
+		return filepath.Join("signatures", "sha256-"+manifestDigest.Hex(), name)
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+
+		var err error
+		policyDir, err = ioutil.TempDir("", "trust")
+		Expect(err).NotTo(HaveOccurred())
+
+		releaseKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+
+		otherKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+
+		writePublicKey("release", &releaseKey.PublicKey)
+		writePublicKey("other", &otherKey.PublicKey)
+
+		writeFile("policy.json", []byte(`{
+			"default": {"type": "accept"},
+			"registries": {
+				"signed.example.com": {"type": "signed", "keys": ["release"]},
+				"other.example.com": {"type": "signed", "keys": ["other"]},
+				"rejected.example.com": {"type": "reject"}
+			}
+		}`))
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		policy, err = repository_fetcher.LoadSignaturePolicy(policyDir)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(policyDir)).To(Succeed())
+	})
+
+	It("uses the default policy for registries that are not listed", func() {
+		Expect(policy.Verify(logger, "some.example.com", "some/repo", manifestDigest)).To(Succeed())
+	})
+
+	It("rejects every image of rejected registries", func() {
+		err := policy.Verify(logger, "rejected.example.com", "some/repo", manifestDigest)
+		Expect(err).To(BeAssignableToTypeOf(&repository_fetcher.UntrustedImageError{}))
+		Expect(err).To(MatchError(ContainSubstring("rejected by policy")))
+	})
+
+	Context("when a registry requires signatures", func() {
+		It("rejects images without signatures", func() {
+			err := policy.Verify(logger, "signed.example.com", "some/repo", manifestDigest)
+			Expect(err).To(MatchError(ContainSubstring("not signed by any of release")))
+		})
+
+		It("accepts images signed by a trusted key", func() {
+			writeFile(signatureFile("release.sig"), sign(releaseKey, manifestDigest))
+
+			Expect(policy.Verify(logger, "signed.example.com", "some/repo", manifestDigest)).To(Succeed())
+		})
+
+		It("accepts base64 encoded signatures", func() {
+			writeFile(signatureFile("other.sig"), []byte(base64.StdEncoding.EncodeToString(sign(otherKey, manifestDigest))+"\n"))
+
+			Expect(policy.Verify(logger, "other.example.com", "some/repo", manifestDigest)).To(Succeed())
+		})
+
+		It("rejects images signed only by keys the registry does not trust", func() {
+			writeFile(signatureFile("other.sig"), sign(otherKey, manifestDigest))
+
+			err := policy.Verify(logger, "signed.example.com", "some/repo", manifestDigest)
+			Expect(err).To(MatchError(ContainSubstring("not signed by any of release")))
+		})
+
+		It("rejects signatures of a different digest", func() {
+			writeFile(signatureFile("release.sig"), sign(releaseKey, "sha256:4567"))
+
+			err := policy.Verify(logger, "signed.example.com", "some/repo", manifestDigest)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("loading the policy", func() {
+		It("fails when a key is missing", func() {
+			writeFile("policy.json", []byte(`{"default": {"type": "signed", "keys": ["missing"]}}`))
+
+			_, err := repository_fetcher.LoadSignaturePolicy(policyDir)
+			Expect(err).To(MatchError(ContainSubstring("load key missing")))
+		})
+
+		It("fails when a signed policy lists no keys", func() {
+			writeFile("policy.json", []byte(`{"registries": {"signed.example.com": {"type": "signed"}}}`))
+
+			_, err := repository_fetcher.LoadSignaturePolicy(policyDir)
+			Expect(err).To(MatchError(ContainSubstring("lists no keys")))
+		})
+
+		It("fails when a policy type is unknown", func() {
+			writeFile("policy.json", []byte(`{"default": {"type": "maybe"}}`))
+
+			_, err := repository_fetcher.LoadSignaturePolicy(policyDir)
+			Expect(err).To(MatchError(ContainSubstring(`unknown type "maybe"`)))
+		})
+	})
+})
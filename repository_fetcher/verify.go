@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/archive"
 )
 
 //go:generate counterfeiter . Verifier
@@ -16,17 +17,36 @@ type Verifier interface {
 
 var DefaultVerifier = VerifyFunc(Verify)
 
+var ErrDigestMismatch = errors.New("digest verification failed")
+
+var ErrDiffIDMismatch = errors.New("diff id verification failed")
+
 // Verify reads the given reader in to a temporary file and validates that
 // it matches the digest. If it does, it returns a reader for that allows access
 // to the data. Otherwise, it returns an error.
 // The caller is responsible for closing the returned reader, in order to
 // ensure the temporary file is deleted.
+// A reader which can seek and be closed, such as a file, is verified in place
+// instead, and returned itself once it has been rewound. A reader that already
+// knows its digest, such as a PartialBlob, is not read at all.
 func Verify(r io.Reader, d digest.Digest) (io.ReadCloser, int64, error) {
+	if dr, ok := r.(digestedReader); ok && dr.Digest() != "" {
+		if dr.Digest() != d {
+			return nil, 0, ErrDigestMismatch
+		}
+
+		return dr, dr.Size(), nil
+	}
+
 	w, err := digest.NewDigestVerifier(d)
 	if err != nil {
 		return nil, 0, err
 	}
 
+	if rsc, ok := r.(readSeekCloser); ok {
+		return verifyInPlace(rsc, w)
+	}
+
 	tmp, err := ioutil.TempFile("", "unverified-layer")
 	if err != nil {
 		return nil, 0, err
@@ -38,7 +58,7 @@ func Verify(r io.Reader, d digest.Digest) (io.ReadCloser, int64, error) {
 	}
 
 	if !w.Verified() {
-		return nil, 0, errors.New("digest verification failed")
+		return nil, 0, ErrDigestMismatch
 	}
 
 	_, err = tmp.Seek(0, 0)
@@ -49,6 +69,60 @@ func Verify(r io.Reader, d digest.Digest) (io.ReadCloser, int64, error) {
 	return &deleteCloser{tmp}, n, nil
 }
 
+// VerifyDiffID checks that the layer r, compressed or not, is the tar stream
+// with the given diff ID
+func VerifyDiffID(r io.Reader, diffID digest.Digest) error {
+	w, err := digest.NewDigestVerifier(diffID)
+	if err != nil {
+		return err
+	}
+
+	tar, err := archive.DecompressStream(r)
+	if err != nil {
+		return err
+	}
+	defer tar.Close()
+
+	if _, err := io.Copy(w, tar); err != nil {
+		return err
+	}
+
+	if !w.Verified() {
+		return ErrDiffIDMismatch
+	}
+
+	return nil
+}
+
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// digestedReader is a reader whose digest was worked out as it was written
+type digestedReader interface {
+	io.ReadCloser
+	Digest() digest.Digest
+	Size() int64
+}
+
+func verifyInPlace(r readSeekCloser, w digest.Verifier) (io.ReadCloser, int64, error) {
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !w.Verified() {
+		return nil, 0, ErrDigestMismatch
+	}
+
+	if _, err := r.Seek(0, 0); err != nil {
+		return nil, 0, err
+	}
+
+	return r, n, nil
+}
+
 type deleteCloser struct {
 	*os.File
 }
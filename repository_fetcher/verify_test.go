@@ -5,6 +5,8 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
 
 	"code.cloudfoundry.org/garden-shed/repository_fetcher"
 	"github.com/docker/distribution/digest"
@@ -37,4 +39,84 @@ var _ = Describe("Verifying a digest", func() {
 			Expect(err).To(MatchError("digest verification failed"))
 		})
 	})
+	Context("when the data is in a file", func() {
+		var file *os.File
+
+		BeforeEach(func() {
+			var err error
+			file, err = ioutil.TempFile("", "verify")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = file.Write([]byte("matches"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = file.Seek(0, 0)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			file.Close()
+			Expect(os.Remove(file.Name())).To(Succeed())
+		})
+
+		It("verifies it in place, returning the file rewound", func() {
+			r, size, err := repository_fetcher.Verify(file, shaThatDoesMatch)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(r).To(BeIdenticalTo(file))
+			Expect(size).To(BeEquivalentTo(7))
+			Expect(ioutil.ReadAll(r)).To(Equal([]byte("matches")))
+		})
+
+		It("returns an error when the digest does not match", func() {
+			_, _, err := repository_fetcher.Verify(file, someShaThatDoesntMatch)
+			Expect(err).To(MatchError("digest verification failed"))
+		})
+	})
+
+	Context("when the digest of the data was worked out as it was written", func() {
+		It("goes by that digest rather than reading the data again", func() {
+			r := &digested{Reader: bytes.NewReader([]byte("not read")), digest: shaThatDoesMatch, size: 7}
+
+			verified, size, err := repository_fetcher.Verify(r, shaThatDoesMatch)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(verified).To(BeIdenticalTo(r))
+			Expect(size).To(BeEquivalentTo(7))
+			Expect(r.Len()).To(Equal(len("not read")))
+		})
+
+		It("returns an error when that digest does not match", func() {
+			r := &digested{Reader: bytes.NewReader([]byte("matches")), digest: someShaThatDoesntMatch, size: 7}
+
+			_, _, err := repository_fetcher.Verify(r, shaThatDoesMatch)
+			Expect(err).To(MatchError("digest verification failed"))
+		})
+	})
+})
+
+var _ = Describe("Verifying a diff ID", func() {
+	const contents = "the uncompressed contents of the layer"
+
+	It("accepts a compressed layer that decompresses to the diff ID", func() {
+		Expect(repository_fetcher.VerifyDiffID(strings.NewReader(gzipped(contents)), digestOf(contents))).To(Succeed())
+	})
+
+	It("accepts an uncompressed layer with the diff ID", func() {
+		Expect(repository_fetcher.VerifyDiffID(strings.NewReader(contents), digestOf(contents))).To(Succeed())
+	})
+
+	It("returns an error when the layer does not have the diff ID", func() {
+		err := repository_fetcher.VerifyDiffID(strings.NewReader(gzipped(contents)), digestOf("something else entirely"))
+		Expect(err).To(Equal(repository_fetcher.ErrDiffIDMismatch))
+	})
 })
+
+type digested struct {
+	*bytes.Reader
+	digest digest.Digest
+	size   int64
+}
+
+func (d *digested) Close() error          { return nil }
+func (d *digested) Digest() digest.Digest { return d.digest }
+func (d *digested) Size() int64           { return d.size }
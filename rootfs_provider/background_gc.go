@@ -0,0 +1,77 @@
+package rootfs_provider
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
+	"code.cloudfoundry.org/lager"
+)
+
+const DefaultPressureCheckInterval = 10 * time.Second
+
+//go:generate counterfeiter . Sweeper
+type Sweeper interface {
+	Sweep(logger lager.Logger) error
+}
+
+// BackgroundGC sweeps the graph every Interval. If Pressure is set it is also
+// checked every PressureCheckInterval, and the graph is swept as soon as it
+// is exceeded rather than at the next interval.
+type BackgroundGC struct {
+	Sweeper               Sweeper
+	Interval              time.Duration
+	Pressure              cleaner.Threshold
+	PressureCheckInterval time.Duration
+	Cake                  layercake.Cake
+	Clock                 clock.Clock
+	Logger                lager.Logger
+}
+
+func NewBackgroundGC(logger lager.Logger, sweeper Sweeper, interval time.Duration) *BackgroundGC {
+	return &BackgroundGC{
+		Sweeper:               sweeper,
+		Interval:              interval,
+		PressureCheckInterval: DefaultPressureCheckInterval,
+		Clock:                 clock.NewClock(),
+		Logger:                logger,
+	}
+}
+
+// Run sweeps until stop is closed. A nil stop runs forever.
+func (b *BackgroundGC) Run(stop <-chan struct{}) {
+	log := b.Logger.Session("background-gc", lager.Data{"interval": b.Interval.String()})
+	log.Info("start")
+	defer log.Info("finished")
+
+	interval := b.Clock.NewTicker(b.Interval)
+	defer interval.Stop()
+
+	var pressure <-chan time.Time
+	if b.Pressure != nil {
+		pressureCheck := b.Clock.NewTicker(b.PressureCheckInterval)
+		defer pressureCheck.Stop()
+
+		pressure = pressureCheck.C()
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-interval.C():
+			b.sweep(log, "interval")
+		case <-pressure:
+			if b.Pressure.Exceeded(log, b.Cake) {
+				b.sweep(log, "disk-pressure")
+			}
+		}
+	}
+}
+
+func (b *BackgroundGC) sweep(log lager.Logger, trigger string) {
+	if err := b.Sweeper.Sweep(log); err != nil {
+		log.Error("sweep-failed", err, lager.Data{"trigger": trigger})
+	}
+}
@@ -0,0 +1,106 @@
+package rootfs_provider_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	fakes "code.cloudfoundry.org/garden-shed/layercake/cleaner/cleanerfakes"
+	"code.cloudfoundry.org/garden-shed/layercake/fake_cake"
+	"code.cloudfoundry.org/garden-shed/rootfs_provider"
+	rootfsfakes "code.cloudfoundry.org/garden-shed/rootfs_provider/rootfs_providerfakes"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("BackgroundGC", func() {
+	var (
+		fakeSweeper  *rootfsfakes.FakeSweeper
+		fakeClock    *fakeclock.FakeClock
+		logger       *lagertest.TestLogger
+		backgroundGC *rootfs_provider.BackgroundGC
+		stop         chan struct{}
+		done         chan struct{}
+	)
+
+	BeforeEach(func() {
+		fakeSweeper = new(rootfsfakes.FakeSweeper)
+		fakeClock = fakeclock.NewFakeClock(time.Unix(1000, 0))
+		logger = lagertest.NewTestLogger("test")
+
+		backgroundGC = rootfs_provider.NewBackgroundGC(logger, fakeSweeper, time.Hour)
+		backgroundGC.Clock = fakeClock
+	})
+
+	JustBeforeEach(func() {
+		stop = make(chan struct{})
+		done = make(chan struct{})
+
+		go func() {
+			backgroundGC.Run(stop)
+			close(done)
+		}()
+	})
+
+	AfterEach(func() {
+		close(stop)
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("sweeps every interval", func() {
+		Consistently(fakeSweeper.SweepCallCount).Should(Equal(0))
+
+		fakeClock.WaitForWatcherAndIncrement(time.Hour)
+		Eventually(fakeSweeper.SweepCallCount).Should(Equal(1))
+
+		fakeClock.Increment(time.Hour)
+		Eventually(fakeSweeper.SweepCallCount).Should(Equal(2))
+	})
+
+	Context("when sweeping fails", func() {
+		BeforeEach(func() {
+			fakeSweeper.SweepReturns(errors.New("potato"))
+		})
+
+		It("logs the error and carries on", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Hour)
+			Eventually(logger).Should(gbytes.Say("sweep-failed"))
+
+			fakeClock.Increment(time.Hour)
+			Eventually(fakeSweeper.SweepCallCount).Should(Equal(2))
+		})
+	})
+
+	Context("when there is a disk pressure threshold", func() {
+		var fakeThreshold *fakes.FakeThreshold
+
+		BeforeEach(func() {
+			fakeThreshold = new(fakes.FakeThreshold)
+			backgroundGC.Pressure = fakeThreshold
+			backgroundGC.Cake = new(fake_cake.FakeCake)
+		})
+
+		It("does not sweep while the disk is not under pressure", func() {
+			fakeClock.WaitForNWatchersAndIncrement(rootfs_provider.DefaultPressureCheckInterval, 2)
+			Eventually(fakeThreshold.ExceededCallCount).Should(Equal(1))
+			Consistently(fakeSweeper.SweepCallCount).Should(Equal(0))
+		})
+
+		It("sweeps as soon as the disk is under pressure", func() {
+			fakeThreshold.ExceededReturns(true)
+
+			fakeClock.WaitForNWatchersAndIncrement(rootfs_provider.DefaultPressureCheckInterval, 2)
+			Eventually(fakeSweeper.SweepCallCount).Should(Equal(1))
+		})
+	})
+
+	Context("when it is stopped", func() {
+		It("returns", func() {
+			close(stop)
+			Eventually(done).Should(BeClosed())
+			stop = make(chan struct{})
+		})
+	})
+})
@@ -8,6 +8,7 @@ import (
 
 	"code.cloudfoundry.org/garden"
 	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
 	"code.cloudfoundry.org/garden-shed/repository_fetcher"
 	"code.cloudfoundry.org/guardian/gardener"
 	"code.cloudfoundry.org/lager"
@@ -26,6 +27,9 @@ type RepositoryFetcher interface {
 //go:generate counterfeiter . GCer
 type GCer interface {
 	GC(log lager.Logger, cake layercake.Cake) error
+	DryRun(log lager.Logger, cake layercake.Cake) (*cleaner.Report, error)
+	Candidates(log lager.Logger, cake layercake.Cake) ([]layercake.ID, error)
+	RemoveChain(log lager.Logger, cake layercake.Cake, id layercake.ID) (int64, error)
 }
 
 //go:generate counterfeiter . Metricser
@@ -33,6 +37,18 @@ type Metricser interface {
 	Metrics(logger lager.Logger, id layercake.ID) (garden.ContainerDiskStat, error)
 }
 
+//go:generate counterfeiter . UsageRecorder
+type UsageRecorder interface {
+	Touch(id layercake.ID) error
+}
+
+//go:generate counterfeiter . Retention
+type Retention interface {
+	Retain(log lager.Logger, image string) error
+	Release(log lager.Logger, image string) error
+	Retained() []string
+}
+
 // CakeOrdinator manages a cake, fetching layers as neccesary
 type CakeOrdinator struct {
 	mu sync.RWMutex
@@ -42,17 +58,21 @@ type CakeOrdinator struct {
 	layerCreator LayerCreator
 	metrics      Metricser
 	gc           GCer
+	usage        UsageRecorder
+	retention    Retention
 }
 
 // New creates a new cake-ordinator, there should only be one CakeOrdinator
 // for a particular cake.
-func NewCakeOrdinator(cake layercake.Cake, fetcher RepositoryFetcher, layerCreator LayerCreator, metrics Metricser, gc GCer) *CakeOrdinator {
+func NewCakeOrdinator(cake layercake.Cake, fetcher RepositoryFetcher, layerCreator LayerCreator, metrics Metricser, gc GCer, usage UsageRecorder, retention Retention) *CakeOrdinator {
 	return &CakeOrdinator{
 		cake:         cake,
 		fetcher:      fetcher,
 		layerCreator: layerCreator,
 		metrics:      metrics,
 		gc:           gc,
+		usage:        usage,
+		retention:    retention,
 	}
 }
 
@@ -80,12 +100,29 @@ func (c *CakeOrdinator) Create(logger lager.Logger, id string, spec gardener.Roo
 	if err != nil {
 		return specs.Spec{}, err
 	}
+
+	c.recordUsage(logger, layercake.ContainerID(id))
+
 	return specs.Spec{
-		Root:    &specs.Root{Path: rootFS},
-		Process: &specs.Process{Env: env},
+		Root:        &specs.Root{Path: rootFS},
+		Process:     imageProcess(logger, rootFS, env, image),
+		Annotations: imageAnnotations(image),
 	}, nil
 }
 
+// recordUsage marks the layer the container was created on as used, so that
+// GC keeps recently used images around for longest
+func (c *CakeOrdinator) recordUsage(logger lager.Logger, cid layercake.ID) {
+	img, err := c.cake.Get(cid)
+	if err != nil || img.Parent == "" {
+		return
+	}
+
+	if err := c.usage.Touch(layercake.DockerImageID(img.Parent)); err != nil {
+		logger.Error("failed-to-record-usage", err, lager.Data{"layer": img.Parent})
+	}
+}
+
 func (c *CakeOrdinator) Metrics(logger lager.Logger, id string, _ bool) (garden.ContainerDiskStat, error) {
 	logger = logger.Session("metrics", lager.Data{"id": id})
 	logger.Debug("start")
@@ -121,3 +158,81 @@ func (c *CakeOrdinator) GC(logger lager.Logger) error {
 
 	return c.gc.GC(logger, c.cake)
 }
+
+// Sweep is GC for running in the background. It picks the chains to remove
+// under the read lock, alongside creates, and takes the write lock only while
+// removing each chain.
+func (c *CakeOrdinator) Sweep(logger lager.Logger) error {
+	logger = logger.Session("sweep")
+	logger.Info("start")
+	defer logger.Info("finished")
+
+	c.mu.RLock()
+	candidates, err := c.gc.Candidates(logger, c.cake)
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	var reclaimed int64
+	for _, id := range candidates {
+		freed, err := c.removeChain(logger, id)
+		reclaimed += freed
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Info("reclaimed", lager.Data{"bytes": reclaimed, "chains": len(candidates)})
+	return nil
+}
+
+func (c *CakeOrdinator) removeChain(logger lager.Logger, id layercake.ID) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gc.RemoveChain(logger, c.cake, id)
+}
+
+// Retain keeps GC from removing the image until it is released, including
+// across restarts. It holds off GC while it resolves the image, so that GC
+// cannot remove the image in between.
+func (c *CakeOrdinator) Retain(logger lager.Logger, image string) error {
+	logger = logger.Session("retain", lager.Data{"image": image})
+	logger.Info("start")
+	c.mu.RLock()
+	defer func() {
+		c.mu.RUnlock()
+		logger.Info("finished")
+	}()
+
+	return c.retention.Retain(logger, image)
+}
+
+// Release lets GC remove a retained image once no container uses it
+func (c *CakeOrdinator) Release(logger lager.Logger, image string) error {
+	logger = logger.Session("release", lager.Data{"image": image})
+	logger.Info("start")
+	defer logger.Info("finished")
+
+	return c.retention.Release(logger, image)
+}
+
+// Retained returns the images retained by Retain
+func (c *CakeOrdinator) Retained() []string {
+	return c.retention.Retained()
+}
+
+// DryRunGC reports what GC would remove, without removing anything. Since it
+// does not change the graph it runs alongside creates.
+func (c *CakeOrdinator) DryRunGC(logger lager.Logger) (*cleaner.Report, error) {
+	logger = logger.Session("gc-dry-run")
+	logger.Info("start")
+	c.mu.RLock()
+	defer func() {
+		c.mu.RUnlock()
+		logger.Info("finished")
+	}()
+
+	return c.gc.DryRun(logger, c.cake)
+}
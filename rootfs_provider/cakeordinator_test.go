@@ -2,10 +2,16 @@ package rootfs_provider_test
 
 import (
 	"errors"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
+	"time"
 
 	"code.cloudfoundry.org/garden"
 	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
+	"code.cloudfoundry.org/garden-shed/layercake/cleaner/cleanerfakes"
 	"code.cloudfoundry.org/garden-shed/layercake/fake_cake"
 	"code.cloudfoundry.org/garden-shed/repository_fetcher"
 	"code.cloudfoundry.org/garden-shed/rootfs_provider"
@@ -13,6 +19,8 @@ import (
 	"code.cloudfoundry.org/guardian/gardener"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/docker/docker/image"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -25,6 +33,8 @@ var _ = Describe("The Cake Co-ordinator", func() {
 		fakeCake         *fake_cake.FakeCake
 		fakeGCer         *fakes.FakeGCer
 		fakeMetrics      *fakes.FakeMetricser
+		fakeUsage        *fakes.FakeUsageRecorder
+		fakeRetention    *fakes.FakeRetention
 		logger           *lagertest.TestLogger
 
 		cakeOrdinator *rootfs_provider.CakeOrdinator
@@ -34,12 +44,16 @@ var _ = Describe("The Cake Co-ordinator", func() {
 		logger = lagertest.NewTestLogger("test")
 
 		fakeFetcher = new(fakes.FakeRepositoryFetcher)
+		fakeFetcher.FetchReturns(&repository_fetcher.Image{}, nil)
 
 		fakeLayerCreator = new(fakes.FakeLayerCreator)
 		fakeCake = new(fake_cake.FakeCake)
+		fakeCake.GetReturns(&image.Image{}, nil)
 		fakeGCer = new(fakes.FakeGCer)
 		fakeMetrics = new(fakes.FakeMetricser)
-		cakeOrdinator = rootfs_provider.NewCakeOrdinator(fakeCake, fakeFetcher, fakeLayerCreator, fakeMetrics, fakeGCer)
+		fakeUsage = new(fakes.FakeUsageRecorder)
+		fakeRetention = new(fakes.FakeRetention)
+		cakeOrdinator = rootfs_provider.NewCakeOrdinator(fakeCake, fakeFetcher, fakeLayerCreator, fakeMetrics, fakeGCer, fakeUsage, fakeRetention)
 	})
 
 	Describe("creating container layers", func() {
@@ -68,12 +82,147 @@ var _ = Describe("The Cake Co-ordinator", func() {
 			})
 		})
 
+		Context("when the image sets process defaults", func() {
+			var rootFS string
+
+			BeforeEach(func() {
+				var err error
+				rootFS, err = ioutil.TempDir("", "rootfs")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(os.MkdirAll(filepath.Join(rootFS, "etc"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(rootFS, "etc", "passwd"), []byte("root:x:0:0::/root:/bin/sh\napp:x:1000:1001::/home/app:/bin/sh\n"), 0644)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(rootFS, "etc", "group"), []byte("root:x:0:\nstaff:x:50:app\n"), 0644)).To(Succeed())
+
+				fakeLayerCreator.CreateReturns(rootFS, []string{"foo=bar"}, nil)
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(rootFS)).To(Succeed())
+			})
+
+			create := func(image *repository_fetcher.Image) specs.Spec {
+				fakeFetcher.FetchReturns(image, nil)
+
+				spec, err := cakeOrdinator.Create(logger, "container-id", gardener.RootfsSpec{RootFS: &url.URL{Path: "parent"}})
+				Expect(err).NotTo(HaveOccurred())
+				return spec
+			}
+
+			It("maps them into the process of the runtime spec", func() {
+				spec := create(&repository_fetcher.Image{
+					WorkingDir: "/home/app",
+					Entrypoint: []string{"/bin/sh", "-c"},
+					Cmd:        []string{"echo hello"},
+				})
+
+				Expect(spec.Process.Env).To(Equal([]string{"foo=bar"}))
+				Expect(spec.Process.Cwd).To(Equal("/home/app"))
+				Expect(spec.Process.Args).To(Equal([]string{"/bin/sh", "-c", "echo hello"}))
+			})
+
+			It("annotates the runtime spec with the stop signal and exposed ports", func() {
+				spec := create(&repository_fetcher.Image{
+					StopSignal:   "SIGQUIT",
+					ExposedPorts: []string{"53/udp", "8080/tcp"},
+				})
+
+				Expect(spec.Annotations).To(Equal(map[string]string{
+					rootfs_provider.StopSignalAnnotation:   "SIGQUIT",
+					rootfs_provider.ExposedPortsAnnotation: "53/udp,8080/tcp",
+				}))
+			})
+
+			It("resolves a named user in the rootfs", func() {
+				spec := create(&repository_fetcher.Image{User: "app"})
+				Expect(spec.Process.User.Username).To(Equal("app"))
+				Expect(spec.Process.User.UID).To(BeEquivalentTo(1000))
+				Expect(spec.Process.User.GID).To(BeEquivalentTo(1001))
+			})
+
+			It("resolves a named group in the rootfs", func() {
+				spec := create(&repository_fetcher.Image{User: "app:staff"})
+				Expect(spec.Process.User.UID).To(BeEquivalentTo(1000))
+				Expect(spec.Process.User.GID).To(BeEquivalentTo(50))
+			})
+
+			It("uses numeric users as they are", func() {
+				spec := create(&repository_fetcher.Image{User: "2000:3000"})
+				Expect(spec.Process.User.UID).To(BeEquivalentTo(2000))
+				Expect(spec.Process.User.GID).To(BeEquivalentTo(3000))
+			})
+
+			Context("when the user is not in the rootfs", func() {
+				It("only sets the username", func() {
+					spec := create(&repository_fetcher.Image{User: "nobody"})
+					Expect(spec.Process.User).To(Equal(specs.User{Username: "nobody"}))
+				})
+
+				It("still creates the container", func() {
+					fakeCake.GetReturns(&image.Image{ID: "container-id", Parent: "image-top-layer"}, nil)
+					create(&repository_fetcher.Image{User: "nobody"})
+
+					Expect(fakeCake.RemoveCallCount()).To(Equal(0))
+					Expect(fakeUsage.TouchCallCount()).To(Equal(1))
+				})
+			})
+
+			Context("when the group is not in the rootfs", func() {
+				It("only sets the username", func() {
+					spec := create(&repository_fetcher.Image{User: "app:wheel"})
+					Expect(spec.Process.User).To(Equal(specs.User{Username: "app:wheel"}))
+				})
+			})
+
+			Context("when /etc/passwd is a symlink", func() {
+				BeforeEach(func() {
+					passwd := filepath.Join(rootFS, "etc", "passwd")
+					Expect(os.Remove(passwd)).To(Succeed())
+					Expect(os.Symlink("/etc/passwd", passwd)).To(Succeed())
+				})
+
+				It("does not follow it", func() {
+					spec := create(&repository_fetcher.Image{User: "root"})
+					Expect(spec.Process.User).To(Equal(specs.User{Username: "root"}))
+				})
+			})
+		})
+
+		Context("when the container layer has been created", func() {
+			BeforeEach(func() {
+				fakeCake.GetReturns(&image.Image{ID: "container-id", Parent: "image-top-layer"}, nil)
+			})
+
+			It("marks the layer it was created on as used", func() {
+				_, err := cakeOrdinator.Create(logger, "container-id", gardener.RootfsSpec{})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeCake.GetArgsForCall(0)).To(Equal(layercake.ContainerID("container-id")))
+				Expect(fakeUsage.TouchCallCount()).To(Equal(1))
+				Expect(fakeUsage.TouchArgsForCall(0)).To(Equal(layercake.DockerImageID("image-top-layer")))
+			})
+
+			Context("when recording the usage fails", func() {
+				It("still succeeds", func() {
+					fakeUsage.TouchReturns(errors.New("disk full"))
+					_, err := cakeOrdinator.Create(logger, "container-id", gardener.RootfsSpec{})
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+		})
+
 		Context("when creating a layer fails", func() {
 			It("returns an error", func() {
 				fakeLayerCreator.CreateReturns("", nil, errors.New("cake"))
 				_, err := cakeOrdinator.Create(logger, "container-id", gardener.RootfsSpec{})
 				Expect(err).To(MatchError("cake"))
 			})
+
+			It("does not mark anything as used", func() {
+				fakeLayerCreator.CreateReturns("", nil, errors.New("cake"))
+				cakeOrdinator.Create(logger, "container-id", gardener.RootfsSpec{})
+				Expect(fakeUsage.TouchCallCount()).To(Equal(0))
+			})
 		})
 
 		Context("when fetching fails", func() {
@@ -224,6 +373,245 @@ var _ = Describe("The Cake Co-ordinator", func() {
 		})
 	})
 
+	Describe("Sweep", func() {
+		BeforeEach(func() {
+			fakeGCer.CandidatesReturns([]layercake.ID{
+				layercake.DockerImageID("old"),
+				layercake.DockerImageID("older"),
+			}, nil)
+		})
+
+		It("removes the candidate chains one at a time", func() {
+			Expect(cakeOrdinator.Sweep(logger)).To(Succeed())
+
+			Expect(fakeGCer.RemoveChainCallCount()).To(Equal(2))
+			_, cake, id := fakeGCer.RemoveChainArgsForCall(0)
+			Expect(cake).To(Equal(fakeCake))
+			Expect(id).To(Equal(layercake.DockerImageID("old")))
+			_, _, id = fakeGCer.RemoveChainArgsForCall(1)
+			Expect(id).To(Equal(layercake.DockerImageID("older")))
+
+			Expect(fakeGCer.GCCallCount()).To(Equal(0))
+		})
+
+		It("does not block creation while picking the candidates", func() {
+			candidatesReturn := make(chan struct{})
+			fakeGCer.CandidatesStub = func(_ lager.Logger, _ layercake.Cake) ([]layercake.ID, error) {
+				<-candidatesReturn
+				return nil, nil
+			}
+
+			go cakeOrdinator.Sweep(logger)
+			Eventually(fakeGCer.CandidatesCallCount).Should(Equal(1))
+
+			go cakeOrdinator.Create(logger, "", gardener.RootfsSpec{
+				RootFS:    &url.URL{},
+				QuotaSize: 33,
+			})
+
+			Eventually(fakeFetcher.FetchCallCount).Should(Equal(1))
+			close(candidatesReturn)
+		})
+
+		It("blocks creation while removing a chain", func() {
+			removeReturns := make(chan struct{})
+			fakeGCer.RemoveChainStub = func(_ lager.Logger, _ layercake.Cake, _ layercake.ID) (int64, error) {
+				<-removeReturns
+				return 0, nil
+			}
+
+			go cakeOrdinator.Sweep(logger)
+			Eventually(fakeGCer.RemoveChainCallCount).Should(Equal(1))
+
+			go cakeOrdinator.Create(logger, "", gardener.RootfsSpec{
+				RootFS:    &url.URL{},
+				QuotaSize: 33,
+			})
+
+			Consistently(fakeFetcher.FetchCallCount).Should(Equal(0))
+			close(removeReturns)
+			Eventually(fakeFetcher.FetchCallCount).Should(Equal(1))
+		})
+
+		Context("when picking the candidates fails", func() {
+			It("returns the error without removing anything", func() {
+				fakeGCer.CandidatesReturns(nil, errors.New("potato"))
+				Expect(cakeOrdinator.Sweep(logger)).To(MatchError("potato"))
+				Expect(fakeGCer.RemoveChainCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when removing a chain fails", func() {
+			It("stops and returns the error", func() {
+				fakeGCer.RemoveChainReturns(0, errors.New("cake failure"))
+				Expect(cakeOrdinator.Sweep(logger)).To(MatchError("cake failure"))
+				Expect(fakeGCer.RemoveChainCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("with a namespaced chain", func() {
+			var (
+				layers            map[string]*image.Image
+				namespacedParents map[string]string
+			)
+
+			BeforeEach(func() {
+				// base <- top <- namespaced, where the namespaced layer only
+				// has a parent as far as the cake is concerned, and a more
+				// recent, unrelated chain
+				layers = map[string]*image.Image{
+					"base":       {ID: "base", Size: 100, Created: time.Unix(100, 0)},
+					"top":        {ID: "top", Parent: "base", Size: 20, Created: time.Unix(100, 0)},
+					"namespaced": {ID: "namespaced", Size: 30, Created: time.Unix(100, 0)},
+					"recent":     {ID: "recent", Size: 5, Created: time.Unix(200, 0)},
+				}
+				namespacedParents = map[string]string{"namespaced": "top"}
+
+				fakeCake.AllStub = func() []*image.Image {
+					var all []*image.Image
+					for _, id := range []string{"base", "top", "namespaced", "recent"} {
+						if layer, ok := layers[id]; ok {
+							all = append(all, layer)
+						}
+					}
+					return all
+				}
+				fakeCake.GetStub = func(id layercake.ID) (*image.Image, error) {
+					layer, ok := layers[id.GraphID()]
+					if !ok {
+						return nil, errors.New("no such layer")
+					}
+
+					img := *layer
+					if parent, ok := namespacedParents[id.GraphID()]; ok {
+						img.Parent = parent
+					}
+					return &img, nil
+				}
+				fakeCake.IsLeafStub = func(id layercake.ID) (bool, error) {
+					for child, layer := range layers {
+						if layer.Parent == id.GraphID() || namespacedParents[child] == id.GraphID() {
+							return false, nil
+						}
+					}
+					return true, nil
+				}
+				fakeCake.GetAllLeavesReturns([]layercake.ID{
+					layercake.DockerImageID("namespaced"),
+					layercake.DockerImageID("recent"),
+				}, nil)
+				fakeCake.RemoveStub = func(id layercake.ID) error {
+					delete(layers, id.GraphID())
+					return nil
+				}
+
+				fakeThreshold := new(cleanerfakes.FakeThreshold)
+				fakeThreshold.ExceededReturns(true)
+				fakeTarget := new(cleanerfakes.FakeTarget)
+				fakeTarget.ExcessReturns(150, nil)
+
+				ovenCleaner := cleaner.NewOvenCleaner(cleaner.NewRetainer(), fakeThreshold)
+				ovenCleaner.LowWaterMark = fakeTarget
+
+				cakeOrdinator = rootfs_provider.NewCakeOrdinator(fakeCake, fakeFetcher, fakeLayerCreator, fakeMetrics, ovenCleaner, fakeUsage, fakeRetention)
+			})
+
+			It("counts the whole chain towards the low-water mark and removes no more than it needs", func() {
+				Expect(cakeOrdinator.Sweep(logger)).To(Succeed())
+
+				Expect(fakeCake.RemoveCallCount()).To(Equal(3))
+				Expect(layers).To(HaveKey("recent"))
+				Expect(layers).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("retaining images", func() {
+		It("retains the image", func() {
+			Expect(cakeOrdinator.Retain(logger, "docker:///busybox")).To(Succeed())
+
+			Expect(fakeRetention.RetainCallCount()).To(Equal(1))
+			_, image := fakeRetention.RetainArgsForCall(0)
+			Expect(image).To(Equal("docker:///busybox"))
+		})
+
+		It("returns the error when the image cannot be retained", func() {
+			fakeRetention.RetainReturns(errors.New("potato"))
+			Expect(cakeOrdinator.Retain(logger, "docker:///busybox")).To(MatchError("potato"))
+		})
+
+		It("does not let GC run while the image is resolved", func() {
+			retainReturns := make(chan struct{})
+			fakeRetention.RetainStub = func(_ lager.Logger, _ string) error {
+				<-retainReturns
+				return nil
+			}
+
+			go cakeOrdinator.Retain(logger, "docker:///busybox")
+			Eventually(fakeRetention.RetainCallCount).Should(Equal(1))
+
+			go cakeOrdinator.GC(logger)
+
+			Consistently(fakeGCer.GCCallCount).Should(Equal(0))
+			close(retainReturns)
+			Eventually(fakeGCer.GCCallCount).Should(Equal(1))
+		})
+
+		It("releases the image", func() {
+			Expect(cakeOrdinator.Release(logger, "docker:///busybox")).To(Succeed())
+
+			Expect(fakeRetention.ReleaseCallCount()).To(Equal(1))
+			_, image := fakeRetention.ReleaseArgsForCall(0)
+			Expect(image).To(Equal("docker:///busybox"))
+		})
+
+		It("lists the retained images", func() {
+			fakeRetention.RetainedReturns([]string{"docker:///busybox"})
+			Expect(cakeOrdinator.Retained()).To(Equal([]string{"docker:///busybox"}))
+		})
+	})
+
+	Describe("DryRunGC", func() {
+		It("delegates to the GCer", func() {
+			report := &cleaner.Report{DryRun: true, Reclaimed: 42}
+			fakeGCer.DryRunReturns(report, nil)
+
+			Expect(cakeOrdinator.DryRunGC(logger)).To(Equal(report))
+			Expect(fakeGCer.DryRunCallCount()).To(Equal(1))
+			Expect(fakeGCer.GCCallCount()).To(Equal(0))
+
+			_, cake := fakeGCer.DryRunArgsForCall(0)
+			Expect(cake).To(Equal(fakeCake))
+		})
+
+		It("does not block creation", func() {
+			dryRunReturns := make(chan struct{})
+			fakeGCer.DryRunStub = func(_ lager.Logger, _ layercake.Cake) (*cleaner.Report, error) {
+				<-dryRunReturns
+				return &cleaner.Report{}, nil
+			}
+
+			go cakeOrdinator.DryRunGC(logger)
+			Eventually(fakeGCer.DryRunCallCount).Should(Equal(1))
+
+			go cakeOrdinator.Create(logger, "", gardener.RootfsSpec{
+				RootFS:    &url.URL{},
+				QuotaSize: 33,
+			})
+
+			Eventually(fakeFetcher.FetchCallCount).Should(Equal(1))
+			close(dryRunReturns)
+		})
+
+		Context("when the dry run fails", func() {
+			It("returns the error", func() {
+				fakeGCer.DryRunReturns(nil, errors.New("potato"))
+				_, err := cakeOrdinator.DryRunGC(logger)
+				Expect(err).To(MatchError("potato"))
+			})
+		})
+	})
+
 	It("allows concurrent creation as long as deletion is not ongoing", func() {
 		fakeBlocks := make(chan struct{})
 		fakeFetcher.FetchStub = func(lager.Logger, *url.URL, string, string, int64) (*repository_fetcher.Image, error) {
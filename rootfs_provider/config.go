@@ -0,0 +1,91 @@
+package rootfs_provider
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/idmapper"
+)
+
+// Config is what Wire builds a CakeOrdinator from
+type Config struct {
+	GraphRoot string
+
+	// RootFS is the rootfs of containers which do not ask for one
+	RootFS string
+
+	// HashRootFSContents identifies local rootfses by their contents rather
+	// than by their path and modification time
+	HashRootFSContents bool
+
+	// PersistentImages are never garbage collected
+	PersistentImages []string
+
+	Registry RegistryConfig
+	GC       GCConfig
+
+	UIDMappings idmapper.MappingList
+	GIDMappings idmapper.MappingList
+}
+
+// RegistryConfig says how images are fetched from registries
+type RegistryConfig struct {
+	// DefaultRegistry is the host of images which do not name one
+	DefaultRegistry    string
+	InsecureRegistries []string
+
+	// CertsDir holds CA and client certificates per registry host, laid out
+	// like docker's certs.d
+	CertsDir string
+
+	// Mirrors are tried, in order, before the registry they mirror
+	Mirrors map[string][]string
+
+	// DockerConfigPath is a docker config.json to take credentials from
+	DockerConfigPath string
+
+	// TrustPolicyDir holds the signature policy images have to satisfy
+	TrustPolicyDir string
+
+	PullPolicy             string
+	Platform               string
+	MaxConcurrentDownloads int
+}
+
+// GCConfig says when unused layers are garbage collected, and how many
+type GCConfig struct {
+	// ThresholdInMegabytes is the size of the graph, including backing
+	// stores and partial downloads, over which GC runs. A negative threshold
+	// disables GC.
+	ThresholdInMegabytes int
+
+	// LowWaterMarkInMegabytes, if set, is the size GC brings the graph down
+	// to, removing the least recently used layers first. When it is not set,
+	// i.e. 0, GC removes every unused layer.
+	LowWaterMarkInMegabytes int
+
+	// TriggerFreeSpace, e.g. "10%" or "5G", replaces the threshold: GC runs
+	// once less space than that is free on the disk of the graph, until
+	// TargetFreeSpace is free, or else TriggerFreeSpace again
+	TriggerFreeSpace string
+	TargetFreeSpace  string
+
+	// BackgroundInterval, if set, runs GC in the background this often, and
+	// as soon as the free space trigger is hit
+	BackgroundInterval time.Duration
+}
+
+func (c GCConfig) threshold() int64 {
+	return megabytes(c.ThresholdInMegabytes)
+}
+
+func (c GCConfig) lowWaterMark() (int64, bool) {
+	if c.LowWaterMarkInMegabytes <= 0 {
+		return 0, false
+	}
+
+	return megabytes(c.LowWaterMarkInMegabytes), true
+}
+
+func megabytes(n int) int64 {
+	return int64(n) * 1024 * 1024
+}
@@ -0,0 +1,32 @@
+package rootfs_provider
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GCConfig", func() {
+	It("converts the threshold to bytes", func() {
+		Expect(GCConfig{ThresholdInMegabytes: 3}.threshold()).To(BeEquivalentTo(3 * 1024 * 1024))
+	})
+
+	It("converts the low-water mark to bytes", func() {
+		lowWaterMark, ok := GCConfig{ThresholdInMegabytes: 3, LowWaterMarkInMegabytes: 2}.lowWaterMark()
+		Expect(ok).To(BeTrue())
+		Expect(lowWaterMark).To(BeEquivalentTo(2 * 1024 * 1024))
+	})
+
+	Context("when the low-water mark is not set", func() {
+		It("has none, so that GC removes every unused layer", func() {
+			_, ok := GCConfig{ThresholdInMegabytes: 3}.lowWaterMark()
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("when the low-water mark is negative", func() {
+		It("is treated as not set", func() {
+			_, ok := GCConfig{ThresholdInMegabytes: 3, LowWaterMarkInMegabytes: -1}.lowWaterMark()
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
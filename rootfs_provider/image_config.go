@@ -0,0 +1,177 @@
+package rootfs_provider
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/garden-shed/repository_fetcher"
+	"code.cloudfoundry.org/lager"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// annotations carrying image defaults the runtime spec has no field for, as
+// named by the OCI image spec
+const (
+	StopSignalAnnotation   = "org.opencontainers.image.stopSignal"
+	ExposedPortsAnnotation = "org.opencontainers.image.exposedPorts"
+)
+
+// imageProcess returns the process the image runs by default. A named user is
+// looked up in the /etc/passwd and /etc/group of rootFS; if that fails, for
+// example because either is a symlink, only the raw user is set as the
+// Username and it is up to the runtime to resolve it.
+func imageProcess(log lager.Logger, rootFS string, env []string, image *repository_fetcher.Image) *specs.Process {
+	process := &specs.Process{
+		Env:  env,
+		Cwd:  image.WorkingDir,
+		Args: append(append([]string(nil), image.Entrypoint...), image.Cmd...),
+	}
+
+	if image.User == "" {
+		return process
+	}
+
+	user, err := resolveUser(rootFS, image.User)
+	if err != nil {
+		log.Error("failed-to-resolve-image-user", err, lager.Data{"user": image.User})
+		user = specs.User{}
+	}
+
+	user.Username = image.User
+	process.User = user
+
+	return process
+}
+
+func imageAnnotations(image *repository_fetcher.Image) map[string]string {
+	annotations := make(map[string]string)
+	if image.StopSignal != "" {
+		annotations[StopSignalAnnotation] = image.StopSignal
+	}
+
+	if len(image.ExposedPorts) > 0 {
+		annotations[ExposedPortsAnnotation] = strings.Join(image.ExposedPorts, ",")
+	}
+
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	return annotations
+}
+
+// resolveUser resolves a user[:group] string, where both may be names or
+// IDs, the way docker does. Without a group the user's primary group is used.
+func resolveUser(rootFS, userSpec string) (specs.User, error) {
+	userName, groupName := userSpec, ""
+	if i := strings.Index(userSpec, ":"); i >= 0 {
+		userName, groupName = userSpec[:i], userSpec[i+1:]
+	}
+
+	var user specs.User
+	if uid, err := strconv.ParseUint(userName, 10, 32); err == nil {
+		user.UID = uint32(uid)
+
+		// the primary group of a numeric user still comes from /etc/passwd
+		if entry, ok, err := findEntry(rootFS, "/etc/passwd", 2, userName); err == nil && ok {
+			if gid, err := strconv.ParseUint(entry[3], 10, 32); err == nil {
+				user.GID = uint32(gid)
+			}
+		}
+	} else {
+		entry, ok, err := findEntry(rootFS, "/etc/passwd", 0, userName)
+		if err != nil {
+			return specs.User{}, err
+		}
+		if !ok {
+			return specs.User{}, fmt.Errorf("user %s not found in /etc/passwd", userName)
+		}
+
+		if user, err = passwdUser(entry); err != nil {
+			return specs.User{}, err
+		}
+	}
+
+	if groupName == "" {
+		return user, nil
+	}
+
+	if gid, err := strconv.ParseUint(groupName, 10, 32); err == nil {
+		user.GID = uint32(gid)
+		return user, nil
+	}
+
+	entry, ok, err := findEntry(rootFS, "/etc/group", 0, groupName)
+	if err != nil {
+		return specs.User{}, err
+	}
+	if !ok {
+		return specs.User{}, fmt.Errorf("group %s not found in /etc/group", groupName)
+	}
+
+	gid, err := strconv.ParseUint(entry[2], 10, 32)
+	if err != nil {
+		return specs.User{}, fmt.Errorf("invalid gid for group %s: %s", groupName, err)
+	}
+	user.GID = uint32(gid)
+
+	return user, nil
+}
+
+func passwdUser(entry []string) (specs.User, error) {
+	uid, err := strconv.ParseUint(entry[2], 10, 32)
+	if err != nil {
+		return specs.User{}, fmt.Errorf("invalid uid for user %s: %s", entry[0], err)
+	}
+
+	gid, err := strconv.ParseUint(entry[3], 10, 32)
+	if err != nil {
+		return specs.User{}, fmt.Errorf("invalid gid for user %s: %s", entry[0], err)
+	}
+
+	return specs.User{UID: uint32(uid), GID: uint32(gid)}, nil
+}
+
+// findEntry returns the first line of the colon separated file at path inside
+// rootFS whose field at index equals value. Symlinks are not followed, since
+// they could point at files of the host.
+func findEntry(rootFS, path string, index int, value string) ([]string, bool, error) {
+	for p := path; p != "/"; p = filepath.Dir(p) {
+		info, err := os.Lstat(filepath.Join(rootFS, p))
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil, false, fmt.Errorf("%s is a symlink", p)
+		}
+	}
+
+	path = filepath.Join(rootFS, path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if fields[index] == value {
+			return fields, true, nil
+		}
+	}
+
+	return nil, false, scanner.Err()
+}
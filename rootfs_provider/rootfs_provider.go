@@ -1,22 +1,25 @@
 package rootfs_provider
 
 import (
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"time"
 
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/garden-shed/distclient"
 	quotaed_aufs "code.cloudfoundry.org/garden-shed/docker_drivers/aufs"
+	quotaed_overlay "code.cloudfoundry.org/garden-shed/docker_drivers/overlay"
 	"code.cloudfoundry.org/garden-shed/layercake"
 	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
 	"code.cloudfoundry.org/garden-shed/quota_manager"
 	"code.cloudfoundry.org/garden-shed/repository_fetcher"
 	"code.cloudfoundry.org/guardian/gardener"
 	"code.cloudfoundry.org/guardian/logging"
-	"code.cloudfoundry.org/idmapper"
 	"code.cloudfoundry.org/lager"
 	"github.com/docker/docker/daemon/graphdriver"
+	_ "github.com/docker/docker/daemon/graphdriver/overlay"
 	"github.com/docker/docker/graph"
 	"github.com/eapache/go-resiliency/retrier"
 )
@@ -25,55 +28,66 @@ type Graph interface {
 	layercake.Cake
 }
 
-func Wire(
-	logger lager.Logger,
-	runner *logging.Runner,
-	graphRoot string,
-	rootFS string,
-	dockerRegistry string,
-	insecureRegistries []string,
-	persistentImages []string,
-	cleanupThresholdInMegabytes int,
-	uidMappings idmapper.MappingList,
-	gidMappings idmapper.MappingList,
-) *CakeOrdinator {
-	logger = logger.Session(gardener.VolumizerSession, lager.Data{"graphRoot": graphRoot})
+type quotaedDriver interface {
+	graphdriver.Driver
+	GetDiffLayerPath(rootFSPath string) string
+	GetMntPath(id layercake.ID) string
+}
+
+func Wire(logger lager.Logger, runner *logging.Runner, config Config) *CakeOrdinator {
+	logger = logger.Session(gardener.VolumizerSession, lager.Data{"graphRoot": config.GraphRoot})
 
 	if err := exec.Command("modprobe", "aufs").Run(); err != nil {
+		// the overlay graph driver is used instead when aufs is unavailable
 		logger.Error("unable-to-load-aufs", err)
 	}
 
-	if err := os.MkdirAll(graphRoot, 0755); err != nil {
+	if err := os.MkdirAll(config.GraphRoot, 0755); err != nil {
 		logger.Fatal("failed-to-create-graph-directory", err)
 	}
 
-	dockerGraphDriver, err := graphdriver.New(graphRoot, nil)
+	dockerGraphDriver, err := graphdriver.New(config.GraphRoot, nil)
 	if err != nil {
 		logger.Fatal("failed-to-construct-graph-driver", err)
 	}
 
-	backingStoresPath := filepath.Join(graphRoot, "backing_stores")
+	backingStoresPath := filepath.Join(config.GraphRoot, "backing_stores")
 	if mkdirErr := os.MkdirAll(backingStoresPath, 0660); mkdirErr != nil {
 		logger.Fatal("failed-to-mkdir-backing-stores", mkdirErr)
 	}
 
-	quotaedGraphDriver := &quotaed_aufs.QuotaedDriver{
-		GraphDriver: dockerGraphDriver,
-		Unmount:     quotaed_aufs.Unmount,
-		BackingStoreMgr: &quotaed_aufs.BackingStore{
-			RootPath: backingStoresPath,
-			Logger:   logger.Session("backing-store-mgr"),
-		},
-		LoopMounter: &quotaed_aufs.Loop{
-			Retrier: retrier.New(retrier.ConstantBackoff(200, 500*time.Millisecond), nil),
-			Logger:  logger.Session("loop-mounter"),
-		},
-		Retrier:  retrier.New(retrier.ConstantBackoff(200, 500*time.Millisecond), nil),
-		RootPath: graphRoot,
-		Logger:   logger.Session("quotaed-driver"),
+	backingStoreMgr := &quotaed_aufs.BackingStore{
+		RootPath: backingStoresPath,
+		Logger:   logger.Session("backing-store-mgr"),
+	}
+
+	loopMounter := &quotaed_aufs.Loop{
+		Retrier: retrier.New(retrier.ConstantBackoff(200, 500*time.Millisecond), nil),
+		Logger:  logger.Session("loop-mounter"),
+	}
+
+	var quotaedGraphDriver quotaedDriver
+	if dockerGraphDriver.String() == "overlay" {
+		quotaedGraphDriver = &quotaed_overlay.QuotaedDriver{
+			GraphDriver:     dockerGraphDriver,
+			BackingStoreMgr: backingStoreMgr,
+			LoopMounter:     loopMounter,
+			RootPath:        config.GraphRoot,
+			Logger:          logger.Session("quotaed-driver"),
+		}
+	} else {
+		quotaedGraphDriver = &quotaed_aufs.QuotaedDriver{
+			GraphDriver:     dockerGraphDriver,
+			Unmount:         quotaed_aufs.Unmount,
+			BackingStoreMgr: backingStoreMgr,
+			LoopMounter:     loopMounter,
+			Retrier:         retrier.New(retrier.ConstantBackoff(200, 500*time.Millisecond), nil),
+			RootPath:        config.GraphRoot,
+			Logger:          logger.Session("quotaed-driver"),
+		}
 	}
 
-	dockerGraph, err := graph.NewGraph(graphRoot, quotaedGraphDriver)
+	dockerGraph, err := graph.NewGraph(config.GraphRoot, quotaedGraphDriver)
 	if err != nil {
 		logger.Fatal("failed-to-construct-graph", err)
 	}
@@ -83,69 +97,239 @@ func Wire(
 		Driver: quotaedGraphDriver,
 	}
 
-	if cake.DriverName() == "aufs" {
+	switch cake.DriverName() {
+	case "aufs":
 		cake = &layercake.AufsCake{
 			Cake:      cake,
 			Runner:    runner,
-			GraphRoot: graphRoot,
+			GraphRoot: config.GraphRoot,
+		}
+	case "overlay":
+		cake = &layercake.OverlayCake{
+			Cake:      cake,
+			Runner:    runner,
+			GraphRoot: config.GraphRoot,
 		}
 	}
 
+	dialer := distclient.NewDialer(config.Registry.InsecureRegistries)
+	dialer.Mirrors = config.Registry.Mirrors
+	dialer.CertsDir = config.Registry.CertsDir
+	dialer.Platform, err = distclient.ParsePlatform(config.Registry.Platform)
+	if err != nil {
+		logger.Fatal("failed-to-parse-platform", err)
+	}
+
+	if config.Registry.DockerConfigPath != "" {
+		dockerConfig, err := distclient.LoadDockerConfig(config.Registry.DockerConfigPath, runner)
+		if err != nil {
+			logger.Fatal("failed-to-load-docker-config", err)
+		}
+		dialer.Keychain = dockerConfig
+	}
+
+	remoteFetcher := repository_fetcher.NewRemote(
+		config.Registry.DefaultRegistry,
+		cake,
+		dialer,
+		repository_fetcher.VerifyFunc(repository_fetcher.Verify),
+	)
+	if config.Registry.MaxConcurrentDownloads > 0 {
+		remoteFetcher.MaxConcurrentDownloads = config.Registry.MaxConcurrentDownloads
+	}
+
+	partialBlobsPath := filepath.Join(config.GraphRoot, "partial-blobs")
+	remoteFetcher.PartialBlobs, err = repository_fetcher.NewPartialBlobs(partialBlobsPath)
+	if err != nil {
+		logger.Fatal("failed-to-create-partial-blobs-directory", err)
+	}
+	remoteFetcher.PartialBlobs.Expire(logger)
+
+	remoteFetcher.Index, err = repository_fetcher.LoadImageIndex(filepath.Join(config.GraphRoot, "garden-info", "images.json"), clock.NewClock())
+	if err != nil {
+		logger.Fatal("failed-to-load-image-index", err)
+	}
+
+	remoteFetcher.PullPolicy, err = repository_fetcher.ParsePullPolicy(config.Registry.PullPolicy)
+	if err != nil {
+		logger.Fatal("failed-to-parse-pull-policy", err)
+	}
+
+	if config.Registry.TrustPolicyDir != "" {
+		remoteFetcher.TrustPolicy, err = repository_fetcher.LoadSignaturePolicy(config.Registry.TrustPolicyDir)
+		if err != nil {
+			logger.Fatal("failed-to-load-trust-policy", err)
+		}
+	}
+
+	// layouts are read again on every fetch, so they need neither an index
+	// nor partial downloads, but share the fetch lock with the registry since
+	// both register layers under their chain IDs
+	layoutFetcher := repository_fetcher.NewRemote(
+		"",
+		cake,
+		&distclient.LayoutDialer{Platform: dialer.Platform},
+		repository_fetcher.VerifyFunc(repository_fetcher.Verify),
+	)
+	layoutFetcher.FetchLock = remoteFetcher.FetchLock
+	layoutFetcher.Index = nil
+
+	// shared so that the retainer finds local rootfses under the digests the
+	// local fetcher already computed
+	var idProvider repository_fetcher.ContainerIDProvider = repository_fetcher.LayerIDProvider{Digests: repository_fetcher.NewFileDigests()}
+	if config.HashRootFSContents {
+		fileDigests, err := repository_fetcher.LoadFileDigests(filepath.Join(config.GraphRoot, "garden-info", "file-digests.json"))
+		if err != nil {
+			logger.Fatal("failed-to-load-file-digests", err)
+		}
+
+		idProvider = repository_fetcher.NewTreeIDProvider(logger.Session("tree-id-provider"), fileDigests)
+	}
+
 	repoFetcher := repository_fetcher.Retryable{
 		RepositoryFetcher: &repository_fetcher.CompositeFetcher{
 			LocalFetcher: &repository_fetcher.Local{
 				Cake:              cake,
-				DefaultRootFSPath: rootFS,
-				IDProvider:        repository_fetcher.LayerIDProvider{},
+				DefaultRootFSPath: config.RootFS,
+				IDProvider:        idProvider,
 			},
-			RemoteFetcher: repository_fetcher.NewRemote(
-				dockerRegistry,
-				cake,
-				distclient.NewDialer(insecureRegistries),
-				repository_fetcher.VerifyFunc(repository_fetcher.Verify),
-			),
+			RemoteFetcher: remoteFetcher,
+			LayoutFetcher: layoutFetcher,
 		},
+		Policy: repository_fetcher.DefaultRetryPolicy(),
 	}
 
 	rootFSNamespacer := &UidNamespacer{
 		Translator: NewUidTranslator(
-			uidMappings,
-			gidMappings,
+			config.UIDMappings,
+			config.GIDMappings,
 		),
 	}
 
+	// images can be retained by configuration, for as long as they are
+	// configured, or at runtime until they are released
 	retainer := cleaner.NewRetainer()
-	ovenCleaner := cleaner.NewOvenCleaner(retainer,
-		cleaner.NewThreshold(int64(cleanupThresholdInMegabytes)*1024*1024),
+	retainedImages, err := cleaner.LoadRetainedImages(filepath.Join(config.GraphRoot, "garden-info", "retained-images.json"), nil)
+	if err != nil {
+		logger.Fatal("failed-to-load-retained-images", err)
+	}
+
+	// configured directory images are retained in the background, since
+	// identifying them can mean hashing all of their contents, and GC waits
+	// for them before it checks anything
+	directoryImagesRetained := make(chan struct{})
+
+	ovenCleaner := cleaner.NewOvenCleaner(cleaner.CheckFunc(func(id layercake.ID) bool {
+		<-directoryImagesRetained
+		return retainer.Check(id) || retainedImages.Check(id)
+	}),
+		cleaner.NewThreshold(config.GC.threshold(), backingStoresPath, partialBlobsPath),
 	)
+	if lowWaterMark, ok := config.GC.lowWaterMark(); ok {
+		ovenCleaner.LowWaterMark = cleaner.NewLowWaterMark(lowWaterMark, backingStoresPath, partialBlobsPath)
+	}
+
+	// a free space trigger replaces the size based threshold, reacting to
+	// what is actually left on the disk of the graph
+	var diskThreshold *cleaner.DiskThreshold
+	if config.GC.TriggerFreeSpace != "" {
+		trigger, err := cleaner.ParseFreeSpace(config.GC.TriggerFreeSpace)
+		if err != nil {
+			logger.Fatal("failed-to-parse-cleanup-trigger-free-space", err)
+		}
+
+		target := trigger
+		if config.GC.TargetFreeSpace != "" {
+			if target, err = cleaner.ParseFreeSpace(config.GC.TargetFreeSpace); err != nil {
+				logger.Fatal("failed-to-parse-cleanup-target-free-space", err)
+			}
+		}
+
+		diskThreshold = cleaner.NewDiskThreshold(config.GraphRoot, trigger, target)
+		ovenCleaner.GraphCleanupThreshold = diskThreshold
+		ovenCleaner.LowWaterMark = diskThreshold
+	}
+
+	ovenCleaner.References = remoteFetcher.Index
+	ovenCleaner.LastUsed, err = cleaner.LoadLastUsed(filepath.Join(config.GraphRoot, "garden-info", "last-used.json"), clock.NewClock())
+	if err != nil {
+		logger.Fatal("failed-to-load-layer-usage", err)
+	}
 
 	imageRetainer := &repository_fetcher.ImageRetainer{
 		GraphRetainer:             retainer,
-		DirectoryRootfsIDProvider: repository_fetcher.LayerIDProvider{},
+		DirectoryRootfsIDProvider: idProvider,
 		DockerImageIDFetcher:      repoFetcher,
 
 		NamespaceCacheKey: rootFSNamespacer.CacheKey(),
 		Logger:            logger,
 	}
+	retainedImages.Resolver = imageRetainer
 
-	// spawn off in a go function to avoid blocking startup
-	// worst case is if an image is immediately created and deleted faster than
-	// we can retain it we'll garbage collect it when we shouldn't. This
-	// is an OK trade-off for not having garden startup block on dockerhub.
-	go imageRetainer.Retain(persistentImages)
+	// other configured images already in the graph are retained before
+	// startup carries on, going by local metadata such as what they resolved to when
+	// they were fetched, so that GC cannot remove them first. Only images
+	// which have never been fetched need the registry, which startup does not
+	// wait for; there is nothing of theirs to collect until they are fetched
+	// anyway.
+	localImageRetainer := *imageRetainer
+	localImageRetainer.DockerImageIDFetcher = repository_fetcher.FetchIDFunc(func(log lager.Logger, u *url.URL) (layercake.ID, error) {
+		if repository_fetcher.IsLayoutURL(u) {
+			return layoutFetcher.FetchID(log, u)
+		}
+
+		return remoteFetcher.LocalID(log, u)
+	})
+
+	var directoryImages, images []string
+	for _, image := range config.PersistentImages {
+		if repository_fetcher.IsDirectoryImage(image) {
+			directoryImages = append(directoryImages, image)
+		} else {
+			images = append(images, image)
+		}
+	}
+
+	go func() {
+		defer close(directoryImagesRetained)
+		imageRetainer.Retain(directoryImages)
+	}()
+
+	if unresolved := localImageRetainer.Retain(images); len(unresolved) > 0 {
+		go imageRetainer.Retain(unresolved)
+	}
 
 	layerCreator := NewLayerCreator(cake, SimpleVolumeCreator{}, rootFSNamespacer)
 
+	var baseSizer quota_manager.BaseSizer = quota_manager.NewAUFSBaseSizer(cake)
+	if cake.DriverName() == "overlay" {
+		baseSizer = quota_manager.NewOverlayBaseSizer(cake)
+	}
+
 	quotaManager := &quota_manager.AUFSQuotaManager{
-		BaseSizer: quota_manager.NewAUFSBaseSizer(cake),
+		BaseSizer: baseSizer,
 		DiffSizer: &quota_manager.AUFSDiffSizer{
 			AUFSDiffPathFinder: quotaedGraphDriver,
 		},
 	}
 
-	return NewCakeOrdinator(cake,
+	cakeOrdinator := NewCakeOrdinator(cake,
 		repoFetcher,
 		layerCreator,
 		NewMetricsAdapter(quotaManager.GetUsage, quotaedGraphDriver.GetMntPath),
-		ovenCleaner)
+		ovenCleaner,
+		ovenCleaner.LastUsed,
+		retainedImages)
+
+	if config.GC.BackgroundInterval > 0 {
+		backgroundGC := NewBackgroundGC(logger, cakeOrdinator, config.GC.BackgroundInterval)
+		if diskThreshold != nil {
+			backgroundGC.Pressure = diskThreshold
+			backgroundGC.Cake = cake
+		}
+
+		go backgroundGC.Run(nil)
+	}
+
+	return cakeOrdinator
 }
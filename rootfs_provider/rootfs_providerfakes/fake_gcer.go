@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"code.cloudfoundry.org/garden-shed/layercake"
+	"code.cloudfoundry.org/garden-shed/layercake/cleaner"
 	"code.cloudfoundry.org/garden-shed/rootfs_provider"
 	"code.cloudfoundry.org/lager"
 )
@@ -22,6 +23,49 @@ type FakeGCer struct {
 	gCReturnsOnCall map[int]struct {
 		result1 error
 	}
+	DryRunStub        func(log lager.Logger, cake layercake.Cake) (*cleaner.Report, error)
+	dryRunMutex       sync.RWMutex
+	dryRunArgsForCall []struct {
+		log  lager.Logger
+		cake layercake.Cake
+	}
+	dryRunReturns struct {
+		result1 *cleaner.Report
+		result2 error
+	}
+	dryRunReturnsOnCall map[int]struct {
+		result1 *cleaner.Report
+		result2 error
+	}
+	CandidatesStub        func(log lager.Logger, cake layercake.Cake) ([]layercake.ID, error)
+	candidatesMutex       sync.RWMutex
+	candidatesArgsForCall []struct {
+		log  lager.Logger
+		cake layercake.Cake
+	}
+	candidatesReturns struct {
+		result1 []layercake.ID
+		result2 error
+	}
+	candidatesReturnsOnCall map[int]struct {
+		result1 []layercake.ID
+		result2 error
+	}
+	RemoveChainStub        func(log lager.Logger, cake layercake.Cake, id layercake.ID) (int64, error)
+	removeChainMutex       sync.RWMutex
+	removeChainArgsForCall []struct {
+		log  lager.Logger
+		cake layercake.Cake
+		id   layercake.ID
+	}
+	removeChainReturns struct {
+		result1 int64
+		result2 error
+	}
+	removeChainReturnsOnCall map[int]struct {
+		result1 int64
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -75,11 +119,174 @@ func (fake *FakeGCer) GCReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeGCer) DryRun(log lager.Logger, cake layercake.Cake) (*cleaner.Report, error) {
+	fake.dryRunMutex.Lock()
+	ret, specificReturn := fake.dryRunReturnsOnCall[len(fake.dryRunArgsForCall)]
+	fake.dryRunArgsForCall = append(fake.dryRunArgsForCall, struct {
+		log  lager.Logger
+		cake layercake.Cake
+	}{log, cake})
+	fake.recordInvocation("DryRun", []interface{}{log, cake})
+	fake.dryRunMutex.Unlock()
+	if fake.DryRunStub != nil {
+		return fake.DryRunStub(log, cake)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.dryRunReturns.result1, fake.dryRunReturns.result2
+}
+
+func (fake *FakeGCer) DryRunCallCount() int {
+	fake.dryRunMutex.RLock()
+	defer fake.dryRunMutex.RUnlock()
+	return len(fake.dryRunArgsForCall)
+}
+
+func (fake *FakeGCer) DryRunArgsForCall(i int) (lager.Logger, layercake.Cake) {
+	fake.dryRunMutex.RLock()
+	defer fake.dryRunMutex.RUnlock()
+	return fake.dryRunArgsForCall[i].log, fake.dryRunArgsForCall[i].cake
+}
+
+func (fake *FakeGCer) DryRunReturns(result1 *cleaner.Report, result2 error) {
+	fake.DryRunStub = nil
+	fake.dryRunReturns = struct {
+		result1 *cleaner.Report
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGCer) DryRunReturnsOnCall(i int, result1 *cleaner.Report, result2 error) {
+	fake.DryRunStub = nil
+	if fake.dryRunReturnsOnCall == nil {
+		fake.dryRunReturnsOnCall = make(map[int]struct {
+			result1 *cleaner.Report
+			result2 error
+		})
+	}
+	fake.dryRunReturnsOnCall[i] = struct {
+		result1 *cleaner.Report
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGCer) Candidates(log lager.Logger, cake layercake.Cake) ([]layercake.ID, error) {
+	fake.candidatesMutex.Lock()
+	ret, specificReturn := fake.candidatesReturnsOnCall[len(fake.candidatesArgsForCall)]
+	fake.candidatesArgsForCall = append(fake.candidatesArgsForCall, struct {
+		log  lager.Logger
+		cake layercake.Cake
+	}{log, cake})
+	fake.recordInvocation("Candidates", []interface{}{log, cake})
+	fake.candidatesMutex.Unlock()
+	if fake.CandidatesStub != nil {
+		return fake.CandidatesStub(log, cake)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.candidatesReturns.result1, fake.candidatesReturns.result2
+}
+
+func (fake *FakeGCer) CandidatesCallCount() int {
+	fake.candidatesMutex.RLock()
+	defer fake.candidatesMutex.RUnlock()
+	return len(fake.candidatesArgsForCall)
+}
+
+func (fake *FakeGCer) CandidatesArgsForCall(i int) (lager.Logger, layercake.Cake) {
+	fake.candidatesMutex.RLock()
+	defer fake.candidatesMutex.RUnlock()
+	return fake.candidatesArgsForCall[i].log, fake.candidatesArgsForCall[i].cake
+}
+
+func (fake *FakeGCer) CandidatesReturns(result1 []layercake.ID, result2 error) {
+	fake.CandidatesStub = nil
+	fake.candidatesReturns = struct {
+		result1 []layercake.ID
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGCer) CandidatesReturnsOnCall(i int, result1 []layercake.ID, result2 error) {
+	fake.CandidatesStub = nil
+	if fake.candidatesReturnsOnCall == nil {
+		fake.candidatesReturnsOnCall = make(map[int]struct {
+			result1 []layercake.ID
+			result2 error
+		})
+	}
+	fake.candidatesReturnsOnCall[i] = struct {
+		result1 []layercake.ID
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGCer) RemoveChain(log lager.Logger, cake layercake.Cake, id layercake.ID) (int64, error) {
+	fake.removeChainMutex.Lock()
+	ret, specificReturn := fake.removeChainReturnsOnCall[len(fake.removeChainArgsForCall)]
+	fake.removeChainArgsForCall = append(fake.removeChainArgsForCall, struct {
+		log  lager.Logger
+		cake layercake.Cake
+		id   layercake.ID
+	}{log, cake, id})
+	fake.recordInvocation("RemoveChain", []interface{}{log, cake, id})
+	fake.removeChainMutex.Unlock()
+	if fake.RemoveChainStub != nil {
+		return fake.RemoveChainStub(log, cake, id)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.removeChainReturns.result1, fake.removeChainReturns.result2
+}
+
+func (fake *FakeGCer) RemoveChainCallCount() int {
+	fake.removeChainMutex.RLock()
+	defer fake.removeChainMutex.RUnlock()
+	return len(fake.removeChainArgsForCall)
+}
+
+func (fake *FakeGCer) RemoveChainArgsForCall(i int) (lager.Logger, layercake.Cake, layercake.ID) {
+	fake.removeChainMutex.RLock()
+	defer fake.removeChainMutex.RUnlock()
+	return fake.removeChainArgsForCall[i].log, fake.removeChainArgsForCall[i].cake, fake.removeChainArgsForCall[i].id
+}
+
+func (fake *FakeGCer) RemoveChainReturns(result1 int64, result2 error) {
+	fake.RemoveChainStub = nil
+	fake.removeChainReturns = struct {
+		result1 int64
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGCer) RemoveChainReturnsOnCall(i int, result1 int64, result2 error) {
+	fake.RemoveChainStub = nil
+	if fake.removeChainReturnsOnCall == nil {
+		fake.removeChainReturnsOnCall = make(map[int]struct {
+			result1 int64
+			result2 error
+		})
+	}
+	fake.removeChainReturnsOnCall[i] = struct {
+		result1 int64
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeGCer) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.gCMutex.RLock()
 	defer fake.gCMutex.RUnlock()
+	fake.dryRunMutex.RLock()
+	defer fake.dryRunMutex.RUnlock()
+	fake.candidatesMutex.RLock()
+	defer fake.candidatesMutex.RUnlock()
+	fake.removeChainMutex.RLock()
+	defer fake.removeChainMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value